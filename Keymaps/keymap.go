@@ -6,19 +6,57 @@ import "github.com/charmbracelet/bubbles/key"
 // is used to render the menu.
 type KeyMap struct {
 	// Keybindings used when browsing the list.
-	CursorRight key.Binding
-	CursorLeft  key.Binding
-	CursorUp    key.Binding
-	CursorDown  key.Binding
-	Filter      key.Binding
-	ClearFilter key.Binding
-	Edit        key.Binding
-	Enter       key.Binding
-	SpaceBar    key.Binding
+	CursorRight    key.Binding
+	CursorLeft     key.Binding
+	CursorUp       key.Binding
+	CursorDown     key.Binding
+	PageUp         key.Binding
+	PageDown       key.Binding
+	Home           key.Binding
+	End            key.Binding
+	Filter         key.Binding
+	ClearFilter    key.Binding
+	Edit           key.Binding
+	EditMetadata   key.Binding
+	Details        key.Binding
+	Enter          key.Binding
+	SpaceBar       key.Binding
+	ToggleJoin     key.Binding
+	SaveCollection key.Binding
+	FocusFacets    key.Binding
+	FullText       key.Binding
+	Sort           key.Binding
+	ReverseSort    key.Binding
+	NextPane       key.Binding
+	PrevPane       key.Binding
+	Gallery        key.Binding
+	Undo           key.Binding
+	Redo           key.Binding
+	ToggleMark     key.Binding
+	MarkRange      key.Binding
+	MarkAll        key.Binding
+	TagOrder       key.Binding
+	PinTag         key.Binding
+	MoveTagUp      key.Binding
+	MoveTagDown    key.Binding
+
+	// Keybindings used in forms.
+	NextField      key.Binding
+	PrevField      key.Binding
+	Save           key.Binding
+	ToggleOverride key.Binding
+
+	// Keybindings used when typing tags.
+	Complete       key.Binding
+	NextSuggestion key.Binding
+	PrevSuggestion key.Binding
 
 	// Keybindings used when setting a filter.
 	CancelWhileFiltering key.Binding
 	AcceptWhileFiltering key.Binding
+	HistoryPrev          key.Binding
+	HistoryNext          key.Binding
+	ToggleRegex          key.Binding
 
 	// Help toggle keybindings.
 	ShowFullHelp  key.Binding
@@ -58,6 +96,22 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("down", "j"),
 			key.WithHelp("↓/j", "down"),
 		),
+		PageUp: key.NewBinding(
+			key.WithKeys("pgup"),
+			key.WithHelp("pgup", "page up"),
+		),
+		PageDown: key.NewBinding(
+			key.WithKeys("pgdown"),
+			key.WithHelp("pgdn", "page down"),
+		),
+		Home: key.NewBinding(
+			key.WithKeys("home"),
+			key.WithHelp("home", "first book"),
+		),
+		End: key.NewBinding(
+			key.WithKeys("end"),
+			key.WithHelp("end", "last book"),
+		),
 		Filter: key.NewBinding(
 			key.WithKeys("/"),
 			key.WithHelp("/", "filter"),
@@ -66,18 +120,138 @@ func DefaultKeyMap() KeyMap {
 			key.WithKeys("e"),
 			key.WithHelp("e", "edit"),
 		),
+		EditMetadata: key.NewBinding(
+			key.WithKeys("m"),
+			key.WithHelp("m", "metadata"),
+		),
+		Details: key.NewBinding(
+			key.WithKeys("i"),
+			key.WithHelp("i", "details"),
+		),
+		NextField: key.NewBinding(
+			key.WithKeys("tab", "down"),
+			key.WithHelp("tab", "next field"),
+		),
+		PrevField: key.NewBinding(
+			key.WithKeys("shift+tab", "up"),
+			key.WithHelp("shift+tab", "previous field"),
+		),
+		Save: key.NewBinding(
+			key.WithKeys("ctrl+s"),
+			key.WithHelp("ctrl+s", "save"),
+		),
+		ToggleOverride: key.NewBinding(
+			key.WithKeys("ctrl+o"),
+			key.WithHelp("ctrl+o", "file/override"),
+		),
+		Complete: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "complete"),
+		),
+		NextSuggestion: key.NewBinding(
+			key.WithKeys("down", "ctrl+n"),
+			key.WithHelp("↓", "next completion"),
+		),
+		PrevSuggestion: key.NewBinding(
+			key.WithKeys("up", "ctrl+p"),
+			key.WithHelp("↑", "previous completion"),
+		),
 		Enter: key.NewBinding(
 			key.WithKeys("enter"),
 			key.WithHelp("enter", "open"),
 		),
 		SpaceBar: key.NewBinding(
 			key.WithKeys(" "),
-			key.WithHelp("SpaceBar", "selectTag"),
+			key.WithHelp("SpaceBar", "include/exclude tag"),
+		),
+		ToggleJoin: key.NewBinding(
+			key.WithKeys("o"),
+			key.WithHelp("o", "and/or"),
+		),
+		SaveCollection: key.NewBinding(
+			key.WithKeys("S"),
+			key.WithHelp("S", "save collection"),
+		),
+		NextPane: key.NewBinding(
+			key.WithKeys("tab"),
+			key.WithHelp("tab", "next pane"),
+		),
+		PrevPane: key.NewBinding(
+			key.WithKeys("shift+tab"),
+			key.WithHelp("shift+tab", "previous pane"),
+		),
+		Gallery: key.NewBinding(
+			key.WithKeys("v"),
+			key.WithHelp("v", "gallery/list"),
+		),
+		Undo: key.NewBinding(
+			key.WithKeys("u"),
+			key.WithHelp("u", "undo tags"),
+		),
+		Redo: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "redo tags"),
+		),
+		ToggleMark: key.NewBinding(
+			key.WithKeys("x"),
+			key.WithHelp("x", "mark book"),
+		),
+		MarkRange: key.NewBinding(
+			key.WithKeys("X"),
+			key.WithHelp("X", "mark range"),
+		),
+		MarkAll: key.NewBinding(
+			key.WithKeys("ctrl+a"),
+			key.WithHelp("ctrl+a", "mark all/none"),
+		),
+		TagOrder: key.NewBinding(
+			key.WithKeys("T"),
+			key.WithHelp("T", "tag order"),
+		),
+		PinTag: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "pin/unpin tag"),
+		),
+		MoveTagUp: key.NewBinding(
+			key.WithKeys("K"),
+			key.WithHelp("K", "move tag up"),
+		),
+		MoveTagDown: key.NewBinding(
+			key.WithKeys("J"),
+			key.WithHelp("J", "move tag down"),
+		),
+		FocusFacets: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "facets"),
+		),
+		FullText: key.NewBinding(
+			key.WithKeys("F"),
+			key.WithHelp("F", "full-text search"),
+		),
+		Sort: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "sort by"),
+		),
+		ReverseSort: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "reverse sort"),
 		),
 		CancelWhileFiltering: key.NewBinding(
 			key.WithKeys("esc"),
 			key.WithHelp("esc", "cancel"),
 		),
+		HistoryPrev: key.NewBinding(
+			key.WithKeys("up"),
+			key.WithHelp("↑", "previous search"),
+		),
+		HistoryNext: key.NewBinding(
+			key.WithKeys("down"),
+			key.WithHelp("↓", "next search"),
+		),
+		ToggleRegex: key.NewBinding(
+			key.WithKeys("ctrl+e"),
+			key.WithHelp("ctrl+e", "regex mode"),
+		),
 		Quit: key.NewBinding(
 			key.WithKeys("esc"),
 			key.WithHelp("esc", "quit"),
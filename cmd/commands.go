@@ -0,0 +1,302 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"Bonalioteko/config"
+	"Bonalioteko/fulltext"
+	"Bonalioteko/internal/walk"
+	"Bonalioteko/journal"
+	"Bonalioteko/metadata"
+	"Bonalioteko/search"
+	"Bonalioteko/xattr"
+)
+
+// runCommand runs the one-shot command given on the command line instead of
+// starting the TUI.
+func runCommand(args []string, cfg config.Config, out io.Writer) error {
+	ebookdir := cfg.Settings.EbookDir
+	switch args[0] {
+	case "import-calibre":
+		return importCalibre(args[1:], ebookdir, out)
+	case "reconcile":
+		return reconcile(args[1:], ebookdir, out)
+	case "collection":
+		return collection(args[1:], cfg, out)
+	case "index":
+		return index(args[1:], ebookdir, out)
+	case "fulltext":
+		return fullText(args[1:], ebookdir, out)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// importCalibre turns the Calibre tags, series and ratings of the library
+// into extended attributes.
+func importCalibre(args []string, ebookdir string, out io.Writer) error {
+	fs := flag.NewFlagSet("import-calibre", flag.ContinueOnError)
+	dryRun := fs.Bool("n", false, "only print what would be imported")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	root := ebookdir
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	var failed int
+	var changes []journal.Change
+	results := metadata.ImportCalibre(root, *dryRun)
+	for _, r := range results {
+		if r.TagsAfter != nil {
+			changes = append(changes, journal.Change{Path: r.Path, Before: r.TagsBefore, After: r.TagsAfter})
+		}
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(out, "error  %s: %v\n", r.Path, r.Err)
+			continue
+		}
+		fmt.Fprintf(out, "ok     %s: tags=[%s] series=%q rating=%q\n", r.Path, strings.Join(r.Tags, ","), r.Series, r.Rating)
+		if len(r.Rejected) > 0 {
+			fmt.Fprintf(out, "  tags with commas not imported: %q\n", r.Rejected)
+		}
+	}
+	fmt.Fprintf(out, "%d books imported, %d failed\n", len(results)-failed, failed)
+	if err := recordTagChanges(fmt.Sprintf("import the Calibre tags of %d books", len(changes)), changes); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d books could not be imported", failed)
+	}
+	return nil
+}
+
+// reconcile reports the books whose xattr tags and embedded dc:subject
+// elements disagree, and optionally copies one side over the other.
+func reconcile(args []string, ebookdir string, out io.Writer) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	to := fs.String("to", "", `fix mismatches by writing the xattr tags into the EPUB ("epub") or the embedded subjects into the xattrs ("xattr")`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to != "" && *to != "epub" && *to != "xattr" {
+		return fmt.Errorf(`-to must be "epub" or "xattr", got %q`, *to)
+	}
+	root := ebookdir
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	var failed int
+	var changes []journal.Change
+	mismatches := metadata.Reconcile(walk.Find(root, ".epub"))
+	for _, m := range mismatches {
+		if m.Err != nil {
+			failed++
+			fmt.Fprintf(out, "error  %s: %v\n", m.Path, m.Err)
+			continue
+		}
+		fmt.Fprintf(out, "%s\n  only in xattr: [%s]\n  only in epub:  [%s]\n", m.Path, strings.Join(m.OnlyXattr, ","), strings.Join(m.OnlyEmbedded, ","))
+
+		var err error
+		switch *to {
+		case "epub":
+			var tags []string
+			if tags, err = xattr.GetTagsFromPath(m.Path); err == nil {
+				err = metadata.WriteSubjects(m.Path, metadata.StoredTags(tags))
+			}
+		case "xattr":
+			var before, subjects []string
+			if before, err = xattr.GetTagsFromPath(m.Path); err == nil {
+				subjects, err = metadata.EmbeddedSubjects(m.Path)
+			}
+			if err == nil {
+				err = xattr.SetTags(m.Path, subjects)
+			}
+			var after []string
+			if err == nil {
+				after, err = xattr.GetTagsFromPath(m.Path)
+			}
+			if err == nil {
+				changes = append(changes, journal.Change{Path: m.Path, Before: before, After: after})
+			}
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "  fix failed: %v\n", err)
+		}
+	}
+	fmt.Fprintf(out, "%d books disagree\n", len(mismatches))
+	if err := recordTagChanges(fmt.Sprintf("copy the embedded subjects of %d books to their tags", len(changes)), changes); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d books could not be reconciled", failed)
+	}
+	return nil
+}
+
+// recordTagChanges records the changes made to the tags in the journal, for
+// them to be undone from the TUI like the ones made there.
+func recordTagChanges(action string, changes []journal.Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	path, err := journal.DefaultPath()
+	if err != nil {
+		return fmt.Errorf("tag journal: %w", err)
+	}
+	j, err := journal.Load(path)
+	if err != nil {
+		return fmt.Errorf("tag journal: %w", err)
+	}
+	j.Record(journal.Entry{Time: time.Now(), Action: action, Changes: changes})
+	if err := j.Save(); err != nil {
+		return fmt.Errorf("tag journal: %w", err)
+	}
+	return nil
+}
+
+// collection lists the saved collections, prints the books of one of them,
+// or saves a new one.
+func collection(args []string, cfg config.Config, out io.Writer) error {
+	fs := flag.NewFlagSet("collection", flag.ContinueOnError)
+	save := fs.Bool("save", false, "save the collection given by -query and -search")
+	tagQuery := fs.String("query", "", "tag query of the saved collection")
+	text := fs.String("search", "", "search of the saved collection")
+	order := fs.String("sort", "", "sort order of the saved collection, such as \"added desc\"")
+	titles := fs.Bool("t", false, "print titles instead of paths")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		for _, c := range cfg.Collections {
+			fmt.Fprintf(out, "%s\tquery=%q search=%q sort=%q\n", c.Name, c.Query, c.Search, c.Sort)
+		}
+		return nil
+	}
+	name := fs.Arg(0)
+
+	if *save {
+		col := config.Collection{Name: name, Query: *tagQuery, Search: *text, Sort: *order}
+		if err := search.Check(col.Query, col.Search); err != nil {
+			return err
+		}
+		if _, err := metadata.ParseSortOrder(col.Sort); err != nil {
+			return err
+		}
+		cfg.SaveCollection(col)
+		return config.WriteCollections(cfg.Collections)
+	}
+
+	col, ok := cfg.Collection(name)
+	if !ok {
+		return fmt.Errorf("no collection named %q", name)
+	}
+	root := cfg.Settings.EbookDir
+	library := metadata.Scan(root)
+	tags := xattr.GetXattrMapTagToFilePath(root)
+	if shadowed := search.AddVirtualTags(tags, library, time.Now()); len(shadowed) > 0 {
+		log.Printf("Warning: tags hidden by the virtual tags of the same name: %s", strings.Join(shadowed, ", "))
+	}
+	books, err := search.Select(library, tags, col.Query, col.Search)
+	if err != nil {
+		return err
+	}
+	if col.Sort != "" {
+		o, err := metadata.ParseSortOrder(col.Sort)
+		if err != nil {
+			return fmt.Errorf("collection %s: %w", col.Name, err)
+		}
+		metadata.SortBooks(books, o, metadata.Locale(cfg.Settings.Locale))
+	}
+	for _, b := range books {
+		if *titles {
+			fmt.Fprintln(out, b.Title)
+			continue
+		}
+		fmt.Fprintln(out, b.Path)
+	}
+	return nil
+}
+
+// index brings the full-text index up to date with the library.
+func index(args []string, ebookdir string, out io.Writer) error {
+	fs := flag.NewFlagSet("index", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	root := ebookdir
+	if fs.NArg() > 0 {
+		root = fs.Arg(0)
+	}
+
+	ix, err := updateIndex(root, out)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d books in the index\n", ix.Len())
+	return nil
+}
+
+// fullText searches the contents of the books, updating the index first.
+func fullText(args []string, ebookdir string, out io.Writer) error {
+	fs := flag.NewFlagSet("fulltext", flag.ContinueOnError)
+	limit := fs.Int("n", 10, "maximum number of books to print")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("fulltext: nothing to search for")
+	}
+
+	ix, err := updateIndex(ebookdir, io.Discard)
+	if err != nil {
+		return err
+	}
+	results, err := ix.Search(strings.Join(fs.Args(), " "), *limit)
+	for _, r := range results {
+		fmt.Fprintf(out, "%s\n  %s: %s\n", r.Path, r.Chapter, markHighlights(r.Snippet, r.Highlights))
+	}
+	return err
+}
+
+// updateIndex opens the full-text index, updates it for the books below
+// root, reporting the failures to out, and saves it.
+func updateIndex(root string, out io.Writer) (*fulltext.Index, error) {
+	path, err := fulltext.DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	ix, err := fulltext.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	res := ix.Update(walk.Find(root, ".epub"))
+	for _, err := range res.Failed {
+		fmt.Fprintf(out, "error  %v\n", err)
+	}
+	fmt.Fprintf(out, "%d indexed, %d removed, %d unchanged, %d failed\n", res.Indexed, res.Removed, res.Unchanged, len(res.Failed))
+	return ix, ix.Save()
+}
+
+// markHighlights wraps the highlighted ranges of s in square brackets.
+func markHighlights(s string, highlights [][2]int) string {
+	var b strings.Builder
+	last := 0
+	for _, h := range highlights {
+		b.WriteString(s[last:h[0]] + "[" + s[h[0]:h[1]] + "]")
+		last = h[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
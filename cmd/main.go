@@ -1,8 +1,8 @@
 package main
 
 import (
+	"Bonalioteko/config"
 	"Bonalioteko/models"
-	"Bonalioteko/xattr"
 	"fmt"
 	"log"
 	"os"
@@ -22,16 +22,29 @@ func main() {
 	}
 	f, err := tea.LogToFile("debug.log", "debug")
 	if err != nil {
-		log.Fatalf("err: %w", err)
+		log.Fatalf("err: %v", err)
 	}
 	defer f.Close()
 
-	Ebookdir, err := xattr.InitEbookdir()
+	cfg, err := config.ParseConfig()
 	if err != nil {
 		panic(fmt.Sprintf("Failed to initialize ebook directory: %v", err))
 	}
-	m := models.InitialModel(dump, Ebookdir)
-	p := tea.NewProgram(&m, tea.WithAltScreen())
+
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:], cfg, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	m := models.InitialModel(dump, cfg)
+	opts := []tea.ProgramOption{tea.WithAltScreen()}
+	if cfg.Settings.Mouse {
+		opts = append(opts, tea.WithMouseCellMotion())
+	}
+	p := tea.NewProgram(&m, opts...)
 
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Alas, there has been an error %v", err)
@@ -1,17 +1,21 @@
 package config
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+
+	"Bonalioteko/internal/atomicfile"
 
 	"gopkg.in/yaml.v3"
 )
 
 var homedir, _ = os.UserHomeDir()
-var ebookdir = filepath.Join(homedir,"Downloads/Ebooks/")
+var ebookdir = filepath.Join(homedir, "Downloads/Ebooks/")
 
 // AppDir is the name of the directory where the config file is stored.
 const AppDir = "Bonalioteko"
@@ -22,10 +26,63 @@ const ConfigFileName = "config.yml"
 // SettingsConfig struct represents the config for the settings.
 type SettingsConfig struct {
 	EbookDir string `yaml:"start_dir"`
+	// EmbedTags mirrors tags into the dc:subject elements of the EPUB.
+	EmbedTags bool `yaml:"embed_tags"`
+	// Sort is the order of the book list, such as "title" or "added desc".
+	Sort string `yaml:"sort,omitempty"`
+	// Locale is the language titles and authors are collated in, such as
+	// "fr" or "de-CH". It defaults to the one of the environment.
+	Locale string `yaml:"locale,omitempty"`
+	// Covers is how cover images are drawn: "kitty", "sixel", "blocks",
+	// "off", or "auto" to pick what the terminal supports.
+	Covers string `yaml:"covers,omitempty"`
+	// Mouse turns clicking and scrolling with the mouse on, which it is by
+	// default. It takes the selection of text over from the terminal, which
+	// then needs Shift held.
+	Mouse bool `yaml:"mouse"`
+	// TagOrder is the order of the tag bar: "alpha", "count" or "manual".
+	TagOrder string `yaml:"tag_order,omitempty"`
+	// PinnedTags are listed first in the tag bar, in this order.
+	PinnedTags []string `yaml:"pinned_tags,omitempty"`
+	// ManualTags is the order of the other tags in the manual tag order.
+	ManualTags []string `yaml:"manual_tags,omitempty"`
+}
+
+// Collection is a saved search: a tag query and the text typed in the
+// filter. Collections are listed at the front of the tag bar and evaluated
+// against the library every time they are opened.
+type Collection struct {
+	Name   string `yaml:"name"`
+	Query  string `yaml:"query,omitempty"`
+	Search string `yaml:"search,omitempty"`
+	// Sort orders the books of the collection instead of the sort setting.
+	Sort string `yaml:"sort,omitempty"`
 }
 
 type Config struct {
-	Settings SettingsConfig `yaml:"settings"`
+	Settings    SettingsConfig `yaml:"settings"`
+	Collections []Collection   `yaml:"collections,omitempty"`
+}
+
+// Collection returns the collection with the given name.
+func (c Config) Collection(name string) (Collection, bool) {
+	for _, col := range c.Collections {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return Collection{}, false
+}
+
+// SaveCollection adds the collection, replacing the one with the same name.
+func (c *Config) SaveCollection(col Collection) {
+	for i := range c.Collections {
+		if c.Collections[i].Name == col.Name {
+			c.Collections[i] = col
+			return
+		}
+	}
+	c.Collections = append(c.Collections, col)
 }
 
 // configError represents an error that occurred while parsing the config file.
@@ -43,6 +100,7 @@ func (parser ConfigParser) getDefaultConfig() Config {
 	return Config{
 		Settings: SettingsConfig{
 			EbookDir: ebookdir,
+			Mouse:    true,
 		},
 	}
 }
@@ -169,3 +227,107 @@ func ParseConfig() (Config, error) {
 
 	return config, nil
 }
+
+// CacheDir returns the directory holding the files Bonalioteko can rebuild,
+// such as the full-text index.
+func CacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, AppDir), nil
+}
+
+// StateDir returns the directory holding what Bonalioteko remembers between
+// runs but isn't worth backing up, such as the search history. It is
+// $XDG_STATE_HOME/Bonalioteko, or ~/.local/state/Bonalioteko.
+func StateDir() (string, error) {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateDir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(stateDir, AppDir), nil
+}
+
+// WriteCollections replaces the collections of the config file. The rest of
+// the file is kept as it is, comments included.
+func WriteCollections(collections []Collection) error {
+	parser := initParser()
+
+	configFilePath, err := parser.getConfigFileOrCreateIfMissing()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(*configFilePath)
+	if err != nil {
+		return err
+	}
+	data, err = setCollections(data, collections)
+	if err != nil {
+		return parsingError{err: err}
+	}
+	return atomicfile.Write(*configFilePath, data, 0o644)
+}
+
+// setCollections replaces the collections key of the YAML document data,
+// adding it when missing, and leaves the other nodes as they are, indented
+// like they were.
+func setCollections(data []byte, collections []Collection) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("the config is not a mapping")
+	}
+
+	var value yaml.Node
+	if err := value.Encode(collections); err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "collections" {
+			value.HeadComment = root.Content[i+1].HeadComment
+			value.LineComment = root.Content[i+1].LineComment
+			root.Content[i+1] = &value
+			return encode(&doc, indentation(data))
+		}
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "collections"}
+	root.Content = append(root.Content, key, &value)
+	return encode(&doc, indentation(data))
+}
+
+// encode returns the YAML of the node, indented by indent spaces.
+func encode(node *yaml.Node, indent int) ([]byte, error) {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(indent)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// indentation returns the number of spaces the first indented line of the
+// YAML document data starts with, or 4, the default of the encoder.
+func indentation(data []byte) int {
+	for line := range strings.Lines(string(data)) {
+		trimmed := strings.TrimLeft(line, " ")
+		if n := len(line) - len(trimmed); n > 0 && strings.TrimSpace(trimmed) != "" && !strings.HasPrefix(trimmed, "#") {
+			return n
+		}
+	}
+	return 4
+}
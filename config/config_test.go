@@ -0,0 +1,96 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"Bonalioteko/config"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriteCollections(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	path := filepath.Join(dir, config.AppDir, config.ConfigFileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	written := `# My library.
+settings:
+  start_dir: /books # on the NAS
+  embed_tags: true
+`
+	if err := os.WriteFile(path, []byte(written), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cols := []config.Collection{{Name: "daily", Query: "unread and philosophy", Sort: "added desc"}}
+	if err := config.WriteCollections(cols); err != nil {
+		t.Fatalf("WriteCollections: got error:%s", err)
+	}
+	cols = append(cols, config.Collection{Name: "french", Search: "lang:fr"})
+	if err := config.WriteCollections(cols); err != nil {
+		t.Fatalf("WriteCollections: got error:%s", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# My library.
+settings:
+  start_dir: /books # on the NAS
+  embed_tags: true
+collections:
+  - name: daily
+    query: unread and philosophy
+    sort: added desc
+  - name: french
+    search: lang:fr
+`
+	if diff := cmp.Diff(want, string(data)); diff != "" {
+		t.Errorf("config file: mismatch (-want +got):\n%s", diff)
+	}
+	cfg, err := config.ParseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(cols, cfg.Collections); diff != "" {
+		t.Errorf("ParseConfig: collections mismatch (-want +got):\n%s", diff)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("config file mode: got %v, %v; want it kept", info.Mode(), err)
+	}
+}
+
+func TestViewState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", config.ViewStateFileName)
+	s, err := config.LoadViewState(path)
+	if err != nil {
+		t.Fatalf("LoadViewState of a missing file: got error:%s", err)
+	}
+	settings := config.SettingsConfig{Sort: "title", TagOrder: "count", PinnedTags: []string{"unread"}}
+	s.Apply(&settings)
+	if settings.Sort != "title" || settings.TagOrder != "count" {
+		t.Errorf("Apply of an empty state: got %+v", settings)
+	}
+
+	// Unpinning every tag is kept, rather than falling back to the settings.
+	settings.Sort = "added desc"
+	settings.PinnedTags = nil
+	if err := config.ViewStateOf(settings).Save(path); err != nil {
+		t.Fatalf("Save: got error:%s", err)
+	}
+	s, err = config.LoadViewState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := config.SettingsConfig{Sort: "title", PinnedTags: []string{"unread"}}
+	s.Apply(&got)
+	want := config.SettingsConfig{Sort: "added desc", TagOrder: "count", PinnedTags: []string{}, ManualTags: []string{}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Apply: mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"Bonalioteko/internal/atomicfile"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ViewStateFileName is the name of the file keeping the state of the views
+// in the state directory.
+const ViewStateFileName = "view_state.yml"
+
+// ViewState is what the keys of the views change and is kept across runs:
+// the order of the book list and of the tag bar, and the pinned tags. It is
+// kept in the state directory rather than in the config file, which is left
+// as the user wrote it. The fields set override the settings of the same
+// name; unset lists are nil, while lists emptied in the views are not.
+type ViewState struct {
+	Sort       string   `yaml:"sort,omitempty"`
+	TagOrder   string   `yaml:"tag_order,omitempty"`
+	PinnedTags []string `yaml:"pinned_tags"`
+	ManualTags []string `yaml:"manual_tags"`
+}
+
+// ViewStatePath returns the location of the view state in the state
+// directory.
+func ViewStatePath() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ViewStateFileName), nil
+}
+
+// LoadViewState reads the view state stored at path. A missing file is an
+// empty state.
+func LoadViewState(path string) (ViewState, error) {
+	var s ViewState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	err = yaml.Unmarshal(data, &s)
+	return s, err
+}
+
+// Apply overrides the settings with the fields of the state that are set.
+func (s ViewState) Apply(settings *SettingsConfig) {
+	if s.Sort != "" {
+		settings.Sort = s.Sort
+	}
+	if s.TagOrder != "" {
+		settings.TagOrder = s.TagOrder
+	}
+	if s.PinnedTags != nil {
+		settings.PinnedTags = s.PinnedTags
+	}
+	if s.ManualTags != nil {
+		settings.ManualTags = s.ManualTags
+	}
+}
+
+// ViewStateOf returns the state of the views held in the settings.
+func ViewStateOf(settings SettingsConfig) ViewState {
+	s := ViewState{
+		Sort:       settings.Sort,
+		TagOrder:   settings.TagOrder,
+		PinnedTags: settings.PinnedTags,
+		ManualTags: settings.ManualTags,
+	}
+	if s.PinnedTags == nil {
+		s.PinnedTags = []string{}
+	}
+	if s.ManualTags == nil {
+		s.ManualTags = []string{}
+	}
+	return s
+}
+
+// Save writes the view state to path.
+func (s ViewState) Save(path string) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(path, data, 0o644)
+}
@@ -0,0 +1,104 @@
+package cover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"Bonalioteko/config"
+	"Bonalioteko/internal/atomicfile"
+	"Bonalioteko/metadata"
+)
+
+// CacheDirName is the name of the thumbnail directory in the cache
+// directory.
+const CacheDirName = "covers"
+
+// ThumbnailSize is the size thumbnails are shrunk to fit in, in pixels.
+const ThumbnailSize = 400
+
+// Cache keeps the thumbnails of the covers, a file per book named after its
+// path. The file starts with a line holding the size and modification time
+// of the book, followed by the thumbnail as a PNG, or by nothing when the
+// book has no cover. A book that changed gets a new thumbnail in place of
+// the old one.
+type Cache struct {
+	dir string
+}
+
+// NewCache returns a cache keeping its thumbnails in dir.
+func NewCache(dir string) Cache {
+	return Cache{dir: dir}
+}
+
+// DefaultCache returns the cache in the cache directory.
+func DefaultCache() (Cache, error) {
+	dir, err := config.CacheDir()
+	if err != nil {
+		return Cache{}, err
+	}
+	return NewCache(filepath.Join(dir, CacheDirName)), nil
+}
+
+// Thumbnail returns the cover of the EPUB at path shrunk to ThumbnailSize,
+// making and caching it the first time. It returns metadata.ErrNoCover for
+// books without a cover, which is remembered too.
+func (c Cache) Thumbnail(path string) (image.Image, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	thumbPath := filepath.Join(c.dir, cacheKey(path)+".thumb")
+	stamp := fmt.Sprintf("%d %d\n", info.Size(), info.ModTime().UnixNano())
+
+	if cached, err := os.ReadFile(thumbPath); err == nil {
+		if data, ok := bytes.CutPrefix(cached, []byte(stamp)); ok {
+			if len(data) == 0 {
+				return nil, metadata.ErrNoCover
+			}
+			if img, err := png.Decode(bytes.NewReader(data)); err == nil {
+				return img, nil
+			}
+		}
+	}
+
+	data, _, err := metadata.ReadCover(path)
+	if errors.Is(err, metadata.ErrNoCover) {
+		c.write(thumbPath, []byte(stamp))
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("cover of %s: %w", path, err)
+	}
+
+	thumb := Shrink(img, ThumbnailSize)
+	buf := bytes.NewBufferString(stamp)
+	if err := png.Encode(buf, thumb); err == nil {
+		c.write(thumbPath, buf.Bytes())
+	}
+	return thumb, nil
+}
+
+// cacheKey names the thumbnail of the file at path.
+func cacheKey(path string) string {
+	h := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(h[:16])
+}
+
+// write stores a file of the cache. The cache only saves work, so failing to
+// write it is left unreported: the thumbnail is made again next time.
+func (c Cache) write(path string, data []byte) {
+	atomicfile.Write(path, data, 0o644)
+}
@@ -0,0 +1,108 @@
+// Package cover renders the cover images of books in the terminal, with the
+// kitty graphics protocol, sixel, or coloured Unicode half blocks, and keeps
+// scaled down copies of them in the cache directory.
+package cover
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Protocol is a way of drawing images in a terminal.
+type Protocol int
+
+const (
+	// Off draws no image.
+	Off Protocol = iota
+	// Blocks draws two pixels per cell with the upper half block and
+	// 24-bit colours, which most terminals support.
+	Blocks
+	// Kitty transmits the image with the kitty graphics protocol.
+	Kitty
+	// Sixel draws the image with DEC sixel graphics.
+	Sixel
+)
+
+var protocolNames = map[Protocol]string{
+	Off:    "off",
+	Blocks: "blocks",
+	Kitty:  "kitty",
+	Sixel:  "sixel",
+}
+
+func (p Protocol) String() string {
+	return protocolNames[p]
+}
+
+// ParseProtocol returns the protocol named s: "kitty", "sixel", "blocks" or
+// "off". "auto", or nothing, detects the one the terminal supports from the
+// environment read with getenv.
+func ParseProtocol(s string, getenv func(string) string) (Protocol, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "auto" {
+		return Detect(getenv), nil
+	}
+	for p, name := range protocolNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return Off, fmt.Errorf("unknown cover protocol %q: want auto, kitty, sixel, blocks or off", s)
+}
+
+// Detect guesses the best protocol the terminal supports from the
+// environment read with getenv.
+func Detect(getenv func(string) string) Protocol {
+	term, program := getenv("TERM"), getenv("TERM_PROGRAM")
+	switch {
+	case getenv("TMUX") != "" || strings.HasPrefix(term, "screen"):
+		// Multiplexers only pass images on wrapped in their own sequences.
+		return Blocks
+	case getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" ||
+		program == "ghostty" || program == "WezTerm":
+		return Kitty
+	case strings.Contains(term, "sixel") || strings.HasPrefix(term, "foot") || term == "mlterm" ||
+		program == "mlterm" || program == "iTerm.app":
+		return Sixel
+	}
+	return Blocks
+}
+
+// Cell is the size of a character cell of the terminal in pixels.
+type Cell struct {
+	Width, Height int
+}
+
+// DefaultCell is the cell size assumed when the terminal doesn't tell.
+var DefaultCell = Cell{Width: 10, Height: 20}
+
+// TerminalCell returns the size of the cells of the terminal on the
+// standard output, or DefaultCell when it doesn't report its size in pixels.
+func TerminalCell() Cell {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 || ws.Xpixel == 0 || ws.Ypixel == 0 {
+		return DefaultCell
+	}
+	return Cell{Width: int(ws.Xpixel / ws.Col), Height: int(ws.Ypixel / ws.Row)}
+}
+
+// Fit returns the largest number of columns and rows, up to maxCols and
+// maxRows, that show an image of the given size without distorting it.
+func Fit(size image.Point, cell Cell, maxCols, maxRows int) (cols, rows int) {
+	if size.X <= 0 || size.Y <= 0 || maxCols <= 0 || maxRows <= 0 {
+		return 0, 0
+	}
+	// The width of the image over its height, measured in cells.
+	ratio := float64(size.X*cell.Height) / float64(size.Y*cell.Width)
+	rows = maxRows
+	cols = int(float64(rows)*ratio + 0.5)
+	if cols > maxCols {
+		cols = maxCols
+		rows = int(float64(cols)/ratio + 0.5)
+	}
+	return max(1, cols), max(1, rows)
+}
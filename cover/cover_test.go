@@ -0,0 +1,211 @@
+package cover_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Bonalioteko/cover"
+	"Bonalioteko/internal/epubtest"
+	"Bonalioteko/metadata"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseProtocol(t *testing.T) {
+	testCases := []struct {
+		setting string
+		env     map[string]string
+		want    cover.Protocol
+	}{
+		{"kitty", nil, cover.Kitty},
+		{" Sixel ", nil, cover.Sixel},
+		{"off", map[string]string{"TERM": "xterm-kitty"}, cover.Off},
+		{"", map[string]string{"TERM": "xterm-kitty"}, cover.Kitty},
+		{"auto", map[string]string{"TERM_PROGRAM": "WezTerm"}, cover.Kitty},
+		{"auto", map[string]string{"TERM": "foot"}, cover.Sixel},
+		{"auto", map[string]string{"TERM": "xterm-kitty", "TMUX": "/tmp/tmux"}, cover.Blocks},
+		{"auto", map[string]string{"TERM": "xterm-256color"}, cover.Blocks},
+	}
+	for _, tc := range testCases {
+		got, err := cover.ParseProtocol(tc.setting, func(k string) string { return tc.env[k] })
+		if err != nil {
+			t.Errorf("ParseProtocol(%q, %v): %v", tc.setting, tc.env, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParseProtocol(%q, %v): want %v, got %v", tc.setting, tc.env, tc.want, got)
+		}
+	}
+	if _, err := cover.ParseProtocol("ascii", os.Getenv); err == nil {
+		t.Error("ParseProtocol(ascii): want an error")
+	}
+}
+
+func TestFit(t *testing.T) {
+	cell := cover.Cell{Width: 10, Height: 20}
+	testCases := []struct {
+		size             image.Point
+		maxCols, maxRows int
+		cols, rows       int
+	}{
+		// A 2:3 cover is 4 cells wide for every 3 rows.
+		{image.Pt(200, 300), 40, 15, 20, 15},
+		{image.Pt(200, 300), 8, 15, 8, 6},
+		{image.Pt(300, 100), 30, 10, 30, 5},
+		{image.Pt(0, 100), 30, 10, 0, 0},
+	}
+	for _, tc := range testCases {
+		cols, rows := cover.Fit(tc.size, cell, tc.maxCols, tc.maxRows)
+		if cols != tc.cols || rows != tc.rows {
+			t.Errorf("Fit(%v, %d, %d): want %dx%d, got %dx%d", tc.size, tc.maxCols, tc.maxRows, tc.cols, tc.rows, cols, rows)
+		}
+	}
+}
+
+// testImage returns a width×height image, red on the left half and blue on
+// the right one.
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestResize(t *testing.T) {
+	small := cover.Resize(testImage(40, 60), 4, 3)
+	if got := small.Bounds().Size(); got != image.Pt(4, 3) {
+		t.Fatalf("want 4x3, got %v", got)
+	}
+	if got := small.RGBAAt(0, 0); got != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("left pixel: got %v", got)
+	}
+	if got := small.RGBAAt(3, 2); got != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("right pixel: got %v", got)
+	}
+
+	shrunk := cover.Shrink(testImage(800, 1200), 400)
+	if got := shrunk.Bounds().Size(); got != image.Pt(266, 400) {
+		t.Errorf("Shrink: want 266x400, got %v", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	img := testImage(20, 30)
+	cell := cover.Cell{Width: 4, Height: 8}
+	for _, p := range []cover.Protocol{cover.Off, cover.Blocks, cover.Kitty, cover.Sixel} {
+		lines := cover.Render(img, p, cell, 6, 4, 7)
+		if len(lines) != 4 {
+			t.Errorf("%v: want 4 lines, got %d", p, len(lines))
+			continue
+		}
+		for i, line := range lines {
+			if w := ansi.StringWidth(line); w != 6 {
+				t.Errorf("%v: line %d is %d cells wide, want 6", p, i, w)
+			}
+		}
+		var want string
+		switch p {
+		case cover.Blocks:
+			want = "\x1b[38;2;255;0;0m\x1b[48;2;255;0;0m▀"
+		case cover.Kitty:
+			want = "\x1b_Ga=T,f=100,i=7,c=6,r=4,"
+		case cover.Sixel:
+			want = "\x1bP0;1;0q\"1;1;24;8"
+		}
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("%v: want %q in %q", p, want, lines[0])
+		}
+	}
+}
+
+// writeEpub writes an EPUB whose cover is the PNG encoding of img, or
+// without cover when img is nil.
+func writeEpub(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	item := ""
+	files := make(map[string]string)
+	if img != nil {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		files["cover.png"] = buf.String()
+		item = `<item id="c" href="cover.png" media-type="image/png" properties="cover-image"/>`
+	}
+	files["content.opf"] = `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>T</dc:title></metadata>
+  <manifest>` + item + `</manifest>
+</package>`
+	epubtest.Write(t, path, "content.opf", files)
+}
+
+func TestCache_Thumbnail(t *testing.T) {
+	dir, cacheDir := t.TempDir(), t.TempDir()
+	cache := cover.NewCache(cacheDir)
+
+	book := filepath.Join(dir, "book.epub")
+	writeEpub(t, book, testImage(600, 900))
+	thumb, err := cache.Thumbnail(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := thumb.Bounds().Size(); got != image.Pt(266, 400) {
+		t.Errorf("want a 266x400 thumbnail, got %v", got)
+	}
+	cached, _ := filepath.Glob(filepath.Join(cacheDir, "*"))
+	if len(cached) != 1 {
+		t.Fatalf("want 1 cached thumbnail, got %v", cached)
+	}
+
+	// The cached thumbnail is read back as long as the book is unchanged,
+	// and replaced once it changed.
+	if err := os.Remove(book); err != nil {
+		t.Fatal(err)
+	}
+	writeEpub(t, book, testImage(10, 10))
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(book, stamp, stamp); err != nil {
+		t.Fatal(err)
+	}
+	thumb, err = cache.Thumbnail(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(image.Pt(10, 10), thumb.Bounds().Size()); diff != "" {
+		t.Errorf("changed book (-want +got):\n%s", diff)
+	}
+	if replaced, _ := filepath.Glob(filepath.Join(cacheDir, "*")); !cmp.Equal(cached, replaced) {
+		t.Errorf("changed book: want its thumbnail replaced, got %v", replaced)
+	}
+	if thumb, err = cache.Thumbnail(book); err != nil || thumb.Bounds().Size() != image.Pt(10, 10) {
+		t.Errorf("changed book read back: got %v, %v", thumb, err)
+	}
+
+	noCover := filepath.Join(dir, "none.epub")
+	writeEpub(t, noCover, nil)
+	for range 2 {
+		if _, err := cache.Thumbnail(noCover); !errors.Is(err, metadata.ErrNoCover) {
+			t.Errorf("without cover: want ErrNoCover, got %v", err)
+		}
+	}
+	if all, _ := filepath.Glob(filepath.Join(cacheDir, "*")); len(all) != 2 {
+		t.Errorf("want the missing cover remembered, got %v", all)
+	}
+}
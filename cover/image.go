@@ -0,0 +1,53 @@
+package cover
+
+import (
+	"image"
+	"image/color"
+)
+
+// Resize scales img to width×height, averaging the pixels each new pixel
+// covers.
+func Resize(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := img.Bounds()
+	if b.Empty() {
+		return dst
+	}
+	for y := range height {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/height)
+		for x := range width {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/width)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// Shrink scales img down to fit in size×size, keeping its proportions.
+// Smaller images are returned as they are.
+func Shrink(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= size && b.Dy() <= size {
+		return img
+	}
+	if b.Dx() >= b.Dy() {
+		return Resize(img, size, max(1, b.Dy()*size/b.Dx()))
+	}
+	return Resize(img, max(1, b.Dx()*size/b.Dy()), size)
+}
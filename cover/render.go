@@ -0,0 +1,202 @@
+package cover
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+	"strings"
+)
+
+// kittyChunk is the largest payload of a kitty graphics escape.
+const kittyChunk = 4096
+
+// Render draws img in cols×rows cells with the protocol. It returns rows
+// lines of cols cells, which the escapes of the image don't count in, so
+// that they can be laid out like text. id tells the kitty images of a screen
+// apart: drawing an image replaces the one with the same id.
+func Render(img image.Image, p Protocol, cell Cell, cols, rows, id int) []string {
+	if cols <= 0 || rows <= 0 {
+		return nil
+	}
+	switch p {
+	case Blocks:
+		return halfBlocks(img, cols, rows)
+	case Kitty:
+		return kitty(img, cols, rows, id)
+	case Sixel:
+		return sixel(img, cell, cols, rows)
+	}
+	return blank(cols, rows)
+}
+
+// Clear returns the escape removing the kitty image id from the screen, for
+// when it isn't drawn anymore. Other protocols draw over text and go away
+// with it.
+func Clear(p Protocol, id int) string {
+	if p != Kitty {
+		return ""
+	}
+	return fmt.Sprintf("\x1b_Ga=d,d=I,i=%d,q=2\x1b\\", id)
+}
+
+// blank returns rows lines of cols spaces.
+func blank(cols, rows int) []string {
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = strings.Repeat(" ", cols)
+	}
+	return lines
+}
+
+// halfBlocks draws every cell as the upper half block, coloured with the
+// upper pixel over the lower one.
+func halfBlocks(img image.Image, cols, rows int) []string {
+	small := Resize(img, cols, rows*2)
+	lines := make([]string, rows)
+	for y := range lines {
+		var b strings.Builder
+		for x := range cols {
+			top, bottom := small.RGBAAt(x, 2*y), small.RGBAAt(x, 2*y+1)
+			fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		b.WriteString("\x1b[0m")
+		lines[y] = b.String()
+	}
+	return lines
+}
+
+// kitty transmits the image as PNG and places it over the blank cells. The
+// image stays until it is replaced or cleared, as the terminal keeps it
+// apart from the text.
+func kitty(img image.Image, cols, rows, id int) []string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return blank(cols, rows)
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	var b strings.Builder
+	b.WriteString(Clear(Kitty, id))
+	for i := 0; i < len(data); i += kittyChunk {
+		chunk := data[i:min(len(data), i+kittyChunk)]
+		more := 0
+		if i+kittyChunk < len(data) {
+			more = 1
+		}
+		if i == 0 {
+			// C=1 leaves the cursor where it is, q=2 silences the replies.
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,i=%d,c=%d,r=%d,C=1,q=2,m=%d;%s\x1b\\", id, cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+
+	lines := blank(cols, rows)
+	lines[0] = b.String() + lines[0]
+	return lines
+}
+
+// sixel draws every row of cells as its own sixel image, so that the terminal
+// redrawing a line of text draws its part of the image again. Each strip is
+// drawn after the blank cells it goes over, with the cursor moved back to
+// them and restored after.
+func sixel(img image.Image, cell Cell, cols, rows int) []string {
+	width, height := cols*cell.Width, rows*cell.Height
+	small := Resize(img, width, height)
+
+	lines := blank(cols, rows)
+	for y := range lines {
+		strip := small.SubImage(image.Rect(0, y*cell.Height, width, (y+1)*cell.Height)).(*image.RGBA)
+		lines[y] += fmt.Sprintf("\x1b[%dD\x1b7%s\x1b8\x1b[%dC", cols, encodeSixel(strip), cols)
+	}
+	return lines
+}
+
+// sixelLevels is the number of levels of every primary of the sixel palette.
+const sixelLevels = 6
+
+// encodeSixel encodes the image as a sixel sequence, with its colours
+// reduced to a 6×6×6 cube.
+func encodeSixel(img *image.RGBA) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	index := func(c color.RGBA) int {
+		level := func(v uint8) int { return (int(v)*(sixelLevels-1) + 127) / 255 }
+		return (level(c.R)*sixelLevels+level(c.G))*sixelLevels + level(c.B)
+	}
+
+	pixels := make([]int, width*height)
+	used := make(map[int]bool)
+	for y := range height {
+		for x := range width {
+			i := index(img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y))
+			pixels[y*width+x] = i
+			used[i] = true
+		}
+	}
+
+	var b strings.Builder
+	// P2=1 leaves the pixels no colour is drawn in alone.
+	fmt.Fprintf(&b, "\x1bP0;1;0q\"1;1;%d;%d", width, height)
+	colors := make([]int, 0, len(used))
+	for c := range used {
+		colors = append(colors, c)
+	}
+	slices.Sort(colors)
+	for _, c := range colors {
+		r, g, bl := c/(sixelLevels*sixelLevels), c/sixelLevels%sixelLevels, c%sixelLevels
+		percent := func(level int) int { return level * 100 / (sixelLevels - 1) }
+		fmt.Fprintf(&b, "#%d;2;%d;%d;%d", c, percent(r), percent(g), percent(bl))
+	}
+
+	for band := 0; band < height; band += 6 {
+		first := true
+		for _, c := range colors {
+			var row []byte
+			drawn := false
+			for x := range width {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < height; dy++ {
+					if pixels[(band+dy)*width+x] == c {
+						bits |= 1 << dy
+					}
+				}
+				drawn = drawn || bits != 0
+				row = append(row, '?'+bits)
+			}
+			if !drawn {
+				continue
+			}
+			if !first {
+				// Go back to the start of the band for the next colour.
+				b.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&b, "#%d", c)
+			writeRuns(&b, row)
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeRuns writes the sixels of a row, repeating runs with the ! prefix.
+func writeRuns(b *strings.Builder, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(b, "!%d%c", n, row[i])
+		} else {
+			b.Write(row[i:j])
+		}
+		i = j
+	}
+}
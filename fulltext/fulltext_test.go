@@ -0,0 +1,163 @@
+package fulltext_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Bonalioteko/fulltext"
+	"Bonalioteko/internal/epubtest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const packageOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Test</dc:title></metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ch1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="text/ch2.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="ch1"/>
+    <itemref idref="ch2"/>
+  </spine>
+</package>`
+
+const navXHTML = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="toc"><ol><li><a href="text/ch1.xhtml">The Storm</a></li></ol></nav>
+</body></html>`
+
+// writeBook writes an EPUB with two chapters to dir/name.
+func writeBook(t *testing.T, dir, name, ch1, ch2 string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	epubtest.Write(t, path, "OEBPS/content.opf", map[string]string{
+		"OEBPS/content.opf":    packageOPF,
+		"OEBPS/nav.xhtml":      navXHTML,
+		"OEBPS/text/ch1.xhtml": "<html><body><h1>One</h1><p>" + ch1 + "</p></body></html>",
+		"OEBPS/text/ch2.xhtml": "<html><head><title>ignored</title></head><body><h2>Second <i>part</i></h2><p>" + ch2 + "</p></body></html>",
+	})
+	return path
+}
+
+func TestSearch(t *testing.T) {
+	dir := t.TempDir()
+	storm := writeBook(t, dir, "storm.epub",
+		"It was a dark and stormy night; the rain fell in torrents.",
+		"The whales were swimming. The whale swims on.")
+	whale := writeBook(t, dir, "whale.epub",
+		"Call me Ishmael.",
+		"A whale, a <b>whale</b>! The whales are hunted by whalers who hunt the whale.")
+
+	ix, err := fulltext.Open(filepath.Join(dir, "cache", fulltext.IndexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := ix.Update([]string{storm, whale}); res.Indexed != 2 || len(res.Failed) != 0 {
+		t.Fatalf("Update: got %+v", res)
+	}
+
+	results, err := ix.Search("whales", 0)
+	if err != nil {
+		t.Fatalf("Search: got error:%s", err)
+	}
+	var paths []string
+	for _, r := range results {
+		paths = append(paths, r.Path)
+	}
+	if diff := cmp.Diff([]string{whale, storm}, paths); diff != "" {
+		t.Errorf("Search(whales): mismatch (-want +got):\n%s", diff)
+	}
+	if results[0].Chapter != "Second part" {
+		t.Errorf("Chapter: want the heading %q, got %q", "Second part", results[0].Chapter)
+	}
+
+	results, _ = ix.Search("stormy night", 0)
+	if len(results) != 1 || results[0].Chapter != "The Storm" {
+		t.Fatalf("Search(stormy night): got %+v", results)
+	}
+	var highlighted []string
+	for _, h := range results[0].Highlights {
+		highlighted = append(highlighted, results[0].Snippet[h[0]:h[1]])
+	}
+	if diff := cmp.Diff([]string{"stormy", "night"}, highlighted); diff != "" {
+		t.Errorf("Highlights: mismatch (-want +got):\n%s", diff)
+	}
+
+	if results, _ := ix.Search("stormy ishmael", 0); len(results) != 0 {
+		t.Errorf("Search(stormy ishmael): want no book with both words, got %+v", results)
+	}
+}
+
+func TestSearch_DocumentFrequency(t *testing.T) {
+	dir := t.TempDir()
+	// Cobalt is in every book and amber in two: amber weighs more, so the
+	// book with the most amber comes first.
+	mostCobalt := writeBook(t, dir, "a.epub", "amber cobalt cobalt cobalt cobalt cobalt", "")
+	mostAmber := writeBook(t, dir, "b.epub", "amber amber amber amber cobalt quartz", "")
+	paths := []string{mostCobalt, mostAmber}
+	for i := range 6 {
+		paths = append(paths, writeBook(t, dir, fmt.Sprintf("c%d.epub", i), "cobalt quartz quartz quartz quartz quartz", ""))
+	}
+
+	ix, err := fulltext.Open(filepath.Join(dir, "cache", fulltext.IndexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := ix.Update(paths); res.Indexed != len(paths) || len(res.Failed) != 0 {
+		t.Fatalf("Update: got %+v", res)
+	}
+
+	results, err := ix.Search("amber cobalt", 0)
+	if err != nil {
+		t.Fatalf("Search: got error:%s", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Path)
+	}
+	if diff := cmp.Diff([]string{mostAmber, mostCobalt}, got); diff != "" {
+		t.Errorf("Search(amber cobalt): mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUpdate_Incremental(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "cache", fulltext.IndexName)
+	storm := writeBook(t, dir, "storm.epub", "A stormy night.", "")
+	whale := writeBook(t, dir, "whale.epub", "Call me Ishmael.", "")
+
+	ix, _ := fulltext.Open(indexPath)
+	ix.Update([]string{storm, whale})
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := fulltext.Open(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(fulltext.UpdateResult{Unchanged: 2}, ix.Update([]string{storm, whale})); diff != "" {
+		t.Errorf("Update after Open: mismatch (-want +got):\n%s", diff)
+	}
+
+	writeBook(t, dir, "storm.epub", "A calm morning.", "")
+	os.Chtimes(storm, time.Now(), time.Now().Add(time.Hour))
+	if diff := cmp.Diff(fulltext.UpdateResult{Indexed: 1, Removed: 1}, ix.Update([]string{storm})); diff != "" {
+		t.Errorf("Update after change: mismatch (-want +got):\n%s", diff)
+	}
+	if results, _ := ix.Search("stormy", 0); len(results) != 0 {
+		t.Errorf("want the old words gone, got %+v", results)
+	}
+	if results, _ := ix.Search("morning", 0); len(results) != 1 {
+		t.Errorf("want the new words indexed, got %+v", results)
+	}
+	if results, _ := ix.Search("ishmael", 0); len(results) != 0 {
+		t.Errorf("want the removed book gone, got %+v", results)
+	}
+}
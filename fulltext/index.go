@@ -0,0 +1,256 @@
+package fulltext
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"Bonalioteko/config"
+	"Bonalioteko/internal/atomicfile"
+	"Bonalioteko/metadata"
+)
+
+// IndexName is the name of the index file in the cache directory.
+const IndexName = "fulltext.gob"
+
+// DefaultPath returns the location of the index in the cache directory.
+func DefaultPath() (string, error) {
+	dir, err := config.CacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, IndexName), nil
+}
+
+// indexVersion changes whenever the index format or the tokenizer changes,
+// which makes the index rebuild itself.
+const indexVersion = 1
+
+// Index is an inverted index of the words of a library. It lives in a
+// single file that is loaded whole and rewritten by Save.
+type Index struct {
+	mu   sync.Mutex
+	path string
+	data indexData
+}
+
+// indexData is the part of the index stored on disk.
+type indexData struct {
+	Version int
+	NextID  uint32
+	Docs    map[uint32]*Doc
+	// Terms maps stemmed words to the chapters containing them.
+	Terms map[string][]Posting
+}
+
+// Doc is an indexed book.
+type Doc struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+	// Chapters holds the spine documents of the book. Snippets are read
+	// from the EPUB again when searching, so that the index only stores
+	// the words.
+	Chapters []metadata.Chapter
+	// Length is the number of words of the book.
+	Length int
+}
+
+// Posting records how often a word appears in a chapter of a book.
+type Posting struct {
+	Doc     uint32
+	Chapter uint32
+	Count   uint32
+}
+
+// Open loads the index stored at path. A missing, unreadable or outdated
+// index gives an empty index that Update fills again.
+func Open(path string) (*Index, error) {
+	ix := &Index{path: path, data: emptyData()}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ix, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var data indexData
+	if err := gob.NewDecoder(f).Decode(&data); err != nil || data.Version != indexVersion {
+		return ix, nil
+	}
+	ix.data = data
+	return ix, nil
+}
+
+func emptyData() indexData {
+	return indexData{
+		Version: indexVersion,
+		Docs:    make(map[uint32]*Doc),
+		Terms:   make(map[string][]Posting),
+	}
+}
+
+// Save writes the index to its file.
+func (ix *Index) Save() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	f, err := atomicfile.Create(ix.path, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+
+	if err := gob.NewEncoder(f).Encode(ix.data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// UpdateResult tells what Update did.
+type UpdateResult struct {
+	Indexed, Removed, Unchanged int
+	// Failed holds the books that could not be read. They are remembered
+	// as empty so that they are only retried once they change.
+	Failed []error
+}
+
+// Update brings the index in line with the library: books that are new or
+// whose size or modification time changed are indexed again, books that
+// are no longer in paths are dropped, and the others are left alone.
+func (ix *Index) Update(paths []string) UpdateResult {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var result UpdateResult
+	byPath := make(map[string]uint32, len(ix.data.Docs))
+	for id, doc := range ix.data.Docs {
+		byPath[doc.Path] = id
+	}
+
+	stale := make(map[uint32]bool)
+	wanted := make(map[string]bool, len(paths))
+	var changed []string
+	for _, path := range paths {
+		wanted[path] = true
+		info, err := os.Stat(path)
+		if err != nil {
+			result.Failed = append(result.Failed, err)
+			continue
+		}
+		id, ok := byPath[path]
+		if ok && ix.data.Docs[id].ModTime.Equal(info.ModTime()) && ix.data.Docs[id].Size == info.Size() {
+			result.Unchanged++
+			continue
+		}
+		if ok {
+			stale[id] = true
+		}
+		changed = append(changed, path)
+	}
+	for path, id := range byPath {
+		if !wanted[path] {
+			stale[id] = true
+			result.Removed++
+		}
+	}
+	ix.remove(stale)
+
+	for _, path := range changed {
+		if err := ix.add(path); err != nil {
+			result.Failed = append(result.Failed, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		result.Indexed++
+	}
+	return result
+}
+
+// remove drops the books from the index.
+func (ix *Index) remove(ids map[uint32]bool) {
+	if len(ids) == 0 {
+		return
+	}
+	for id := range ids {
+		delete(ix.data.Docs, id)
+	}
+	for term, postings := range ix.data.Terms {
+		kept := postings[:0]
+		for _, p := range postings {
+			if !ids[p.Doc] {
+				kept = append(kept, p)
+			}
+		}
+		if len(kept) == 0 {
+			delete(ix.data.Terms, term)
+			continue
+		}
+		ix.data.Terms[term] = kept
+	}
+}
+
+// add indexes the book at path. Books that are not EPUBs, or that can't be
+// read, are recorded without words.
+func (ix *Index) add(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	id := ix.data.NextID
+	ix.data.NextID++
+	doc := &Doc{Path: path, ModTime: info.ModTime(), Size: info.Size()}
+	ix.data.Docs[id] = doc
+
+	if !strings.EqualFold(filepath.Ext(path), ".epub") {
+		return nil
+	}
+
+	counts := make(map[string]map[uint32]uint32)
+	err = metadata.ReadChapters(path, func(ch metadata.Chapter, r io.Reader) error {
+		text, err := extractText(r)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ch.Name, err)
+		}
+		if ch.Title == "" {
+			ch.Title = text.heading
+		}
+		n := uint32(len(doc.Chapters))
+		doc.Chapters = append(doc.Chapters, ch)
+
+		for _, t := range tokenize(text.text) {
+			if counts[t.term] == nil {
+				counts[t.term] = make(map[uint32]uint32)
+			}
+			counts[t.term][n]++
+			doc.Length++
+		}
+		return nil
+	})
+	if err != nil {
+		doc.Chapters, doc.Length = nil, 0
+		return err
+	}
+
+	for term, chapters := range counts {
+		for ch, n := range chapters {
+			ix.data.Terms[term] = append(ix.data.Terms[term], Posting{Doc: id, Chapter: ch, Count: n})
+		}
+	}
+	return nil
+}
+
+// Len returns the number of indexed books.
+func (ix *Index) Len() int {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return len(ix.data.Docs)
+}
@@ -0,0 +1,217 @@
+package fulltext
+
+import (
+	"cmp"
+	"errors"
+	"io"
+	"math"
+	"slices"
+
+	"Bonalioteko/metadata"
+)
+
+// Result is a book matching a full-text search.
+type Result struct {
+	Path  string
+	Score float64
+	// Chapter names the chapter the snippet comes from.
+	Chapter string
+	// Snippet is a passage of the chapter around the words searched for,
+	// and Highlights the byte ranges of those words in it.
+	Snippet    string
+	Highlights [][2]int
+}
+
+// BM25 parameters.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Search returns the books containing every word of q, best first, at most
+// limit of them. Books are ranked with BM25, and the snippet is taken from
+// the chapter holding the most of the words.
+func (ix *Index) Search(q string, limit int) ([]Result, error) {
+	words := terms(q)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	ix.mu.Lock()
+	var results []Result
+	// chapters holds, for every matching book, the number of searched
+	// words found in each of its chapters and their total count.
+	type chapterHits struct{ words, count uint32 }
+	chapters := make(map[uint32]map[uint32]chapterHits)
+	scores := make(map[uint32]float64)
+
+	var avgLength float64
+	for _, doc := range ix.data.Docs {
+		avgLength += float64(doc.Length)
+	}
+	n := float64(len(ix.data.Docs))
+	avgLength = max(1, avgLength/max(1, n))
+
+	for i, word := range words {
+		// The document frequency counts every book having the word,
+		// not only the ones having the previous words too.
+		df := make(map[uint32]bool)
+		for _, p := range ix.data.Terms[word] {
+			df[p.Doc] = true
+		}
+		tf := make(map[uint32]uint32)
+		for _, p := range ix.data.Terms[word] {
+			// Only books having the previous words can still match.
+			if i > 0 && chapters[p.Doc] == nil {
+				continue
+			}
+			tf[p.Doc] += p.Count
+			if chapters[p.Doc] == nil {
+				chapters[p.Doc] = make(map[uint32]chapterHits)
+			}
+			h := chapters[p.Doc][p.Chapter]
+			h.words++
+			h.count += p.Count
+			chapters[p.Doc][p.Chapter] = h
+		}
+		for id := range chapters {
+			if _, ok := tf[id]; !ok {
+				delete(chapters, id)
+			}
+		}
+
+		idf := math.Log(1 + (n-float64(len(df))+0.5)/(float64(len(df))+0.5))
+		for id, f := range tf {
+			length := float64(ix.data.Docs[id].Length)
+			scores[id] += idf * float64(f) * (k1 + 1) / (float64(f) + k1*(1-b+b*length/avgLength))
+		}
+	}
+
+	type match struct {
+		doc     Doc
+		chapter uint32
+	}
+	matches := make(map[string]match)
+	for id, hits := range chapters {
+		best, bestHits := uint32(0), chapterHits{}
+		for ch, h := range hits {
+			if h.words > bestHits.words || h.words == bestHits.words && (h.count > bestHits.count || h.count == bestHits.count && ch < best) {
+				best, bestHits = ch, h
+			}
+		}
+		doc := *ix.data.Docs[id]
+		results = append(results, Result{Path: doc.Path, Score: scores[id]})
+		matches[doc.Path] = match{doc: doc, chapter: best}
+	}
+	ix.mu.Unlock()
+
+	slices.SortFunc(results, func(a, b Result) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Path, b.Path)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	var errs []error
+	for i := range results {
+		m := matches[results[i].Path]
+		if int(m.chapter) >= len(m.doc.Chapters) {
+			continue
+		}
+		ch := m.doc.Chapters[m.chapter]
+		results[i].Chapter = ch.Title
+		if results[i].Chapter == "" {
+			results[i].Chapter = ch.Name
+		}
+		text, err := chapterTextOf(m.doc.Path, ch.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		results[i].Snippet, results[i].Highlights = snippet(text, words)
+	}
+	return results, errors.Join(errs...)
+}
+
+// errFound stops ReadChapters once the chapter is read.
+var errFound = errors.New("found")
+
+// chapterTextOf reads the text of a chapter of the EPUB at path again.
+func chapterTextOf(path, name string) (string, error) {
+	var text string
+	err := metadata.ReadChapters(path, func(ch metadata.Chapter, r io.Reader) error {
+		if ch.Name != name {
+			return nil
+		}
+		t, err := extractText(r)
+		if err != nil {
+			return err
+		}
+		text = t.text
+		return errFound
+	})
+	if err != nil && !errors.Is(err, errFound) {
+		return "", err
+	}
+	return text, nil
+}
+
+// Snippet lengths, in words.
+const (
+	snippetWindow = 30
+	snippetBefore = 8
+)
+
+// snippet returns the passage of text showing the most of the words, and
+// the byte ranges of the words in it.
+func snippet(text string, words []string) (string, [][2]int) {
+	tokens := tokenize(text)
+	wanted := make(map[string]bool, len(words))
+	for _, w := range words {
+		wanted[w] = true
+	}
+
+	// Start the window at the match followed by the most distinct words.
+	best, bestCount := -1, 0
+	for i, t := range tokens {
+		if !wanted[t.term] {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, u := range tokens[i:min(len(tokens), i+snippetWindow)] {
+			if wanted[u.term] {
+				seen[u.term] = true
+			}
+		}
+		if len(seen) > bestCount {
+			best, bestCount = i, len(seen)
+		}
+	}
+	if best < 0 {
+		return "", nil
+	}
+
+	first := max(0, best-snippetBefore)
+	last := min(len(tokens), first+snippetWindow) - 1
+	start, end := tokens[first].start, tokens[last].end
+
+	var prefix, suffix string
+	if first > 0 {
+		prefix = "…"
+	}
+	if last < len(tokens)-1 {
+		suffix = "…"
+	}
+
+	var highlights [][2]int
+	for _, t := range tokens[first : last+1] {
+		if wanted[t.term] {
+			offset := len(prefix) - start
+			highlights = append(highlights, [2]int{t.start + offset, t.end + offset})
+		}
+	}
+	return prefix + text[start:end] + suffix, highlights
+}
@@ -0,0 +1,109 @@
+// Package fulltext indexes the text of EPUB books and searches it.
+package fulltext
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// chapterText is the text of an XHTML document of the spine.
+type chapterText struct {
+	// heading is the first heading of the document, used as its name when
+	// the table of contents doesn't list it.
+	heading string
+	text    string
+}
+
+// skipped are the elements whose content is not part of the text.
+var skipped = map[atom.Atom]bool{
+	atom.Head:   true,
+	atom.Script: true,
+	atom.Style:  true,
+	atom.Svg:    true,
+}
+
+// headings are the elements a chapter takes its name from.
+var headings = map[atom.Atom]bool{
+	atom.H1: true, atom.H2: true, atom.H3: true,
+}
+
+// extractText returns the text of an XHTML document. Block elements are
+// separated by a space, inline elements run together with their
+// neighbours.
+func extractText(r io.Reader) (chapterText, error) {
+	var ch chapterText
+	var text, heading strings.Builder
+	var skipDepth, headingDepth int
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				ch.text = strings.Join(strings.Fields(text.String()), " ")
+				return ch, nil
+			}
+			return ch, z.Err()
+
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			switch {
+			case skipped[a]:
+				skipDepth++
+			case headings[a] && ch.heading == "":
+				headingDepth++
+			}
+			if isBlock(a) {
+				text.WriteByte(' ')
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			switch {
+			case skipped[a]:
+				skipDepth = max(0, skipDepth-1)
+			case headings[a] && headingDepth > 0:
+				headingDepth--
+				if headingDepth == 0 {
+					ch.heading = strings.Join(strings.Fields(heading.String()), " ")
+				}
+			}
+			if isBlock(a) {
+				text.WriteByte(' ')
+			}
+
+		case html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			if isBlock(atom.Lookup(name)) {
+				text.WriteByte(' ')
+			}
+
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			t := string(z.Text())
+			text.WriteString(t)
+			if headingDepth > 0 {
+				heading.WriteString(t)
+			}
+		}
+	}
+}
+
+// isBlock reports whether the element breaks the flow of words.
+func isBlock(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Br, atom.Hr, atom.Li, atom.Tr, atom.Td, atom.Th,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Blockquote, atom.Section, atom.Article, atom.Pre, atom.Dd, atom.Dt,
+		atom.Body, atom.Table, atom.Ul, atom.Ol, atom.Figure, atom.Figcaption:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package fulltext
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is a word of a text, stemmed, with its byte offsets in the text.
+type token struct {
+	term       string
+	start, end int
+}
+
+// minTermLen is the length below which words are not indexed.
+const minTermLen = 2
+
+// tokenize splits text into words made of letters and digits, and stems
+// them.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		word, wordStart := text[start:end], start
+		start = -1
+		if utf8.RuneCountInString(word) < minTermLen {
+			return
+		}
+		tokens = append(tokens, token{term: stem(strings.ToLower(word)), start: wordStart, end: end})
+	}
+
+	for i, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+// terms returns the distinct stemmed words of a query, in order.
+func terms(query string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, t := range tokenize(query) {
+		if !seen[t.term] {
+			seen[t.term] = true
+			result = append(result, t.term)
+		}
+	}
+	return result
+}
+
+// stem strips the common inflections of English words, so that "walked",
+// "walking" and "walks" are all indexed as "walk". It is deliberately
+// light: the index and the queries go through the same stemmer, so it only
+// has to be consistent, and words of other languages are mostly left
+// alone.
+func stem(w string) string {
+	if utf8.RuneCountInString(w) <= 3 {
+		return w
+	}
+
+	switch {
+	case strings.HasSuffix(w, "sses"):
+		w = w[:len(w)-2]
+	case strings.HasSuffix(w, "ies") && len(w) > 4:
+		w = w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss") &&
+		!strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is"):
+		w = w[:len(w)-1]
+	}
+
+	for _, suffix := range []string{"ingly", "edly", "ing", "ed"} {
+		base, ok := strings.CutSuffix(w, suffix)
+		if !ok || len(base) < 3 || !hasVowel(base) {
+			continue
+		}
+		w = base
+		// "stopped" -> "stopp" -> "stop", but "falling" keeps "fall".
+		if n := len(w); w[n-1] == w[n-2] && !strings.ContainsRune("aeioulsz", rune(w[n-1])) {
+			w = w[:n-1]
+		}
+		break
+	}
+
+	for _, suffix := range []string{"ness", "ly"} {
+		if base, ok := strings.CutSuffix(w, suffix); ok && len(base) >= 3 {
+			return base
+		}
+	}
+	return w
+}
+
+func hasVowel(s string) bool {
+	return strings.ContainsAny(s, "aeiouy")
+}
@@ -0,0 +1,141 @@
+// Package history keeps the searches typed in the filter view across runs.
+package history
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"Bonalioteko/config"
+	"Bonalioteko/internal/atomicfile"
+)
+
+// FileName is the name of the history file in the state directory.
+const FileName = "search_history"
+
+// MaxEntries is the number of searches kept; older ones are dropped.
+const MaxEntries = 500
+
+// DefaultPath returns the location of the history in the state directory.
+func DefaultPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// History is a list of searches, oldest first, with a position for
+// browsing through them like a shell history. The zero value is an empty
+// history kept in memory only.
+type History struct {
+	path    string
+	entries []string
+
+	// pos is the entry being shown while browsing, len(entries) when the
+	// text being typed is, and draft holds that text meanwhile.
+	pos   int
+	draft string
+}
+
+// Load reads the history stored at path. A missing file is an empty
+// history.
+func Load(path string) (*History, error) {
+	h := &History{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return h, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	h.pos = len(h.entries)
+	return h, scanner.Err()
+}
+
+// Entries returns the searches, oldest first.
+func (h *History) Entries() []string {
+	return slices.Clone(h.entries)
+}
+
+// Add records a search as the most recent one, moving it there when it was
+// already in the history, and stops browsing.
+func (h *History) Add(s string) {
+	// Entries are stored one per line.
+	s = strings.TrimSpace(strings.ReplaceAll(s, "\n", " "))
+	if s != "" {
+		h.entries = slices.DeleteFunc(h.entries, func(e string) bool { return e == s })
+		h.entries = append(h.entries, s)
+		if len(h.entries) > MaxEntries {
+			h.entries = slices.Delete(h.entries, 0, len(h.entries)-MaxEntries)
+		}
+	}
+	h.Reset()
+}
+
+// Reset stops browsing, so that Prev starts again from the most recent
+// search.
+func (h *History) Reset() {
+	h.pos = len(h.entries)
+	h.draft = ""
+}
+
+// Prev returns the search before the one being shown. current is the text
+// being typed, given back by Next once past the most recent search. It
+// reports false at the oldest search.
+func (h *History) Prev(current string) (string, bool) {
+	if h.pos == 0 {
+		return "", false
+	}
+	if h.pos == len(h.entries) {
+		h.draft = current
+	}
+	h.pos--
+	return h.entries[h.pos], true
+}
+
+// Next returns the search after the one being shown, or the text that was
+// being typed after the most recent one. It reports false when not
+// browsing.
+func (h *History) Next() (string, bool) {
+	if h.pos >= len(h.entries) {
+		return "", false
+	}
+	h.pos++
+	if h.pos == len(h.entries) {
+		return h.draft, true
+	}
+	return h.entries[h.pos], true
+}
+
+// Save writes the history back to its file.
+func (h *History) Save() error {
+	if h.path == "" {
+		return nil
+	}
+	f, err := atomicfile.Create(h.path, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+
+	w := bufio.NewWriter(f)
+	for _, e := range h.entries {
+		w.WriteString(e + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Commit()
+}
@@ -0,0 +1,67 @@
+package history_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"Bonalioteko/history"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistory_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", history.FileName)
+
+	h, err := history.Load(path)
+	if err != nil {
+		t.Fatalf("Load of a missing file: got error:%s", err)
+	}
+	for _, s := range []string{"tolstoy", "  year:1890..1910 ", "", "lang:en\nfr", "tolstoy"} {
+		h.Add(s)
+	}
+	if err := h.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = history.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"year:1890..1910", "lang:en fr", "tolstoy"}
+	if diff := cmp.Diff(want, h.Entries()); diff != "" {
+		t.Errorf("Entries: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHistory_Browse(t *testing.T) {
+	h, _ := history.Load(filepath.Join(t.TempDir(), history.FileName))
+	h.Add("first")
+	h.Add("second")
+
+	type step struct {
+		prev bool
+		want string
+		ok   bool
+	}
+	steps := []step{
+		{prev: false, want: "", ok: false},
+		{prev: true, want: "second", ok: true},
+		{prev: true, want: "first", ok: true},
+		{prev: true, want: "", ok: false},
+		{prev: false, want: "second", ok: true},
+		{prev: false, want: "draft", ok: true},
+		{prev: false, want: "", ok: false},
+	}
+	for i, s := range steps {
+		var got string
+		var ok bool
+		if s.prev {
+			got, ok = h.Prev("draft")
+		} else {
+			got, ok = h.Next()
+		}
+		if got != s.want || ok != s.ok {
+			t.Errorf("step %d: want %q, %t, got %q, %t", i, s.want, s.ok, got, ok)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package atomicfile replaces files through a temporary file renamed over
+// them, so that a file is never left half written and instances running at
+// the same time never write to the same temporary file.
+package atomicfile
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// File is the temporary file that replaces the file at its path once
+// committed.
+type File struct {
+	*os.File
+	path string
+	done bool
+}
+
+// Create returns a temporary file next to path, creating its directory. The
+// file gets the permissions of the one at path, or perm when there is none
+// yet.
+func Create(path string, perm fs.FileMode) (*File, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	f := &File{File: tmp, path: path}
+	if err := tmp.Chmod(perm); err != nil {
+		f.Abort()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Commit closes the temporary file and renames it over the file it
+// replaces. The temporary file is removed when that fails.
+func (f *File) Commit() error {
+	if f.done {
+		return os.ErrClosed
+	}
+	f.done = true
+	err := f.File.Close()
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Abort closes and removes the temporary file, leaving the file it was to
+// replace untouched. It does nothing once the file is committed, so that it
+// can be deferred.
+func (f *File) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+// Write replaces the file at path with data, as Create and Commit do.
+func Write(path string, data []byte, perm fs.FileMode) error {
+	f, err := Create(path, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
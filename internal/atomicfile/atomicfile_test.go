@@ -0,0 +1,71 @@
+package atomicfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"Bonalioteko/internal/atomicfile"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state", "file")
+	if err := atomicfile.Write(path, []byte("one"), 0o600); err != nil {
+		t.Fatalf("Write of a new file: got error:%s", err)
+	}
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := atomicfile.Write(path, []byte("two"), 0o600); err != nil {
+		t.Fatalf("Write: got error:%s", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "two" {
+		t.Errorf("ReadFile: got %q, %v; want %q", data, err, "two")
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o640 {
+		t.Errorf("mode: got %v, %v; want the one of the replaced file kept", info.Mode(), err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("ReadDir: got %d files; want no temporary file left", len(entries))
+	}
+}
+
+func TestAbort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("kept"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := atomicfile.Create(path, 0o644)
+	if err != nil {
+		t.Fatalf("Create: got error:%s", err)
+	}
+	f.WriteString("dropped")
+	f.Abort()
+	if err := f.Commit(); err == nil {
+		t.Error("Commit after Abort: got no error")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if diff := cmp.Diff([]string{"file"}, names); diff != "" {
+		t.Errorf("ReadDir: mismatch (-want +got):\n%s", diff)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "kept" {
+		t.Errorf("ReadFile: got %q, %v; want the file untouched", data, err)
+	}
+}
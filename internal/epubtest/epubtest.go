@@ -0,0 +1,53 @@
+// Package epubtest writes the EPUB files the tests of the other packages
+// read.
+package epubtest
+
+import (
+	"archive/zip"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+)
+
+// Container returns the META-INF/container.xml of an EPUB whose OPF is at
+// opfPath inside it.
+func Container(opfPath string) string {
+	return `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="` + opfPath + `" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+}
+
+// Write writes an EPUB to path holding the mimetype, a container pointing
+// at the OPF at opfPath, and files, keyed by their path inside the EPUB,
+// in the order of their paths.
+func Write(t testing.TB, path, opfPath string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	add := func(name, body string) {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("mimetype", "application/epub+zip")
+	add("META-INF/container.xml", Container(opfPath))
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		add(name, files[name])
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
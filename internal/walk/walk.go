@@ -0,0 +1,22 @@
+// Package walk finds the files of the library.
+package walk
+
+import (
+	"io/fs"
+	"path/filepath"
+)
+
+// Find returns the files below root with the extension ext.
+func Find(root, ext string) []string {
+	var filename []string
+	filepath.WalkDir(root, func(s string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		if filepath.Ext(d.Name()) == ext {
+			filename = append(filename, s)
+		}
+		return nil
+	})
+	return filename
+}
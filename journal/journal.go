@@ -0,0 +1,265 @@
+// Package journal records the changes made to the tags of the books, so
+// that they can be undone and redone across runs.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"Bonalioteko/config"
+	"Bonalioteko/internal/atomicfile"
+)
+
+// FileName is the name of the journal file in the state directory.
+const FileName = "tag_journal"
+
+// MaxEntries is the number of changes kept; older ones are dropped.
+const MaxEntries = 200
+
+// DefaultPath returns the location of the journal in the state directory.
+func DefaultPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, FileName), nil
+}
+
+// Errors returned when there is no change to undo or redo.
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+)
+
+// Change is the tags of a file before and after a change.
+type Change struct {
+	Path   string   `json:"path"`
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+// Entry is a change to the tags of one file or more, undone and redone as
+// a whole. Action tells what it did, such as "add fiction to Demons".
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Changes []Change  `json:"changes"`
+}
+
+// Store reads and writes the tags of files.
+type Store interface {
+	Tags(path string) ([]string, error)
+	SetTags(path string, tags []string) error
+}
+
+// ConflictError tells that a change can't be undone or redone because the
+// tags of some of its files were changed since by something else.
+type ConflictError struct {
+	Action string
+	Redo   bool
+	Paths  []string
+}
+
+func (e *ConflictError) Error() string {
+	verb := "undone"
+	if e.Redo {
+		verb = "redone"
+	}
+	paths := e.Paths
+	if len(paths) > 3 {
+		paths = append(slices.Clone(paths[:3]), fmt.Sprintf("%d more", len(e.Paths)-3))
+	}
+	return fmt.Sprintf("%q can't be %s: the tags of %s changed since, so it was dropped from the journal", e.Action, verb, strings.Join(paths, ", "))
+}
+
+// Journal is a list of changes, oldest first, with the position between the
+// ones applied and the ones undone, which can be redone until a new change
+// is recorded. The zero value is an empty journal kept in memory only.
+type Journal struct {
+	path    string
+	entries []Entry
+	pos     int
+}
+
+// record is how an entry is stored: one JSON object per line.
+type record struct {
+	Entry
+	Undone bool `json:"undone,omitempty"`
+}
+
+// Load reads the journal stored at path. A missing file is an empty
+// journal.
+func Load(path string) (*Journal, error) {
+	j := &Journal{path: path}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return j, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return j, fmt.Errorf("%s: %w", path, err)
+		}
+		j.entries = append(j.entries, r.Entry)
+		if !r.Undone {
+			j.pos = len(j.entries)
+		}
+	}
+	return j, scanner.Err()
+}
+
+// Reload reads the journal from its file again, to pick up the changes
+// recorded by other runs, such as the commands changing tags from the
+// command line. A journal kept in memory only is left as it is.
+func (j *Journal) Reload() error {
+	if j.path == "" {
+		return nil
+	}
+	loaded, err := Load(j.path)
+	if err != nil {
+		return err
+	}
+	*j = *loaded
+	return nil
+}
+
+// Entries returns the changes, oldest first.
+func (j *Journal) Entries() []Entry {
+	return slices.Clone(j.entries)
+}
+
+// Record adds a change that was just made. The changes undone before are
+// forgotten, since they can't be redone on top of it. Files whose tags
+// didn't change are left out of it, and a change without any isn't
+// recorded.
+func (j *Journal) Record(e Entry) {
+	e.Changes = slices.DeleteFunc(slices.Clone(e.Changes), func(c Change) bool { return sameTags(c.Before, c.After) })
+	if len(e.Changes) == 0 {
+		return
+	}
+	j.entries = append(j.entries[:j.pos], e)
+	if len(j.entries) > MaxEntries {
+		j.entries = slices.Delete(j.entries, 0, len(j.entries)-MaxEntries)
+	}
+	j.pos = len(j.entries)
+}
+
+// Undo gives the files of the last change applied their tags from before
+// it, and returns it. When the tags of one of them changed since, no file
+// is written and the change is dropped, since it can't be undone safely.
+func (j *Journal) Undo(s Store) (Entry, error) {
+	if j.pos == 0 {
+		return Entry{}, ErrNothingToUndo
+	}
+	e := j.entries[j.pos-1]
+	err := apply(s, e, false)
+	var conflict *ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		j.entries = slices.Delete(j.entries, j.pos-1, j.pos)
+		j.pos--
+	case err == nil:
+		j.pos--
+	}
+	return e, err
+}
+
+// Redo applies the last change undone again, and returns it. When the tags
+// of one of its files changed since, no file is written and the changes
+// left to redo are dropped.
+func (j *Journal) Redo(s Store) (Entry, error) {
+	if j.pos == len(j.entries) {
+		return Entry{}, ErrNothingToRedo
+	}
+	e := j.entries[j.pos]
+	err := apply(s, e, true)
+	var conflict *ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		j.entries = j.entries[:j.pos]
+	case err == nil:
+		j.pos++
+	}
+	return e, err
+}
+
+// apply gives the files of the change their tags from after it, or from
+// before it when undoing, once it has checked that they all have the tags
+// from the other side. Files already written are put back when writing one
+// fails.
+func apply(s Store, e Entry, redo bool) error {
+	from, to := func(c Change) []string { return c.After }, func(c Change) []string { return c.Before }
+	if redo {
+		from, to = to, from
+	}
+
+	var conflicts []string
+	for _, c := range e.Changes {
+		current, err := s.Tags(c.Path)
+		if err != nil {
+			return err
+		}
+		if !sameTags(current, from(c)) {
+			conflicts = append(conflicts, c.Path)
+		}
+	}
+	if len(conflicts) > 0 {
+		return &ConflictError{Action: e.Action, Redo: redo, Paths: conflicts}
+	}
+
+	for i, c := range e.Changes {
+		if err := s.SetTags(c.Path, to(c)); err != nil {
+			for _, done := range e.Changes[:i] {
+				s.SetTags(done.Path, from(done))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sameTags reports whether a and b hold the same tags, in any order.
+func sameTags(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+// Save writes the journal back to its file.
+func (j *Journal) Save() error {
+	if j.path == "" {
+		return nil
+	}
+	f, err := atomicfile.Create(j.path, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i, e := range j.entries {
+		if err := enc.Encode(record{Entry: e, Undone: i >= j.pos}); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Commit()
+}
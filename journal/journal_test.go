@@ -0,0 +1,172 @@
+package journal_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"Bonalioteko/journal"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeStore keeps the tags of files in memory.
+type fakeStore map[string][]string
+
+func (s fakeStore) Tags(path string) ([]string, error) { return s[path], nil }
+
+func (s fakeStore) SetTags(path string, tags []string) error {
+	s[path] = tags
+	return nil
+}
+
+func entry(action string, changes ...journal.Change) journal.Entry {
+	return journal.Entry{Time: time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), Action: action, Changes: changes}
+}
+
+func TestJournal_UndoRedo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", journal.FileName)
+	store := fakeStore{"/a.epub": {"fiction"}, "/b.epub": nil}
+
+	j, err := journal.Load(path)
+	if err != nil {
+		t.Fatalf("Load of a missing file: got error:%s", err)
+	}
+	store["/a.epub"] = []string{"fiction", "unread"}
+	store["/b.epub"] = []string{"unread"}
+	j.Record(entry("add unread to 2 books",
+		journal.Change{Path: "/a.epub", Before: []string{"fiction"}, After: []string{"fiction", "unread"}},
+		journal.Change{Path: "/b.epub", After: []string{"unread"}}))
+	store["/a.epub"] = []string{"unread"}
+	j.Record(entry("remove fiction from A", journal.Change{Path: "/a.epub", Before: []string{"fiction", "unread"}, After: []string{"unread"}}))
+
+	if _, err := j.Undo(store); err != nil {
+		t.Fatalf("Undo: got error:%s", err)
+	}
+	if err := j.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The change undone can still be redone after a restart.
+	j, err = journal.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := j.Undo(store)
+	if err != nil {
+		t.Fatalf("Undo: got error:%s", err)
+	}
+	if e.Action != "add unread to 2 books" {
+		t.Errorf("Undo: got %q", e.Action)
+	}
+	want := fakeStore{"/a.epub": {"fiction"}, "/b.epub": nil}
+	if diff := cmp.Diff(want, store); diff != "" {
+		t.Errorf("Undo: mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := j.Undo(store); !errors.Is(err, journal.ErrNothingToUndo) {
+		t.Errorf("Undo past the start: got %v", err)
+	}
+
+	for range 2 {
+		if _, err := j.Redo(store); err != nil {
+			t.Fatalf("Redo: got error:%s", err)
+		}
+	}
+	want = fakeStore{"/a.epub": {"unread"}, "/b.epub": {"unread"}}
+	if diff := cmp.Diff(want, store); diff != "" {
+		t.Errorf("Redo: mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := j.Redo(store); !errors.Is(err, journal.ErrNothingToRedo) {
+		t.Errorf("Redo past the end: got %v", err)
+	}
+}
+
+func TestJournal_Record(t *testing.T) {
+	store := fakeStore{"/a.epub": {"fiction"}}
+	j := &journal.Journal{}
+	j.Record(entry("add fiction to A", journal.Change{Path: "/a.epub", After: []string{"fiction"}}))
+	if _, err := j.Undo(store); err != nil {
+		t.Fatal(err)
+	}
+	// A new change forgets the one undone.
+	store["/a.epub"] = []string{"poetry"}
+	j.Record(entry("add poetry to A", journal.Change{Path: "/a.epub", After: []string{"poetry"}}))
+	if _, err := j.Redo(store); !errors.Is(err, journal.ErrNothingToRedo) {
+		t.Errorf("Redo: got %v, want nothing to redo", err)
+	}
+	if got := len(j.Entries()); got != 1 {
+		t.Errorf("Entries: got %d, want 1", got)
+	}
+}
+
+func TestJournal_Conflict(t *testing.T) {
+	store := fakeStore{"/a.epub": {"unread", "fiction"}, "/b.epub": {"unread"}}
+	j := &journal.Journal{}
+	j.Record(entry("add fiction to A", journal.Change{Path: "/a.epub", Before: []string{"unread"}, After: []string{"fiction", "unread"}}))
+	j.Record(entry("add unread to 2 books",
+		journal.Change{Path: "/a.epub", Before: []string{"fiction"}, After: []string{"fiction", "unread"}},
+		journal.Change{Path: "/b.epub", After: []string{"unread"}}))
+
+	// Something else tagged B since.
+	store["/b.epub"] = []string{"unread", "poetry"}
+	_, err := j.Undo(store)
+	var conflict *journal.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Undo: got %v, want a conflict", err)
+	}
+	if diff := cmp.Diff([]string{"/b.epub"}, conflict.Paths); diff != "" {
+		t.Errorf("ConflictError.Paths: mismatch (-want +got):\n%s", diff)
+	}
+	// No file was written, and the change was dropped.
+	want := fakeStore{"/a.epub": {"unread", "fiction"}, "/b.epub": {"unread", "poetry"}}
+	if diff := cmp.Diff(want, store); diff != "" {
+		t.Errorf("Undo: mismatch (-want +got):\n%s", diff)
+	}
+	e, err := j.Undo(store)
+	if err != nil || e.Action != "add fiction to A" {
+		t.Errorf("Undo after the conflict: got %q, %v", e.Action, err)
+	}
+}
+
+func TestJournal_RecordUnchanged(t *testing.T) {
+	j := &journal.Journal{}
+	j.Record(entry("add fiction to A", journal.Change{Path: "/a.epub", Before: []string{"fiction"}, After: []string{"fiction"}}))
+	j.Record(entry("add fiction to 2 books",
+		journal.Change{Path: "/a.epub", Before: []string{"fiction"}, After: []string{"fiction"}},
+		journal.Change{Path: "/b.epub", After: []string{"fiction"}}))
+	want := []journal.Entry{entry("add fiction to 2 books", journal.Change{Path: "/b.epub", After: []string{"fiction"}})}
+	if diff := cmp.Diff(want, j.Entries()); diff != "" {
+		t.Errorf("Entries: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestJournal_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), journal.FileName)
+	j, err := journal.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another run, such as a command, records a change meanwhile.
+	other, err := journal.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Record(entry("import the Calibre tags of 2 books", journal.Change{Path: "/a.epub", After: []string{"fiction"}}))
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Reload(); err != nil {
+		t.Fatalf("Reload: got error:%s", err)
+	}
+	j.Record(entry("add poetry to B", journal.Change{Path: "/b.epub", After: []string{"poetry"}}))
+	var got []string
+	for _, e := range j.Entries() {
+		got = append(got, e.Action)
+	}
+	if diff := cmp.Diff([]string{"import the Calibre tags of 2 books", "add poetry to B"}, got); diff != "" {
+		t.Errorf("Entries: mismatch (-want +got):\n%s", diff)
+	}
+}
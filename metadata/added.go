@@ -0,0 +1,95 @@
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"Bonalioteko/config"
+	"Bonalioteko/internal/atomicfile"
+)
+
+// AddedFileName is the name of the index of the dates the books were added,
+// in the state directory.
+const AddedFileName = "added"
+
+// DefaultAddedPath returns the location of the index of the dates the books
+// were added in the state directory.
+func DefaultAddedPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, AddedFileName), nil
+}
+
+// AddedIndex maps the paths of the books to the date they were first seen
+// in the library. It is kept apart from the books, so that scanning the
+// library never writes to them. The zero value is an empty index kept in
+// memory only.
+type AddedIndex struct {
+	path    string
+	dates   map[string]time.Time
+	changed bool
+}
+
+// LoadAddedIndex reads the index stored at path. A missing file is an
+// empty index.
+func LoadAddedIndex(path string) (*AddedIndex, error) {
+	x := &AddedIndex{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return x, nil
+	}
+	if err != nil {
+		return x, err
+	}
+	if err := json.Unmarshal(data, &x.dates); err != nil {
+		return x, err
+	}
+	return x, nil
+}
+
+// Stamp gives the books their date added. Books seen for the first time
+// were most likely added when they were last modified, so the earlier of
+// that and now is recorded for them.
+func (x *AddedIndex) Stamp(books []Book) {
+	now := time.Now().Truncate(time.Second)
+	for i := range books {
+		b := &books[i]
+		if b.ModTime.IsZero() {
+			continue
+		}
+		added, ok := x.dates[b.Path]
+		if !ok {
+			added = now
+			if b.ModTime.Before(now) {
+				added = b.ModTime.Truncate(time.Second)
+			}
+			if x.dates == nil {
+				x.dates = make(map[string]time.Time)
+			}
+			x.dates[b.Path] = added
+			x.changed = true
+		}
+		b.Added = added
+	}
+}
+
+// Save writes the index back to its file when new books were stamped.
+func (x *AddedIndex) Save() error {
+	if x.path == "" || !x.changed {
+		return nil
+	}
+	data, err := json.Marshal(x.dates)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.Write(x.path, data, 0o644); err != nil {
+		return err
+	}
+	x.changed = false
+	return nil
+}
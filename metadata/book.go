@@ -0,0 +1,241 @@
+package metadata
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"Bonalioteko/internal/walk"
+	"Bonalioteko/xattr"
+
+	"github.com/pirmd/epub"
+)
+
+// Identifier is a book identifier such as an ISBN or a UUID.
+type Identifier struct {
+	Scheme string
+	Value  string
+}
+
+// Book holds the metadata of a single ebook file.
+type Book struct {
+	Path string
+
+	Title       string
+	Authors     []string
+	Series      string
+	SeriesIndex string
+	Language    string
+	Publisher   string
+	PublishDate string
+	Identifiers []Identifier
+	Description string
+	Subjects    []string
+
+	Size    int64
+	ModTime time.Time
+	// Added is when the book was first seen in the library.
+	Added time.Time
+
+	// Overrides are the fields set in extended attributes and Original
+	// the values they replaced.
+	Overrides Overrides
+	Original  Overrides
+}
+
+// Author returns the authors of the book joined by a comma.
+func (b Book) Author() string {
+	return strings.Join(b.Authors, ", ")
+}
+
+// ISBN returns the first identifier that looks like an ISBN.
+func (b Book) ISBN() string {
+	for _, id := range b.Identifiers {
+		if strings.EqualFold(id.Scheme, "isbn") || strings.HasPrefix(strings.ToLower(id.Value), "urn:isbn:") {
+			return strings.TrimPrefix(strings.ToLower(id.Value), "urn:isbn:")
+		}
+	}
+	return ""
+}
+
+// FromFile reads the metadata of the ebook at path. The Calibre
+// metadata.opf of the file, as found by SidecarPath, takes precedence over the OPF embedded in the EPUB, and
+// the overrides stored in extended attributes over both. The
+// returned Book always carries the path, size and modification time; the
+// title falls back to the Calibre folder name or the file name when no OPF
+// has one.
+func FromFile(path string) (Book, error) {
+	book := Book{Path: path, Title: filepath.Base(path)}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return book, err
+	}
+	book.Size = info.Size()
+	book.ModTime = info.ModTime()
+
+	meta, err := epub.GetMetadataFromFile(path)
+	if err == nil {
+		fromInformation(&book, meta)
+	}
+
+	var sidecar Sidecar
+	sidecarErr := fs.ErrNotExist
+	if p := SidecarPath(path); p != "" {
+		sidecar, sidecarErr = ReadSidecar(p)
+	}
+	switch {
+	case sidecarErr == nil:
+		applySidecar(&book, sidecar)
+	case errors.Is(sidecarErr, fs.ErrNotExist):
+		sidecarErr = nil
+		applyCalibreLayout(&book)
+	}
+
+	overrides, overridesErr := ReadOverrides(path)
+	applyOverrides(&book, overrides)
+
+	return book, errors.Join(err, sidecarErr, overridesErr)
+}
+
+// fromInformation copies the fields of the parsed OPF into book.
+func fromInformation(book *Book, meta *epub.Information) {
+	if t := first(meta.Title); t != "" {
+		book.Title = t
+	}
+	for _, c := range meta.Creator {
+		if c.FullName != "" {
+			book.Authors = append(book.Authors, strings.TrimSpace(c.FullName))
+		}
+	}
+	book.Series = strings.TrimSpace(meta.Series)
+	book.SeriesIndex = strings.TrimSpace(meta.SeriesIndex)
+	book.Language = first(meta.Language)
+	book.Publisher = first(meta.Publisher)
+	book.Description = first(meta.Description)
+	book.PublishDate = publicationDate(meta.Date)
+	for _, subject := range meta.Subject {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			book.Subjects = append(book.Subjects, subject)
+		}
+	}
+	for _, id := range meta.Identifier {
+		if id.Value == "" {
+			continue
+		}
+		book.Identifiers = append(book.Identifiers, Identifier{Scheme: id.Scheme, Value: strings.TrimSpace(id.Value)})
+	}
+}
+
+// publicationDate prefers the date flagged as the publication event and
+// falls back to the first date of the OPF.
+func publicationDate(dates []epub.Date) string {
+	for _, d := range dates {
+		if strings.EqualFold(d.Event, "publication") {
+			return strings.TrimSpace(d.Stamp)
+		}
+	}
+	if len(dates) > 0 {
+		return strings.TrimSpace(dates[0].Stamp)
+	}
+	return ""
+}
+
+func first(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Scan walks root and returns the metadata of every EPUB found below it.
+func Scan(root string) []Book {
+	return LoadAll(walk.Find(root, ".epub"))
+}
+
+// Load is like FromFile but logs the error instead of returning it, so that
+// a broken file still shows up in the library under its file name.
+func Load(path string) Book {
+	book, err := FromFile(path)
+	if err != nil {
+		log.Printf("Warning: could not read metadata for %s: %v", path, err)
+	}
+	return book
+}
+
+// LoadAll returns the metadata of every path. Books seen for the first time
+// are given their date added in the index of the state directory.
+func LoadAll(paths []string) []Book {
+	books := make([]Book, 0, len(paths))
+	for _, p := range paths {
+		books = append(books, Load(p))
+	}
+	stampAdded(books)
+	return books
+}
+
+// stampAdded gives the books their date added from the index in the state
+// directory, recording the books seen for the first time.
+func stampAdded(books []Book) {
+	// An index that can't be read is kept in memory only, not to lose it.
+	index := &AddedIndex{}
+	path, err := DefaultAddedPath()
+	if err == nil {
+		var loaded *AddedIndex
+		if loaded, err = LoadAddedIndex(path); err == nil {
+			index = loaded
+		}
+	}
+	if err != nil {
+		log.Printf("Warning: dates added: %v", err)
+	}
+	index.Stamp(books)
+	if err := index.Save(); err != nil {
+		log.Printf("Warning: dates added: %v", err)
+	}
+}
+
+// Paths returns the file paths of books.
+func Paths(books []Book) []string {
+	paths := make([]string, len(books))
+	for i, b := range books {
+		paths[i] = b.Path
+	}
+	return paths
+}
+
+// WithPaths returns the books whose path is in paths, in their order.
+func WithPaths(books []Book, paths []string) []Book {
+	keep := xattr.CreateHashSet(paths)
+	var kept []Book
+	for _, b := range books {
+		if keep[b.Path] {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// Year returns the year of the publication date, or 0 when unknown.
+func (b Book) Year() int {
+	if len(b.PublishDate) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(b.PublishDate[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+// Format returns the file format of the book, such as "epub".
+func (b Book) Format() string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(b.Path), "."))
+}
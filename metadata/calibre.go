@@ -0,0 +1,275 @@
+package metadata
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"Bonalioteko/internal/walk"
+	"Bonalioteko/xattr"
+)
+
+// SidecarName is the name of the OPF file Calibre writes next to each book.
+const SidecarName = "metadata.opf"
+
+// calibreDir matches the "Title (id)" folders of a Calibre library.
+var calibreDir = regexp.MustCompile(`^(.+) \((\d+)\)$`)
+
+// Sidecar is the metadata found in a Calibre metadata.opf.
+type Sidecar struct {
+	Title       string
+	Authors     []string
+	Tags        []string
+	Series      string
+	SeriesIndex string
+	Rating      string
+	Language    string
+	Publisher   string
+	PublishDate string
+	Identifiers []Identifier
+	Description string
+}
+
+// sidecarOPF is the subset of an OPF document read from Calibre sidecars.
+type sidecarOPF struct {
+	Metadata struct {
+		Title       []string `xml:"title"`
+		Creator     []string `xml:"creator"`
+		Subject     []string `xml:"subject"`
+		Language    []string `xml:"language"`
+		Publisher   []string `xml:"publisher"`
+		Date        []string `xml:"date"`
+		Description []string `xml:"description"`
+		Identifier  []struct {
+			Scheme string `xml:"scheme,attr"`
+			Value  string `xml:",chardata"`
+		} `xml:"identifier"`
+		Meta []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+}
+
+// SidecarPath returns the path of the Calibre sidecar of the ebook at path,
+// or "" when it has none. A sidecar describes a single book, so one is only
+// taken to belong to the ebook in the "Author/Title (id)/" folders of a
+// Calibre library, or when the ebook is the only EPUB of its folder: a
+// metadata.opf left among other books describes none of them.
+func SidecarPath(path string) string {
+	dir := filepath.Dir(path)
+	sidecar := filepath.Join(dir, SidecarName)
+	if _, err := os.Stat(sidecar); err != nil {
+		return ""
+	}
+	if calibreDir.MatchString(filepath.Base(dir)) {
+		return sidecar
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	epubs := 0
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == filepath.Ext(path) {
+			epubs++
+		}
+	}
+	if epubs != 1 {
+		return ""
+	}
+	return sidecar
+}
+
+// ReadSidecar parses the Calibre metadata.opf at path.
+func ReadSidecar(path string) (Sidecar, error) {
+	var s Sidecar
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+
+	var opf sidecarOPF
+	if err := xml.Unmarshal(data, &opf); err != nil {
+		return s, fmt.Errorf("parsing %q: %w", path, err)
+	}
+
+	md := opf.Metadata
+	s.Title = first(md.Title)
+	s.Language = first(md.Language)
+	s.Publisher = first(md.Publisher)
+	s.PublishDate = first(md.Date)
+	s.Description = first(md.Description)
+	for _, c := range md.Creator {
+		if c = strings.TrimSpace(c); c != "" {
+			s.Authors = append(s.Authors, c)
+		}
+	}
+	for _, tag := range md.Subject {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			s.Tags = append(s.Tags, tag)
+		}
+	}
+	for _, id := range md.Identifier {
+		if v := strings.TrimSpace(id.Value); v != "" {
+			s.Identifiers = append(s.Identifiers, Identifier{Scheme: id.Scheme, Value: v})
+		}
+	}
+	for _, meta := range md.Meta {
+		switch meta.Name {
+		case "calibre:series":
+			s.Series = strings.TrimSpace(meta.Content)
+		case "calibre:series_index":
+			s.SeriesIndex = strings.TrimSpace(meta.Content)
+		case "calibre:rating":
+			s.Rating = strings.TrimSpace(meta.Content)
+		}
+	}
+
+	return s, nil
+}
+
+// applySidecar overlays the non-empty fields of the sidecar on book.
+func applySidecar(book *Book, s Sidecar) {
+	if s.Title != "" {
+		book.Title = s.Title
+	}
+	if len(s.Authors) > 0 {
+		book.Authors = s.Authors
+	}
+	if len(s.Tags) > 0 {
+		book.Subjects = s.Tags
+	}
+	if s.Series != "" {
+		book.Series = s.Series
+		book.SeriesIndex = s.SeriesIndex
+	}
+	if s.Language != "" {
+		book.Language = s.Language
+	}
+	if s.Publisher != "" {
+		book.Publisher = s.Publisher
+	}
+	if s.PublishDate != "" {
+		book.PublishDate = s.PublishDate
+	}
+	if len(s.Identifiers) > 0 {
+		book.Identifiers = s.Identifiers
+	}
+	if s.Description != "" {
+		book.Description = s.Description
+	}
+}
+
+// applyCalibreLayout fills the title and author from an "Author/Title (id)/"
+// folder layout when the book has neither.
+func applyCalibreLayout(book *Book) {
+	dir := filepath.Dir(book.Path)
+	match := calibreDir.FindStringSubmatch(filepath.Base(dir))
+	if match == nil {
+		return
+	}
+	if book.Title == filepath.Base(book.Path) {
+		book.Title = match[1]
+	}
+	if len(book.Authors) == 0 {
+		book.Authors = []string{filepath.Base(filepath.Dir(dir))}
+	}
+}
+
+// CalibreStars converts a Calibre rating, stored from 0 to 10, to a number
+// of stars from 0 to 5.
+func CalibreStars(rating string) string {
+	r, err := strconv.ParseFloat(rating, 64)
+	if err != nil || r <= 0 {
+		return ""
+	}
+	return strconv.Itoa(int(r+1) / 2)
+}
+
+// ImportResult describes what the Calibre import did for one book.
+type ImportResult struct {
+	Path   string
+	Tags   []string
+	Series string
+	Rating string
+	Err    error
+
+	// Rejected are the Calibre tags containing a comma, which separates
+	// the tags in the extended attribute, so they are not imported rather
+	// than split into several tags.
+	Rejected []string
+
+	// TagsBefore and TagsAfter are the tags of the file before and after
+	// the import, for it to be journaled.
+	TagsBefore, TagsAfter []string
+}
+
+// ImportCalibre turns the tags, series and ratings of the Calibre sidecars
+// found below root into Bonalioteko extended attributes. Tags are merged
+// with the ones already on the file.
+func ImportCalibre(root string, dryRun bool) []ImportResult {
+	var results []ImportResult
+	for _, path := range walk.Find(root, ".epub") {
+		sidecar := SidecarPath(path)
+		if sidecar == "" {
+			continue
+		}
+		s, err := ReadSidecar(sidecar)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		result := ImportResult{Path: path, Series: s.Series, Rating: CalibreStars(s.Rating), Err: err}
+		for _, tag := range s.Tags {
+			if strings.Contains(tag, ",") {
+				result.Rejected = append(result.Rejected, tag)
+			} else {
+				result.Tags = append(result.Tags, tag)
+			}
+		}
+		if err == nil && !dryRun {
+			result.Err = importSidecar(&result, s.SeriesIndex)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func importSidecar(r *ImportResult, seriesIndex string) error {
+	path := r.Path
+	if len(r.Tags) > 0 {
+		before, err := xattr.GetTagsFromPath(path)
+		if err != nil {
+			return err
+		}
+		if err := xattr.Addtag(path, []byte(strings.Join(r.Tags, ","))); err != nil {
+			return err
+		}
+		after, err := xattr.GetTagsFromPath(path)
+		if err != nil {
+			return err
+		}
+		r.TagsBefore, r.TagsAfter = before, after
+	}
+	if r.Series != "" {
+		if err := xattr.SetAttribute(path, xattr.AttrSeries, r.Series); err != nil {
+			return err
+		}
+		if err := xattr.SetAttribute(path, xattr.AttrSeriesIndex, seriesIndex); err != nil {
+			return err
+		}
+	}
+	if r.Rating != "" {
+		if err := xattr.SetAttribute(path, xattr.AttrRating, r.Rating); err != nil {
+			return err
+		}
+	}
+	return nil
+}
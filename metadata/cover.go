@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrNoCover is returned by ReadCover for EPUBs without a cover image.
+var ErrNoCover = errors.New("no cover image")
+
+// coverDocument holds the parts of an OPF pointing at the cover.
+type coverDocument struct {
+	Metas []struct {
+		Name    string `xml:"name,attr"`
+		Content string `xml:"content,attr"`
+	} `xml:"metadata>meta"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+}
+
+// ReadCover returns the cover image of the EPUB file and its media type.
+// The cover is the manifest item with the cover-image property of EPUB 3,
+// else the item named by the cover meta of EPUB 2, else an image whose id or
+// file name says it is the cover.
+func ReadCover(file string) ([]byte, string, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, "", err
+	}
+	defer zr.Close()
+
+	name, err := rootfile(&zr.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := readZipFile(&zr.Reader, name)
+	if err != nil {
+		return nil, "", err
+	}
+	var pkg coverDocument
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, "", fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	href, mediaType := pkg.cover()
+	if href == "" {
+		return nil, "", ErrNoCover
+	}
+	href = resolve(path.Dir(name), href)
+	image, err := readZipFile(&zr.Reader, href)
+	if err != nil {
+		return nil, "", fmt.Errorf("cover %s: %w", href, err)
+	}
+	return image, mediaType, nil
+}
+
+// cover returns the href and the media type of the cover image.
+func (pkg coverDocument) cover() (href, mediaType string) {
+	for _, item := range pkg.Manifest {
+		if strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			return item.Href, item.MediaType
+		}
+	}
+
+	var id string
+	for _, meta := range pkg.Metas {
+		if meta.Name == "cover" {
+			id = meta.Content
+		}
+	}
+	if id != "" {
+		// Some books name the file instead of the item.
+		for _, item := range pkg.Manifest {
+			if item.ID == id || item.Href == id {
+				return item.Href, item.MediaType
+			}
+		}
+	}
+
+	for _, item := range pkg.Manifest {
+		if !strings.HasPrefix(item.MediaType, "image/") {
+			continue
+		}
+		if strings.Contains(strings.ToLower(item.ID+" "+path.Base(item.Href)), "cover") {
+			return item.Href, item.MediaType
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,153 @@
+package metadata
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// blankLine separates the paragraphs of descriptions written as plain
+// text.
+var blankLine = regexp.MustCompile(`\n[ \t\r]*\n`)
+
+// DescriptionText renders the HTML of an OPF description as plain text:
+// paragraphs are separated by a blank line, line breaks and list items start
+// a new line, and the rest of the white space is collapsed. Descriptions
+// written as plain text keep their paragraphs.
+func DescriptionText(description string) string {
+	var w textWriter
+	var skipDepth, preDepth int
+
+	z := html.NewTokenizer(strings.NewReader(description))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// The tokenizer reads broken markup as text, so this is the end
+			// of the description.
+			return w.b.String()
+
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			start := tt != html.EndTagToken
+			switch {
+			case a == atom.Script || a == atom.Style:
+				if tt == html.StartTagToken {
+					skipDepth++
+				} else if tt == html.EndTagToken {
+					skipDepth = max(0, skipDepth-1)
+				}
+			case a == atom.Br && start:
+				w.brk(1)
+			case a == atom.Li && start:
+				w.brk(1)
+				w.prefix("• ")
+			case a == atom.Pre:
+				if tt == html.StartTagToken {
+					preDepth++
+				} else if tt == html.EndTagToken {
+					preDepth = max(0, preDepth-1)
+				}
+				w.brk(2)
+			case isParagraph(a):
+				w.brk(2)
+			case isLine(a):
+				w.brk(1)
+			}
+
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+			text := string(z.Text())
+			if preDepth > 0 {
+				w.prefix(strings.Trim(text, "\n"))
+				continue
+			}
+			for i, para := range blankLine.Split(text, -1) {
+				if i > 0 {
+					w.brk(2)
+				}
+				w.words(para)
+			}
+		}
+	}
+}
+
+// isParagraph reports whether the element stands apart from its neighbours
+// with a blank line.
+func isParagraph(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Blockquote, atom.Section, atom.Article,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Ul, atom.Ol, atom.Dl, atom.Table, atom.Hr, atom.Figure:
+		return true
+	}
+	return false
+}
+
+// isLine reports whether the element starts on a line of its own.
+func isLine(a atom.Atom) bool {
+	switch a {
+	case atom.Tr, atom.Dt, atom.Dd, atom.Figcaption:
+		return true
+	}
+	return false
+}
+
+// textWriter joins words with single spaces and line breaks, leaving out the
+// breaks at the start and the repeated ones.
+type textWriter struct {
+	b strings.Builder
+	// breaks is the number of line feeds, and space whether a space, to
+	// write before the next word.
+	breaks int
+	space  bool
+}
+
+func (w *textWriter) brk(n int) {
+	if w.b.Len() > 0 {
+		w.breaks = max(w.breaks, n)
+	}
+	w.space = false
+}
+
+// flush writes the separator pending before the next word.
+func (w *textWriter) flush() {
+	switch {
+	case w.breaks > 0:
+		w.b.WriteString(strings.Repeat("\n", w.breaks))
+	case w.space && w.b.Len() > 0:
+		w.b.WriteByte(' ')
+	}
+	w.breaks, w.space = 0, false
+}
+
+// words writes the words of s, and the spaces around them.
+func (w *textWriter) words(s string) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		w.space = w.space || s != ""
+		return
+	}
+	if strings.TrimLeft(s, " \t\r\n") != s {
+		w.space = true
+	}
+	for i, f := range fields {
+		if i > 0 {
+			w.space = true
+		}
+		w.flush()
+		w.b.WriteString(f)
+	}
+	w.space = strings.TrimRight(s, " \t\r\n") != s
+}
+
+// prefix writes s as it is, without a space after it.
+func (w *textWriter) prefix(s string) {
+	w.flush()
+	w.b.WriteString(s)
+}
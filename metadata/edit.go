@@ -0,0 +1,217 @@
+package metadata
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"Bonalioteko/internal/atomicfile"
+)
+
+// languageTag loosely matches BCP 47 language tags such as "en" or "pt-BR".
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// Edit holds the metadata fields that can be written back into an EPUB.
+type Edit struct {
+	Title       string
+	Authors     []string
+	Series      string
+	SeriesIndex string
+	Language    string
+	Description string
+}
+
+// EditFromBook returns the editable fields of book.
+func EditFromBook(b Book) Edit {
+	return Edit{
+		Title:       b.Title,
+		Authors:     slices.Clone(b.Authors),
+		Series:      b.Series,
+		SeriesIndex: b.SeriesIndex,
+		Language:    b.Language,
+		Description: b.Description,
+	}
+}
+
+// Validate checks that the edit can be written into an OPF.
+func (e Edit) Validate() error {
+	var errs []error
+	if strings.TrimSpace(e.Title) == "" {
+		errs = append(errs, errors.New("title can't be empty"))
+	}
+	for _, a := range e.Authors {
+		if strings.TrimSpace(a) == "" {
+			errs = append(errs, errors.New("author names can't be empty"))
+			break
+		}
+	}
+	if e.Language != "" && !languageTag.MatchString(e.Language) {
+		errs = append(errs, fmt.Errorf("%q is not a language code such as en or pt-BR", e.Language))
+	}
+	if e.SeriesIndex != "" {
+		if e.Series == "" {
+			errs = append(errs, errors.New("series index needs a series"))
+		}
+		if _, err := strconv.ParseFloat(e.SeriesIndex, 64); err != nil {
+			errs = append(errs, fmt.Errorf("series index %q is not a number", e.SeriesIndex))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ApplyEdit writes the fields that differ between before and after into
+// opf. Fields left untouched keep their elements and attributes as they are.
+func ApplyEdit(opf []byte, before, after Edit) ([]byte, error) {
+	var err error
+	if after.Title != before.Title {
+		if opf, err = setText(opf, "title", after.Title); err != nil {
+			return nil, err
+		}
+	}
+	if after.Language != before.Language {
+		if opf, err = setText(opf, "language", after.Language); err != nil {
+			return nil, err
+		}
+	}
+	if after.Description != before.Description {
+		if opf, err = setText(opf, "description", after.Description); err != nil {
+			return nil, err
+		}
+	}
+	if !slices.Equal(after.Authors, before.Authors) {
+		// The role and sort name of the creators are kept.
+		if opf, err = setTexts(opf, "creator", after.Authors); err != nil {
+			return nil, err
+		}
+	}
+	if after.Series != before.Series || after.SeriesIndex != before.SeriesIndex {
+		opf, err = replaceMetadata(opf, isSeriesMeta, func(string) []string {
+			if after.Series == "" {
+				return nil
+			}
+			elements := []string{metaElement("calibre:series", after.Series)}
+			if after.SeriesIndex != "" {
+				elements = append(elements, metaElement("calibre:series_index", after.SeriesIndex))
+			}
+			return elements
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return opf, nil
+}
+
+// isSeriesMeta matches the Calibre and EPUB 3 series meta elements.
+func isSeriesMeta(e xml.StartElement) bool {
+	if e.Name.Local != "meta" {
+		return false
+	}
+	switch attr(e, "name") {
+	case "calibre:series", "calibre:series_index":
+		return true
+	}
+	return attr(e, "property") == "belongs-to-collection"
+}
+
+// metaElement renders an OPF 2 meta element.
+func metaElement(name, content string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(content))
+	return fmt.Sprintf(`<meta name="%s" content="%s"/>`, name, escaped.String())
+}
+
+// WriteEdit validates the edit and writes it into the OPF of the EPUB at
+// path, and into its Calibre sidecar when there is one since the sidecar
+// takes precedence when reading. The edited OPFs are parsed back and
+// compared with the edit, and both files written in full, before either
+// original is replaced, so that a failure leaves both untouched.
+func WriteEdit(path string, before, after Edit) error {
+	if err := after.Validate(); err != nil {
+		return err
+	}
+	edit := func(opf []byte) ([]byte, error) {
+		edited, err := ApplyEdit(opf, before, after)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyEdit(edited, before, after); err != nil {
+			return nil, err
+		}
+		return edited, nil
+	}
+
+	epub, err := rewriteOPF(path, edit)
+	if err != nil {
+		return err
+	}
+	defer epub.Abort()
+
+	sidecar, err := editSidecar(SidecarPath(path), edit)
+	if err != nil {
+		return err
+	}
+	if sidecar != nil {
+		defer sidecar.Abort()
+	}
+
+	if err := epub.Commit(); err != nil {
+		return err
+	}
+	if sidecar != nil {
+		return sidecar.Commit()
+	}
+	return nil
+}
+
+// editSidecar writes the Calibre sidecar at path passed through edit to a
+// temporary file, for it to be committed. It returns nil when there is no
+// sidecar.
+func editSidecar(path string, edit func([]byte) ([]byte, error)) (*atomicfile.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	opf, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opf, err = edit(opf); err != nil {
+		return nil, fmt.Errorf("editing %s: %w", path, err)
+	}
+	f, err := atomicfile.Create(path, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(opf); err != nil {
+		f.Abort()
+		return nil, err
+	}
+	return f, nil
+}
+
+// verifyEdit checks that the fields changed by the edit read back from opf.
+func verifyEdit(opf []byte, before, want Edit) error {
+	var doc sidecarOPF
+	if err := xml.Unmarshal(opf, &doc); err != nil {
+		return fmt.Errorf("edited OPF is not valid XML: %w", err)
+	}
+	if got := first(doc.Metadata.Title); want.Title != before.Title && got != strings.TrimSpace(want.Title) {
+		return fmt.Errorf("edited OPF has title %q, want %q", got, want.Title)
+	}
+	if got := first(doc.Metadata.Language); want.Language != before.Language && got != strings.TrimSpace(want.Language) {
+		return fmt.Errorf("edited OPF has language %q, want %q", got, want.Language)
+	}
+	if !slices.Equal(before.Authors, want.Authors) && !slices.Equal(doc.Metadata.Creator, want.Authors) {
+		return fmt.Errorf("edited OPF has authors %q, want %q", doc.Metadata.Creator, want.Authors)
+	}
+	return nil
+}
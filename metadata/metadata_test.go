@@ -0,0 +1,358 @@
+package metadata_test
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Bonalioteko/internal/epubtest"
+	"Bonalioteko/metadata"
+	"Bonalioteko/xattr"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const testOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>Demons</dc:title>
+    <dc:creator opf:role="aut">Fyodor Dostoevsky</dc:creator>
+    <dc:language>en</dc:language>
+    <dc:publisher>Standard Ebooks</dc:publisher>
+    <dc:date opf:event="publication">1872-01-01</dc:date>
+    <dc:identifier id="uid" opf:scheme="ISBN">9780000000001</dc:identifier>
+    <dc:description>A novel.</dc:description>
+    <dc:subject>fiction</dc:subject>
+    <meta name="calibre:series" content="Novels"/>
+    <meta name="calibre:series_index" content="3"/>
+  </metadata>
+  <manifest>
+    <item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="ch1"/>
+  </spine>
+</package>`
+
+const testChapter = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml"><head><title>Chapter One</title></head>
+<body><h1>Chapter One</h1><p>It was a dark and stormy night.</p></body></html>`
+
+// writeEpub writes a minimal EPUB with the given OPF to dir/name.
+func writeEpub(t *testing.T, dir, name, opf string) string {
+	t.Helper()
+	return writeEpubFiles(t, dir, name, opf, nil)
+}
+
+// writeEpubFiles writes a minimal EPUB with the given OPF and extra files,
+// keyed by their path inside the EPUB, to dir/name.
+func writeEpubFiles(t *testing.T, dir, name, opf string, extra map[string]string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	files := map[string]string{
+		"OEBPS/content.opf": opf,
+		"OEBPS/ch1.xhtml":   testChapter,
+	}
+	maps.Copy(files, extra)
+	epubtest.Write(t, path, "OEBPS/content.opf", files)
+	return path
+}
+
+func TestFromFile(t *testing.T) {
+	path := writeEpub(t, t.TempDir(), "demons.epub", testOPF)
+
+	got, err := metadata.FromFile(path)
+	if err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+
+	want := metadata.Book{
+		Path:        path,
+		Title:       "Demons",
+		Authors:     []string{"Fyodor Dostoevsky"},
+		Series:      "Novels",
+		SeriesIndex: "3",
+		Language:    "en",
+		Publisher:   "Standard Ebooks",
+		PublishDate: "1872-01-01",
+		Identifiers: []metadata.Identifier{{Scheme: "ISBN", Value: "9780000000001"}},
+		Description: "A novel.",
+		Subjects:    []string{"fiction"},
+	}
+	if !cmp.Equal(want, got, cmpopts.IgnoreFields(metadata.Book{}, "Size", "ModTime")) {
+		t.Error(cmp.Diff(want, got, cmpopts.IgnoreFields(metadata.Book{}, "Size", "ModTime")))
+	}
+	if got.Size == 0 || got.ModTime.IsZero() {
+		t.Errorf("expected size and mtime to be set, got %d and %v", got.Size, got.ModTime)
+	}
+	if got.ISBN() != "9780000000001" {
+		t.Errorf("ISBN: want 9780000000001, got %q", got.ISBN())
+	}
+}
+
+func TestFromFile_Broken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.epub")
+	os.WriteFile(path, []byte("dummy content"), 0o644)
+
+	got, err := metadata.FromFile(path)
+	if err == nil {
+		t.Error("expected an error for a broken epub")
+	}
+	if got.Title != "broken.epub" {
+		t.Errorf("want title to fall back to the file name, got %q", got.Title)
+	}
+}
+
+const calibreOPF = `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid_id" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:identifier opf:scheme="uuid" id="uuid_id">0b8e2a5c-1111-2222-3333-444455556666</dc:identifier>
+    <dc:title>The Possessed</dc:title>
+    <dc:creator opf:file-as="Dostoevsky, Fyodor" opf:role="aut">Fyodor Dostoevsky</dc:creator>
+    <dc:language>eng</dc:language>
+    <dc:subject>russian</dc:subject>
+    <dc:subject>unread</dc:subject>
+    <meta name="calibre:series" content="Novels"/>
+    <meta name="calibre:series_index" content="4.0"/>
+    <meta name="calibre:rating" content="8"/>
+  </metadata>
+</package>`
+
+func TestFromFile_CalibreSidecar(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Fyodor Dostoevsky", "Demons (12)")
+	os.MkdirAll(dir, 0o755)
+	path := writeEpub(t, dir, "Demons - Fyodor Dostoevsky.epub", testOPF)
+	os.WriteFile(filepath.Join(dir, metadata.SidecarName), []byte(calibreOPF), 0o644)
+
+	got, err := metadata.FromFile(path)
+	if err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+	if got.Title != "The Possessed" || got.Language != "eng" || got.SeriesIndex != "4.0" {
+		t.Errorf("sidecar metadata not preferred: %+v", got)
+	}
+	if !cmp.Equal([]string{"russian", "unread"}, got.Subjects) {
+		t.Error(cmp.Diff([]string{"russian", "unread"}, got.Subjects))
+	}
+	if got.Publisher != "Standard Ebooks" {
+		t.Errorf("want embedded publisher to be kept, got %q", got.Publisher)
+	}
+}
+
+func TestFromFile_SharedSidecar(t *testing.T) {
+	dir := t.TempDir()
+	demons := writeEpub(t, dir, "demons.epub", testOPF)
+	idiot := writeEpub(t, dir, "idiot.epub", strings.Replace(testOPF, "Demons", "The Idiot", 1))
+	os.WriteFile(filepath.Join(dir, metadata.SidecarName), []byte(calibreOPF), 0o644)
+
+	// A metadata.opf among several books describes none of them.
+	for path, title := range map[string]string{demons: "Demons", idiot: "The Idiot"} {
+		got, err := metadata.FromFile(path)
+		if err != nil {
+			t.Fatalf("got error:%s", err)
+		}
+		if got.Title != title || !cmp.Equal([]string{"fiction"}, got.Subjects) {
+			t.Errorf("FromFile(%s): got %q with subjects %v; want %q with the ones of the EPUB", filepath.Base(path), got.Title, got.Subjects, title)
+		}
+	}
+	if results := metadata.ImportCalibre(dir, true); len(results) != 0 {
+		t.Errorf("ImportCalibre: got %+v; want nothing imported", results)
+	}
+
+	// It is the one of the only book of its folder.
+	os.Remove(idiot)
+	if got, _ := metadata.FromFile(demons); got.Title != "The Possessed" {
+		t.Errorf("FromFile of the only book: got %q; want the title of the sidecar", got.Title)
+	}
+}
+
+func TestImportCalibre(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "Fyodor Dostoevsky", "Demons (12)")
+	os.MkdirAll(dir, 0o755)
+	path := writeEpub(t, dir, "Demons - Fyodor Dostoevsky.epub", testOPF)
+	opf := strings.Replace(calibreOPF, "<dc:subject>unread</dc:subject>", "<dc:subject>unread</dc:subject>\n    <dc:subject>Voyages, Imaginary</dc:subject>", 1)
+	os.WriteFile(filepath.Join(dir, metadata.SidecarName), []byte(opf), 0o644)
+	if err := xattr.SetTags(path, []string{"fiction"}); err != nil {
+		t.Fatal(err)
+	}
+
+	results := metadata.ImportCalibre(root, false)
+	want := []metadata.ImportResult{{
+		Path:       path,
+		Tags:       []string{"russian", "unread"},
+		Series:     "Novels",
+		Rating:     "4",
+		Rejected:   []string{"Voyages, Imaginary"},
+		TagsBefore: []string{"fiction"},
+		TagsAfter:  []string{"fiction", "russian", "unread"},
+	}}
+	// The tags already on the file are merged in no particular order.
+	sorted := cmpopts.SortSlices(func(a, b string) bool { return a < b })
+	if diff := cmp.Diff(want, results, sorted); diff != "" {
+		t.Errorf("ImportCalibre: mismatch (-want +got):\n%s", diff)
+	}
+	tags, err := xattr.GetTagsFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"fiction", "russian", "unread"}, tags, sorted); diff != "" {
+		t.Errorf("tags: mismatch (-want +got):\n%s", diff)
+	}
+
+	// The imported series is not an override of the one of the file.
+	book, err := metadata.FromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !book.Overrides.IsZero() || book.Series != "Novels" {
+		t.Errorf("FromFile after the import: got series %q and overrides %+v; want no overrides", book.Series, book.Overrides)
+	}
+}
+
+func TestFromFile_CalibreLayout(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Leo Tolstoy", "Resurrection (7)")
+	os.MkdirAll(dir, 0o755)
+	path := filepath.Join(dir, "resurrection.epub")
+	os.WriteFile(path, []byte("dummy content"), 0o644)
+
+	got, _ := metadata.FromFile(path)
+	if got.Title != "Resurrection" || !cmp.Equal([]string{"Leo Tolstoy"}, got.Authors) {
+		t.Errorf("want title and author from the folders, got %q by %v", got.Title, got.Authors)
+	}
+}
+
+func TestCalibreStars(t *testing.T) {
+	testCases := map[string]string{"": "", "0": "", "2": "1", "8": "4", "9": "5", "10": "5"}
+	for rating, want := range testCases {
+		if got := metadata.CalibreStars(rating); got != want {
+			t.Errorf("CalibreStars(%q): want %q, got %q", rating, want, got)
+		}
+	}
+}
+
+func TestFromFile_Overrides(t *testing.T) {
+	path := writeEpub(t, t.TempDir(), "demons.epub", testOPF)
+	err := metadata.WriteOverrides(path, metadata.Overrides{Title: "The Possessed", Authors: []string{"Dostoevsky, Fyodor", "Garnett, Constance"}})
+	if err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+
+	got, err := metadata.FromFile(path)
+	if err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+	if got.Title != "The Possessed" || !cmp.Equal([]string{"Dostoevsky, Fyodor", "Garnett, Constance"}, got.Authors) {
+		t.Errorf("overrides not applied: %q by %v", got.Title, got.Authors)
+	}
+	if got.Series != "Novels" {
+		t.Errorf("want series from the file, got %q", got.Series)
+	}
+
+	file := got.FileValues()
+	if file.Title != "Demons" || !cmp.Equal([]string{"Fyodor Dostoevsky"}, file.Authors) {
+		t.Errorf("original values lost: %q by %v", file.Title, file.Authors)
+	}
+
+	metadata.WriteOverrides(path, metadata.Overrides{})
+	got, _ = metadata.FromFile(path)
+	if got.Title != "Demons" || !got.Overrides.IsZero() {
+		t.Errorf("want overrides removed, got %q with %+v", got.Title, got.Overrides)
+	}
+}
+
+func TestDescriptionText(t *testing.T) {
+	testCases := map[string]string{
+		"":         "",
+		"A novel.": "A novel.",
+		"<p>First  <i>paragraph</i>,\n wrapped.</p><p>Second&nbsp;one &amp; more.</p>": "First paragraph, wrapped.\n\nSecond one & more.",
+		"<div><p>Nested</p></div><br/>Line<br>break":                                   "Nested\n\nLine\nbreak",
+		"<ul><li>one</li><li>two <b>bold</b></li></ul>After":                           "• one\n• two bold\n\nAfter",
+		"Plain text.\n\n  Second paragraph\nwith a newline.":                           "Plain text.\n\nSecond paragraph with a newline.",
+		"<style>p { color: red }</style><p>Shown</p><script>hidden()</script>":         "Shown",
+		"<pre>  keep\n  this</pre>":                                                    "  keep\n  this",
+	}
+	for description, want := range testCases {
+		if diff := cmp.Diff(want, metadata.DescriptionText(description)); diff != "" {
+			t.Errorf("DescriptionText(%q) mismatch (-want +got):\n%s", description, diff)
+		}
+	}
+}
+
+func TestReadCover(t *testing.T) {
+	manifest := `<item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="img" href="images/cover%20art.jpg" media-type="image/jpeg"PROPERTIES/>
+    <item id="other" href="images/plate.png" media-type="image/png"/>`
+	opf := func(meta, properties string) string {
+		return `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>T</dc:title>` + meta + `</metadata>
+  <manifest>` + strings.Replace(manifest, "PROPERTIES", properties, 1) + `</manifest>
+  <spine><itemref idref="ch1"/></spine>
+</package>`
+	}
+	files := map[string]string{"OEBPS/images/cover art.jpg": "JPEG", "OEBPS/images/plate.png": "PNG"}
+
+	testCases := map[string]struct {
+		opf       string
+		want      string
+		mediaType string
+	}{
+		"property":   {opf("", ` properties="cover-image"`), "JPEG", "image/jpeg"},
+		"meta":       {opf(`<meta name="cover" content="other"/>`, ""), "PNG", "image/png"},
+		"meta href":  {opf(`<meta name="cover" content="images/plate.png"/>`, ""), "PNG", "image/png"},
+		"file name":  {opf("", ""), "JPEG", "image/jpeg"},
+		"meta first": {opf(`<meta name="cover" content="other"/>`, ` properties="cover-image"`), "JPEG", "image/jpeg"},
+	}
+	dir := t.TempDir()
+	for name, tc := range testCases {
+		path := writeEpubFiles(t, dir, name+".epub", tc.opf, files)
+		data, mediaType, err := metadata.ReadCover(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(data) != tc.want || mediaType != tc.mediaType {
+			t.Errorf("%s: want %s %s, got %s %s", name, tc.want, tc.mediaType, data, mediaType)
+		}
+	}
+
+	path := writeEpub(t, dir, "none.epub", testOPF)
+	if _, _, err := metadata.ReadCover(path); !errors.Is(err, metadata.ErrNoCover) {
+		t.Errorf("without cover: want ErrNoCover, got %v", err)
+	}
+}
+
+func TestScan_Added(t *testing.T) {
+	state := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", state)
+	dir := t.TempDir()
+	path := writeEpub(t, dir, "demons.epub", testOPF)
+	modTime := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	books := metadata.Scan(dir)
+	if len(books) != 1 || !books[0].Added.Equal(modTime) {
+		t.Fatalf("Scan: got %v, want a book added on %v", books, modTime)
+	}
+	// The date is kept in the state directory.
+	if _, err := os.Stat(filepath.Join(state, "Bonalioteko", metadata.AddedFileName)); err != nil {
+		t.Errorf("index of the dates added: %v", err)
+	}
+
+	// The date stays put once the book is modified.
+	later := modTime.Add(48 * time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if books := metadata.Scan(dir); !books[0].Added.Equal(modTime) {
+		t.Errorf("Scan again: got added on %v, want %v", books[0].Added, modTime)
+	}
+}
@@ -0,0 +1,454 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"Bonalioteko/internal/atomicfile"
+	"Bonalioteko/xattr"
+
+	"github.com/pirmd/epub"
+)
+
+// containerPath is the location of the container document in an EPUB.
+const containerPath = "META-INF/container.xml"
+
+// span is a byte range of an OPF document.
+type span struct{ start, end int }
+
+// element is a child of the metadata element of an OPF document.
+type element struct {
+	span
+	// contentStart is the offset right after the start tag.
+	contentStart int
+	// name is the qualified name of the element, such as "dc:title".
+	name string
+}
+
+// metadataLayout locates the children of the metadata element of an OPF.
+type metadataLayout struct {
+	// end is the offset of the metadata closing tag.
+	end int
+	// separator precedes each child element, usually a line break and
+	// the indentation of the first child.
+	separator string
+	// dcPrefix is the prefix bound to the Dublin Core namespace.
+	dcPrefix string
+	// matches are the children selected by the match function.
+	matches []element
+}
+
+// scanMetadata walks the metadata element of opf and records the spans of
+// the direct children for which match returns true.
+func scanMetadata(opf []byte, match func(xml.StartElement) bool) (metadataLayout, error) {
+	layout := metadataLayout{end: -1, dcPrefix: "dc"}
+
+	d := xml.NewDecoder(bytes.NewReader(opf))
+	d.Strict = false
+
+	depth, metadataDepth := 0, -1
+	var current *element
+	firstChild := true
+	for {
+		offset := int(d.InputOffset())
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return layout, fmt.Errorf("parsing OPF: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case metadataDepth < 0 && t.Name.Local == "metadata":
+				metadataDepth = depth
+			case metadataDepth > 0 && depth == metadataDepth+1:
+				if firstChild {
+					layout.separator = lineStart(opf, offset)
+					firstChild = false
+				}
+				if t.Name.Local == "title" && t.Name.Space != "" {
+					layout.dcPrefix = t.Name.Space
+				}
+				if match(t) {
+					name := t.Name.Local
+					if t.Name.Space != "" {
+						name = t.Name.Space + ":" + name
+					}
+					current = &element{span: span{start: offset}, contentStart: int(d.InputOffset()), name: name}
+				}
+			}
+
+		case xml.EndElement:
+			if depth == metadataDepth+1 && current != nil {
+				current.end = int(d.InputOffset())
+				layout.matches = append(layout.matches, *current)
+				current = nil
+			}
+			if depth == metadataDepth {
+				layout.end = offset
+				return layout, nil
+			}
+			depth--
+		}
+	}
+
+	return layout, errors.New("parsing OPF: no metadata element")
+}
+
+// lineStart returns the line break and indentation preceding offset, or an
+// empty string when offset is not the first element of its line.
+func lineStart(data []byte, offset int) string {
+	nl := bytes.LastIndexByte(data[:offset], '\n')
+	if nl < 0 || len(bytes.TrimSpace(data[nl:offset])) != 0 {
+		return ""
+	}
+	return string(data[nl:offset])
+}
+
+// replaceMetadata removes the children of the metadata element selected by
+// match and inserts elements in their place, or at the end of the metadata
+// element when nothing matched. elements receives the Dublin Core prefix in
+// use by the document.
+func replaceMetadata(opf []byte, match func(xml.StartElement) bool, elements func(dc string) []string) ([]byte, error) {
+	layout, err := scanMetadata(opf, match)
+	if err != nil {
+		return nil, err
+	}
+
+	// Removed elements take their line with them and inserted elements
+	// bring their own, so the surrounding layout stays untouched.
+	removals := make([]span, len(layout.matches))
+	for i, m := range layout.matches {
+		removals[i] = span{m.start - len(lineStart(opf, m.start)), m.end}
+	}
+	insertAt := layout.end - len(lineStart(opf, layout.end))
+	if len(removals) > 0 {
+		insertAt = removals[0].start
+	}
+
+	var inserted strings.Builder
+	for _, e := range elements(layout.dcPrefix) {
+		inserted.WriteString(layout.separator + e)
+	}
+
+	var out bytes.Buffer
+	pos := 0
+	for _, r := range removals {
+		out.Write(opf[pos:r.start])
+		if r.start == insertAt {
+			out.WriteString(inserted.String())
+		}
+		pos = r.end
+	}
+	if len(removals) == 0 {
+		out.Write(opf[:insertAt])
+		out.WriteString(inserted.String())
+		pos = insertAt
+	}
+	out.Write(opf[pos:])
+
+	return out.Bytes(), nil
+}
+
+// setText sets the text of the first child of the metadata element with
+// the local name, keeping its start tag and attributes. The element is
+// created when missing, and every element with that name is removed when
+// value is empty.
+func setText(opf []byte, local string, value string) ([]byte, error) {
+	match := func(e xml.StartElement) bool { return e.Name.Local == local }
+	if value == "" {
+		return replaceMetadata(opf, match, func(string) []string { return nil })
+	}
+
+	layout, err := scanMetadata(opf, match)
+	if err != nil {
+		return nil, err
+	}
+	if len(layout.matches) == 0 {
+		return replaceMetadata(opf, match, func(dc string) []string {
+			return []string{dcElement(dc, local, value)}
+		})
+	}
+
+	e := layout.matches[0]
+	startTag := opf[e.start:e.contentStart]
+	if bytes.HasSuffix(startTag, []byte("/>")) {
+		startTag = append(bytes.TrimSuffix(bytes.Clone(startTag), []byte("/>")), '>')
+	}
+
+	var out bytes.Buffer
+	out.Write(opf[:e.start])
+	out.Write(startTag)
+	xml.EscapeText(&out, []byte(value))
+	out.WriteString("</" + e.name + ">")
+	out.Write(opf[e.end:])
+	return out.Bytes(), nil
+}
+
+// setTexts sets the text of the Dublin Core elements local of opf to values,
+// in order. The elements keep their attributes, such as the opf:file-as of
+// a dc:creator, as long as there is a value at their position; the ones
+// beyond the values are removed and values beyond the elements are added
+// after them.
+func setTexts(opf []byte, local string, values []string) ([]byte, error) {
+	match := func(e xml.StartElement) bool { return e.Name.Local == local }
+	layout, err := scanMetadata(opf, match)
+	if err != nil {
+		return nil, err
+	}
+	if len(layout.matches) == 0 {
+		return replaceMetadata(opf, match, func(dc string) []string {
+			var elements []string
+			for _, v := range values {
+				elements = append(elements, dcElement(dc, local, v))
+			}
+			return elements
+		})
+	}
+
+	var out bytes.Buffer
+	pos := 0
+	for i, e := range layout.matches {
+		if i >= len(values) {
+			// Removed elements take their line with them.
+			out.Write(opf[pos : e.start-len(lineStart(opf, e.start))])
+			pos = e.end
+			continue
+		}
+		startTag := opf[e.start:e.contentStart]
+		if bytes.HasSuffix(startTag, []byte("/>")) {
+			startTag = append(bytes.TrimSuffix(bytes.Clone(startTag), []byte("/>")), '>')
+		}
+		out.Write(opf[pos:e.start])
+		out.Write(startTag)
+		xml.EscapeText(&out, []byte(values[i]))
+		out.WriteString("</" + e.name + ">")
+		pos = e.end
+	}
+	for _, v := range values[min(len(values), len(layout.matches)):] {
+		out.WriteString(layout.separator + dcElement(layout.dcPrefix, local, v))
+	}
+	out.Write(opf[pos:])
+	return out.Bytes(), nil
+}
+
+// attr returns the value of the attribute with the local name.
+func attr(e xml.StartElement, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// dcElement renders a Dublin Core element with an escaped value.
+func dcElement(dc, name, value string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(value))
+	return fmt.Sprintf("<%s:%s>%s</%s:%s>", dc, name, escaped.String(), dc, name)
+}
+
+// SetSubjects replaces the dc:subject elements of opf with subjects.
+func SetSubjects(opf []byte, subjects []string) ([]byte, error) {
+	return replaceMetadata(opf,
+		func(e xml.StartElement) bool { return e.Name.Local == "subject" },
+		func(dc string) []string {
+			var elements []string
+			for _, s := range subjects {
+				elements = append(elements, dcElement(dc, "subject", s))
+			}
+			return elements
+		})
+}
+
+// rootfile returns the path of the OPF inside the EPUB.
+func rootfile(zr *zip.Reader) (string, error) {
+	f, err := zr.Open(containerPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := xml.NewDecoder(f).Decode(&container); err != nil {
+		return "", fmt.Errorf("parsing %s: %w", containerPath, err)
+	}
+	if len(container.Rootfiles) == 0 || container.Rootfiles[0].FullPath == "" {
+		return "", fmt.Errorf("%s has no rootfile", containerPath)
+	}
+	return container.Rootfiles[0].FullPath, nil
+}
+
+// ReadOPF returns the raw OPF document of the EPUB at path.
+func ReadOPF(path string) ([]byte, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	name, err := rootfile(&zr.Reader)
+	if err != nil {
+		return nil, err
+	}
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// RewriteOPF rewrites the OPF document of the EPUB at path with edit. The
+// new EPUB is written to a temporary file next to the original, checked to
+// be readable, given the extended attributes, permissions and modification
+// time of the original and then renamed over it, so that the original is
+// left untouched on failure.
+func RewriteOPF(path string, edit func(opf []byte) ([]byte, error)) error {
+	f, err := rewriteOPF(path, edit)
+	if err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// rewriteOPF is RewriteOPF up to the rename: it returns the new EPUB, for
+// it to be committed or aborted.
+func rewriteOPF(path string, edit func(opf []byte) ([]byte, error)) (_ *atomicfile.File, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	opfName, err := rootfile(&zr.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := atomicfile.Create(path, info.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			f.Abort()
+		}
+	}()
+
+	if err := rewriteZip(&zr.Reader, f, opfName, edit); err != nil {
+		return nil, err
+	}
+	if err := validateEpub(f.Name()); err != nil {
+		return nil, err
+	}
+	if err := xattr.CopyAll(path, f.Name()); err != nil {
+		return nil, fmt.Errorf("copying extended attributes: %w", err)
+	}
+	if err := os.Chtimes(f.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// rewriteZip copies the entries of zr to w, passing the OPF through edit.
+// The mimetype entry is written first and uncompressed as the EPUB
+// specification requires.
+func rewriteZip(zr *zip.Reader, w io.Writer, opfName string, edit func([]byte) ([]byte, error)) error {
+	zw := zip.NewWriter(w)
+
+	files := make([]*zip.File, 0, len(zr.File))
+	for _, f := range zr.File {
+		if f.Name == "mimetype" {
+			files = append([]*zip.File{f}, files...)
+			continue
+		}
+		files = append(files, f)
+	}
+
+	for _, f := range files {
+		switch f.Name {
+		case opfName:
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			opf, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if opf, err = edit(opf); err != nil {
+				return err
+			}
+			header := f.FileHeader
+			header.Method = zip.Deflate
+			fw, err := zw.CreateHeader(&header)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(opf); err != nil {
+				return err
+			}
+
+		case "mimetype":
+			header := f.FileHeader
+			header.Method = zip.Store
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			fw, err := zw.CreateHeader(&header)
+			if err == nil {
+				_, err = io.Copy(fw, rc)
+			}
+			rc.Close()
+			if err != nil {
+				return err
+			}
+
+		default:
+			if err := zw.Copy(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return zw.Close()
+}
+
+// validateEpub checks that the EPUB at path can be opened and its metadata
+// read.
+func validateEpub(path string) error {
+	e, err := epub.Open(path)
+	if err != nil {
+		return fmt.Errorf("validating rewritten EPUB: %w", err)
+	}
+	defer e.Close()
+
+	if _, err := e.Information(); err != nil {
+		return fmt.Errorf("validating rewritten EPUB: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package metadata_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Bonalioteko/metadata"
+
+	xattrpkg "github.com/pkg/xattr"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSetSubjects(t *testing.T) {
+	type testCase struct {
+		name     string
+		opf      string
+		subjects []string
+		want     string
+	}
+
+	testCases := []testCase{
+		{
+			name: "replace",
+			opf: `<metadata xmlns:dc="x">
+    <dc:title>T</dc:title>
+    <dc:subject>old</dc:subject>
+    <dc:language>en</dc:language>
+    <dc:subject>older</dc:subject>
+  </metadata>`,
+			subjects: []string{"a", "b&c"},
+			want: `<metadata xmlns:dc="x">
+    <dc:title>T</dc:title>
+    <dc:subject>a</dc:subject>
+    <dc:subject>b&amp;c</dc:subject>
+    <dc:language>en</dc:language>
+  </metadata>`,
+		},
+		{
+			name: "insert",
+			opf: `<metadata>
+  <dc:title>T</dc:title>
+</metadata>`,
+			subjects: []string{"a"},
+			want: `<metadata>
+  <dc:title>T</dc:title>
+  <dc:subject>a</dc:subject>
+</metadata>`,
+		},
+		{
+			name:     "remove",
+			opf:      `<metadata><opf:title>T</opf:title><opf:subject>a</opf:subject></metadata>`,
+			subjects: nil,
+			want:     `<metadata><opf:title>T</opf:title></metadata>`,
+		},
+		{
+			name:     "prefix",
+			opf:      `<metadata><opf:title>T</opf:title></metadata>`,
+			subjects: []string{"a"},
+			want:     `<metadata><opf:title>T</opf:title><opf:subject>a</opf:subject></metadata>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		got, err := metadata.SetSubjects([]byte(tc.opf), tc.subjects)
+		if err != nil {
+			t.Fatalf("%s: got error:%s", tc.name, err)
+		}
+		if !cmp.Equal(tc.want, string(got)) {
+			t.Errorf("%s: %s", tc.name, cmp.Diff(tc.want, string(got)))
+		}
+	}
+}
+
+func TestWriteSubjects_KeepsXattrsAndMtime(t *testing.T) {
+	path := writeEpub(t, t.TempDir(), "demons.epub", testOPF)
+	xattrpkg.Set(path, "user.xdg.tags", []byte("russian,unread"))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(path, mtime, mtime)
+
+	if err := metadata.WriteSubjects(path, []string{"russian", "unread"}); err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+
+	got, err := metadata.EmbeddedSubjects(path)
+	if err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+	if !cmp.Equal([]string{"russian", "unread"}, got) {
+		t.Error(cmp.Diff([]string{"russian", "unread"}, got))
+	}
+
+	tags, _ := xattrpkg.Get(path, "user.xdg.tags")
+	if string(tags) != "russian,unread" {
+		t.Errorf("xattrs not kept, got %q", tags)
+	}
+	info, _ := os.Stat(path)
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("mtime not kept: want %v, got %v", mtime, info.ModTime())
+	}
+
+	opf, _ := metadata.ReadOPF(path)
+	if !strings.Contains(string(opf), "<dc:title>Demons</dc:title>") {
+		t.Errorf("rest of the OPF was not kept:\n%s", opf)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".*tmp-*"))
+	if len(leftovers) != 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	path := writeEpub(t, t.TempDir(), "demons.epub", testOPF)
+	xattrpkg.Set(path, "user.xdg.tags", []byte("fiction,unread"))
+
+	want := []metadata.Mismatch{{Path: path, OnlyXattr: []string{"unread"}}}
+	got := metadata.Reconcile([]string{path})
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestWriteEdit(t *testing.T) {
+	path := writeEpub(t, t.TempDir(), "demons.epub", testOPF)
+	xattrpkg.Set(path, "user.xdg.tags", []byte("russian"))
+	book, _ := metadata.FromFile(path)
+
+	before := metadata.EditFromBook(book)
+	after := before
+	after.Title = "The Possessed"
+	after.Authors = []string{"Fyodor Dostoevsky", "Constance Garnett"}
+	after.Series = "Great Novels"
+	after.SeriesIndex = "2"
+	after.Language = "en-GB"
+	after.Description = ""
+
+	if err := metadata.WriteEdit(path, before, after); err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+
+	got, err := metadata.FromFile(path)
+	if err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+	if !cmp.Equal(after, metadata.EditFromBook(got)) {
+		t.Error(cmp.Diff(after, metadata.EditFromBook(got)))
+	}
+	if got.Publisher != book.Publisher || got.ISBN() != book.ISBN() {
+		t.Errorf("untouched fields changed: %+v", got)
+	}
+	tags, _ := xattrpkg.Get(path, "user.xdg.tags")
+	if string(tags) != "russian" {
+		t.Errorf("xattrs not kept, got %q", tags)
+	}
+}
+
+func TestEditValidate(t *testing.T) {
+	testCases := []metadata.Edit{
+		{Title: " "},
+		{Title: "T", Language: "english please"},
+		{Title: "T", Series: "S", SeriesIndex: "two"},
+		{Title: "T", SeriesIndex: "2"},
+		{Title: "T", Authors: []string{""}},
+	}
+	for _, tc := range testCases {
+		if err := tc.Validate(); err == nil {
+			t.Errorf("Validate(%+v): expected an error", tc)
+		}
+	}
+	if err := (metadata.Edit{Title: "T", Language: "pt-BR", Series: "S", SeriesIndex: "1.5"}).Validate(); err != nil {
+		t.Errorf("got error:%s", err)
+	}
+}
+
+func TestApplyEdit_Creators(t *testing.T) {
+	opf := `<metadata xmlns:dc="x" xmlns:opf="y">
+    <dc:title>Demons</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Dostoevsky, Fyodor">Fyodor Dostoevsky</dc:creator>
+    <dc:creator opf:role="trl" opf:file-as="Garnett, Constance">Constance Garnett</dc:creator>
+    <dc:language>en</dc:language>
+  </metadata>`
+	before := metadata.Edit{Title: "Demons", Authors: []string{"Fyodor Dostoevsky", "Constance Garnett"}}
+
+	testCases := []struct {
+		name    string
+		authors []string
+		want    string
+	}{
+		{
+			name:    "rename",
+			authors: []string{"Fyodor Dostoyevsky", "Constance Garnett"},
+			want: `<metadata xmlns:dc="x" xmlns:opf="y">
+    <dc:title>Demons</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Dostoevsky, Fyodor">Fyodor Dostoyevsky</dc:creator>
+    <dc:creator opf:role="trl" opf:file-as="Garnett, Constance">Constance Garnett</dc:creator>
+    <dc:language>en</dc:language>
+  </metadata>`,
+		},
+		{
+			name:    "add",
+			authors: []string{"Fyodor Dostoevsky", "Constance Garnett", "Ann & Co"},
+			want: `<metadata xmlns:dc="x" xmlns:opf="y">
+    <dc:title>Demons</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Dostoevsky, Fyodor">Fyodor Dostoevsky</dc:creator>
+    <dc:creator opf:role="trl" opf:file-as="Garnett, Constance">Constance Garnett</dc:creator>
+    <dc:creator>Ann &amp; Co</dc:creator>
+    <dc:language>en</dc:language>
+  </metadata>`,
+		},
+		{
+			name:    "remove",
+			authors: []string{"Fyodor Dostoevsky"},
+			want: `<metadata xmlns:dc="x" xmlns:opf="y">
+    <dc:title>Demons</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Dostoevsky, Fyodor">Fyodor Dostoevsky</dc:creator>
+    <dc:language>en</dc:language>
+  </metadata>`,
+		},
+	}
+	for _, tc := range testCases {
+		after := before
+		after.Authors = tc.authors
+		got, err := metadata.ApplyEdit([]byte(opf), before, after)
+		if err != nil {
+			t.Fatalf("%s: got error:%s", tc.name, err)
+		}
+		if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+			t.Errorf("%s: mismatch (-want +got):\n%s", tc.name, diff)
+		}
+	}
+}
+
+func TestWriteEdit_SidecarFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Fyodor Dostoevsky", "Demons (12)")
+	os.MkdirAll(dir, 0o755)
+	path := writeEpub(t, dir, "demons.epub", testOPF)
+	// A sidecar without a metadata element can't be edited.
+	os.WriteFile(filepath.Join(dir, metadata.SidecarName), []byte(`<package/>`), 0o644)
+	book, _ := metadata.FromFile(path)
+
+	before := metadata.EditFromBook(book)
+	after := before
+	after.Title = "The Possessed"
+	if err := metadata.WriteEdit(path, before, after); err == nil {
+		t.Fatal("got no error")
+	}
+
+	// The EPUB is left as it was, and no temporary file is left behind.
+	got, err := metadata.FromFile(path)
+	if err != nil {
+		t.Fatalf("got error:%s", err)
+	}
+	if got.Title != "Demons" {
+		t.Errorf("EPUB edited although the sidecar wasn't: got title %q", got.Title)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("want the EPUB and its sidecar only, got %v", entries)
+	}
+}
@@ -0,0 +1,109 @@
+package metadata
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"Bonalioteko/xattr"
+)
+
+// authorSeparator separates the authors stored in the author override, as
+// author names may contain commas.
+const authorSeparator = "; "
+
+// Overrides are metadata fields stored in user.bonalioteko.override.*
+// extended attributes. They are layered over the metadata read from the file, for
+// books that must not be modified.
+type Overrides struct {
+	Title       string
+	Authors     []string
+	Series      string
+	SeriesIndex string
+}
+
+// IsZero reports whether no field is overridden.
+func (o Overrides) IsZero() bool {
+	return o.Title == "" && len(o.Authors) == 0 && o.Series == "" && o.SeriesIndex == ""
+}
+
+// ReadOverrides returns the overrides stored on the file at path.
+func ReadOverrides(path string) (Overrides, error) {
+	var o Overrides
+	var errs []error
+	get := func(name string) string {
+		v, err := xattr.GetAttribute(path, name)
+		errs = append(errs, err)
+		return strings.TrimSpace(v)
+	}
+
+	o.Title = get(xattr.AttrOverrideTitle)
+	o.Authors = SplitAuthors(get(xattr.AttrOverrideAuthor))
+	o.Series = get(xattr.AttrOverrideSeries)
+	o.SeriesIndex = get(xattr.AttrOverrideSeriesIndex)
+
+	return o, errors.Join(errs...)
+}
+
+// WriteOverrides stores o on the file at path. Empty fields remove their
+// attribute so that the value read from the file shows again.
+func WriteOverrides(path string, o Overrides) error {
+	return errors.Join(
+		xattr.SetAttribute(path, xattr.AttrOverrideTitle, strings.TrimSpace(o.Title)),
+		xattr.SetAttribute(path, xattr.AttrOverrideAuthor, strings.Join(o.Authors, authorSeparator)),
+		xattr.SetAttribute(path, xattr.AttrOverrideSeries, strings.TrimSpace(o.Series)),
+		xattr.SetAttribute(path, xattr.AttrOverrideSeriesIndex, strings.TrimSpace(o.SeriesIndex)),
+	)
+}
+
+// SplitAuthors splits a list of authors separated by semicolons.
+func SplitAuthors(s string) []string {
+	var authors []string
+	for a := range strings.SplitSeq(s, ";") {
+		if a = strings.TrimSpace(a); a != "" {
+			authors = append(authors, a)
+		}
+	}
+	return authors
+}
+
+// applyOverrides layers o over book, keeping the replaced values in
+// book.Original.
+func applyOverrides(book *Book, o Overrides) {
+	book.Overrides = o
+	if o.Title != "" {
+		book.Original.Title = book.Title
+		book.Title = o.Title
+	}
+	if len(o.Authors) > 0 {
+		book.Original.Authors = book.Authors
+		book.Authors = o.Authors
+	}
+	if o.Series != "" {
+		book.Original.Series = book.Series
+		book.Series = o.Series
+	}
+	if o.SeriesIndex != "" {
+		book.Original.SeriesIndex = book.SeriesIndex
+		book.SeriesIndex = o.SeriesIndex
+	}
+}
+
+// FileValues returns the book as read from the file, without overrides.
+func (b Book) FileValues() Book {
+	o := b.Overrides
+	if o.Title != "" {
+		b.Title = b.Original.Title
+	}
+	if len(o.Authors) > 0 {
+		b.Authors = slices.Clone(b.Original.Authors)
+	}
+	if o.Series != "" {
+		b.Series = b.Original.Series
+	}
+	if o.SeriesIndex != "" {
+		b.SeriesIndex = b.Original.SeriesIndex
+	}
+	b.Overrides, b.Original = Overrides{}, Overrides{}
+	return b
+}
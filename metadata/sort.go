@@ -0,0 +1,216 @@
+package metadata
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// SortKey is a property books can be sorted by.
+type SortKey string
+
+const (
+	SortTitle     SortKey = "title"
+	SortAuthor    SortKey = "author"
+	SortSeries    SortKey = "series"
+	SortPublished SortKey = "published"
+	SortAdded     SortKey = "added"
+	SortModified  SortKey = "modified"
+	SortSize      SortKey = "size"
+)
+
+// SortKeys lists the sort keys in the order they are cycled through.
+var SortKeys = []SortKey{SortTitle, SortAuthor, SortSeries, SortPublished, SortAdded, SortModified, SortSize}
+
+// SortOrder is a sort key and a direction.
+type SortOrder struct {
+	Key  SortKey
+	Desc bool
+}
+
+// ParseSortOrder parses orders such as "title" or "added desc". An empty
+// string is the title order.
+func ParseSortOrder(s string) (SortOrder, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 {
+		return SortOrder{Key: SortTitle}, nil
+	}
+
+	o := SortOrder{Key: SortKey(fields[0])}
+	if !slices.Contains(SortKeys, o.Key) {
+		return SortOrder{Key: SortTitle}, fmt.Errorf("unknown sort key %q", fields[0])
+	}
+	switch {
+	case len(fields) == 1:
+	case len(fields) == 2 && (fields[1] == "asc" || fields[1] == "desc"):
+		o.Desc = fields[1] == "desc"
+	default:
+		return SortOrder{Key: SortTitle}, fmt.Errorf("sort order %q is not a key followed by asc or desc", s)
+	}
+	return o, nil
+}
+
+func (o SortOrder) String() string {
+	if o.Desc {
+		return string(o.Key) + " desc"
+	}
+	return string(o.Key) + " asc"
+}
+
+// NextKey returns the order with the sort key following o's.
+func (o SortOrder) NextKey() SortOrder {
+	i := slices.Index(SortKeys, o.Key)
+	o.Key = SortKeys[(i+1)%len(SortKeys)]
+	return o
+}
+
+// Locale returns the language books are collated in: the given setting,
+// or the one of the environment, or the root collation order.
+func Locale(setting string) language.Tag {
+	candidates := []string{setting, os.Getenv("LC_ALL"), os.Getenv("LC_COLLATE"), os.Getenv("LANG")}
+	for _, c := range candidates {
+		// POSIX locales look like fr_FR.UTF-8@euro.
+		c, _, _ = strings.Cut(c, ".")
+		c, _, _ = strings.Cut(c, "@")
+		if c == "" || c == "C" || c == "POSIX" {
+			continue
+		}
+		if tag, err := language.Parse(strings.ReplaceAll(c, "_", "-")); err == nil {
+			return tag
+		}
+	}
+	return language.Und
+}
+
+// sortEntry holds the values a book is compared by.
+type sortEntry struct {
+	book Book
+	// missing books have no value for the key and go last either way.
+	missing bool
+	key     []byte
+	num     float64
+	title   []byte
+}
+
+// SortBooks sorts books in place. Text is collated for the locale, without
+// case or accents mattering, and titles are compared without their leading
+// article. Books lacking the sort key come last, and ties are broken by
+// title.
+func SortBooks(books []Book, o SortOrder, locale language.Tag) {
+	c := collate.New(locale, collate.Loose, collate.Numeric)
+	var buf collate.Buffer
+	key := func(s string) []byte {
+		return slices.Clone(c.KeyFromString(&buf, s))
+	}
+
+	entries := make([]sortEntry, len(books))
+	for i, b := range books {
+		e := sortEntry{book: b, title: key(SortableTitle(b.Title, b.Language))}
+		switch o.Key {
+		case SortTitle:
+			e.key = e.title
+		case SortAuthor:
+			e.missing = len(b.Authors) == 0
+			if !e.missing {
+				e.key = key(AuthorSortName(b.Authors[0]))
+			}
+		case SortSeries:
+			e.missing = b.Series == ""
+			e.key = key(SortableTitle(b.Series, b.Language))
+			e.num, _ = strconv.ParseFloat(b.SeriesIndex, 64)
+		case SortPublished:
+			e.missing = b.PublishDate == ""
+			e.key = []byte(b.PublishDate)
+		case SortAdded:
+			e.missing = b.Added.IsZero()
+			e.num = float64(b.Added.Unix())
+		case SortModified:
+			e.num = float64(b.ModTime.Unix())
+		case SortSize:
+			e.num = float64(b.Size)
+		}
+		entries[i] = e
+	}
+
+	slices.SortStableFunc(entries, func(a, b sortEntry) int {
+		if a.missing != b.missing {
+			if a.missing {
+				return 1
+			}
+			return -1
+		}
+		c := bytes.Compare(a.key, b.key)
+		if c == 0 {
+			c = cmp.Compare(a.num, b.num)
+		}
+		if o.Desc {
+			c = -c
+		}
+		if c == 0 {
+			c = bytes.Compare(a.title, b.title)
+		}
+		if c == 0 {
+			c = cmp.Compare(a.book.Path, b.book.Path)
+		}
+		return c
+	})
+	for i, e := range entries {
+		books[i] = e.book
+	}
+}
+
+// articles are the leading words titles are sorted without, by language.
+var articles = map[string][]string{
+	"en": {"the ", "a ", "an "},
+	"fr": {"le ", "la ", "les ", "l'", "l’", "un ", "une "},
+	"de": {"der ", "die ", "das ", "ein ", "eine "},
+	"es": {"el ", "la ", "los ", "las ", "un ", "una "},
+	"it": {"il ", "lo ", "la ", "i ", "gli ", "le ", "l'", "l’", "un ", "una "},
+	"nl": {"de ", "het ", "een "},
+	"pt": {"o ", "a ", "os ", "as ", "um ", "uma "},
+}
+
+// SortableTitle returns title without the leading article of its language,
+// English when the language is unknown, so that "The Idiot" sorts under I.
+func SortableTitle(title, lang string) string {
+	primary, _, _ := strings.Cut(strings.ToLower(lang), "-")
+	list, ok := articles[primary]
+	if !ok {
+		list = articles["en"]
+	}
+	lower := strings.ToLower(title)
+	for _, a := range list {
+		if strings.HasPrefix(lower, a) && len(title) > len(a) {
+			return strings.TrimSpace(title[len(a):])
+		}
+	}
+	return title
+}
+
+// nameSuffixes are the words after a surname that are not the surname.
+var nameSuffixes = []string{"jr", "jr.", "sr", "sr.", "ii", "iii", "iv"}
+
+// AuthorSortName returns the author as "surname, given names". Names
+// already written that way are kept.
+func AuthorSortName(author string) string {
+	author = strings.TrimSpace(author)
+	if strings.Contains(author, ",") {
+		return author
+	}
+	words := strings.Fields(author)
+	last := len(words) - 1
+	for last > 0 && slices.Contains(nameSuffixes, strings.ToLower(words[last])) {
+		last--
+	}
+	if last <= 0 {
+		return author
+	}
+	return words[last] + ", " + strings.Join(append(words[:last:last], words[last+1:]...), " ")
+}
@@ -0,0 +1,76 @@
+package metadata_test
+
+import (
+	"testing"
+	"time"
+
+	"Bonalioteko/metadata"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/text/language"
+)
+
+func TestSortBooks(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	library := []metadata.Book{
+		{Path: "/a", Title: "The Idiot", Authors: []string{"Fyodor Dostoevsky"}, Series: "Novels", SeriesIndex: "10", PublishDate: "1869", Added: day(3), Size: 30},
+		{Path: "/b", Title: "Émile", Authors: []string{"Jean-Jacques Rousseau"}, Language: "fr", Added: day(1), Size: 10},
+		{Path: "/c", Title: "Demons", Authors: []string{"Dostoevsky, Fyodor"}, Series: "Novels", SeriesIndex: "9", PublishDate: "1872-01-01", Size: 20},
+		{Path: "/d", Title: "Les Misérables", Authors: []string{"Victor Hugo"}, Language: "fr", PublishDate: "1862", Added: day(2), Size: 40},
+		{Path: "/e", Title: "an Essay", Authors: []string{"Martin Luther King Jr."}, Size: 5},
+	}
+
+	type testCase struct {
+		order string
+		want  []string
+	}
+
+	testCases := []testCase{
+		{order: "title", want: []string{"Demons", "Émile", "an Essay", "The Idiot", "Les Misérables"}},
+		{order: "title desc", want: []string{"Les Misérables", "The Idiot", "an Essay", "Émile", "Demons"}},
+		{order: "author", want: []string{"Demons", "The Idiot", "Les Misérables", "an Essay", "Émile"}},
+		{order: "series", want: []string{"Demons", "The Idiot", "Émile", "an Essay", "Les Misérables"}},
+		{order: "published desc", want: []string{"Demons", "The Idiot", "Les Misérables", "Émile", "an Essay"}},
+		{order: "added", want: []string{"Émile", "Les Misérables", "The Idiot", "Demons", "an Essay"}},
+		{order: "size desc", want: []string{"Les Misérables", "The Idiot", "Demons", "Émile", "an Essay"}},
+	}
+
+	for _, tc := range testCases {
+		o, err := metadata.ParseSortOrder(tc.order)
+		if err != nil {
+			t.Fatalf("ParseSortOrder(%q): got error:%s", tc.order, err)
+		}
+		books := append([]metadata.Book(nil), library...)
+		metadata.SortBooks(books, o, language.English)
+
+		var got []string
+		for _, b := range books {
+			got = append(got, b.Title)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("SortBooks(%q): mismatch (-want +got):\n%s", tc.order, diff)
+		}
+	}
+}
+
+func TestParseSortOrder_Errors(t *testing.T) {
+	for _, s := range []string{"colour", "title up", "title asc desc"} {
+		if _, err := metadata.ParseSortOrder(s); err == nil {
+			t.Errorf("ParseSortOrder(%q): got no error", s)
+		}
+	}
+}
+
+func TestAuthorSortName(t *testing.T) {
+	testCases := map[string]string{
+		"Fyodor Dostoevsky":      "Dostoevsky, Fyodor",
+		"Dostoevsky, Fyodor":     "Dostoevsky, Fyodor",
+		"Martin Luther King Jr.": "King, Martin Luther Jr.",
+		"Homer":                  "Homer",
+	}
+	for in, want := range testCases {
+		if got := metadata.AuthorSortName(in); got != want {
+			t.Errorf("AuthorSortName(%q): want %q, got %q", in, want, got)
+		}
+	}
+}
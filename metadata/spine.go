@@ -0,0 +1,186 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Chapter is a document of the reading order of an EPUB.
+type Chapter struct {
+	// Name is the path of the document inside the EPUB.
+	Name string
+	// Title comes from the table of contents, and is empty for documents
+	// it doesn't list.
+	Title string
+}
+
+// packageDocument holds the parts of an OPF describing the reading order.
+type packageDocument struct {
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Toc   string `xml:"toc,attr"`
+		Items []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// ReadChapters calls fn with every document of the spine of the EPUB at
+// path, in reading order. It stops at the first error returned by fn.
+func ReadChapters(path string, fn func(Chapter, io.Reader) error) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	chapters, err := spine(&zr.Reader)
+	if err != nil {
+		return err
+	}
+	for _, ch := range chapters {
+		f, err := zr.Open(ch.Name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ch.Name, err)
+		}
+		err = fn(ch, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spine returns the documents of the reading order, titled from the EPUB 3
+// navigation document or the EPUB 2 NCX.
+func spine(zr *zip.Reader) ([]Chapter, error) {
+	name, err := rootfile(zr)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readZipFile(zr, name)
+	if err != nil {
+		return nil, err
+	}
+	var pkg packageDocument
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	dir := path.Dir(name)
+	hrefs := make(map[string]string)
+	var toc string
+	for _, item := range pkg.Manifest {
+		hrefs[item.ID] = resolve(dir, item.Href)
+		if strings.Contains(" "+item.Properties+" ", " nav ") {
+			toc = hrefs[item.ID]
+		}
+	}
+	if toc == "" && pkg.Spine.Toc != "" {
+		toc = hrefs[pkg.Spine.Toc]
+	}
+
+	titles := make(map[string]string)
+	if toc != "" {
+		// A missing or broken table of contents only costs the titles.
+		if data, err := readZipFile(zr, toc); err == nil {
+			titles = tocTitles(data, path.Dir(toc))
+		}
+	}
+
+	var chapters []Chapter
+	for _, ref := range pkg.Spine.Items {
+		href, ok := hrefs[ref.IDRef]
+		if !ok {
+			continue
+		}
+		chapters = append(chapters, Chapter{Name: href, Title: titles[href]})
+	}
+	return chapters, nil
+}
+
+// tocTitles maps the documents linked from a navigation document or an NCX
+// to the first title they are given.
+func tocTitles(data []byte, dir string) map[string]string {
+	titles := make(map[string]string)
+	add := func(href, title string) {
+		href = resolve(dir, href)
+		title = strings.Join(strings.Fields(title), " ")
+		if _, ok := titles[href]; !ok && title != "" {
+			titles[href] = title
+		}
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	// Navigation documents link with <a href>, NCX files put a <text> label
+	// before a <content src>.
+	var href, label string
+	var inLink, inLabel bool
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "a":
+				href, label, inLink = attr(t, "href"), "", true
+			case "text":
+				label, inLabel = "", true
+			case "content":
+				add(attr(t, "src"), label)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "a":
+				if inLink {
+					add(href, label)
+				}
+				inLink = false
+			case "text":
+				inLabel = false
+			}
+		case xml.CharData:
+			if inLink || inLabel {
+				label += string(t)
+			}
+		}
+	}
+	return titles
+}
+
+// resolve returns the zip path of href relative to dir, without fragment.
+func resolve(dir, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Clean(path.Join(dir, href))
+}
+
+// readZipFile returns the contents of the named entry.
+func readZipFile(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
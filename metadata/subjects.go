@@ -0,0 +1,87 @@
+package metadata
+
+import (
+	"slices"
+
+	"Bonalioteko/xattr"
+
+	"github.com/pirmd/epub"
+)
+
+// WriteSubjects mirrors tags into the dc:subject elements of the EPUB at
+// path, replacing the subjects it had.
+func WriteSubjects(path string, tags []string) error {
+	return RewriteOPF(path, func(opf []byte) ([]byte, error) {
+		return SetSubjects(opf, tags)
+	})
+}
+
+// EmbeddedSubjects returns the dc:subject elements of the EPUB at path,
+// ignoring any Calibre sidecar.
+func EmbeddedSubjects(path string) ([]string, error) {
+	meta, err := epub.GetMetadataFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var subjects []string
+	for _, s := range meta.Subject {
+		if s != "" {
+			subjects = append(subjects, s)
+		}
+	}
+	return subjects, nil
+}
+
+// Mismatch lists the tags that differ between the extended attributes of a
+// file and the subjects embedded in it.
+type Mismatch struct {
+	Path         string
+	OnlyXattr    []string
+	OnlyEmbedded []string
+	Err          error
+}
+
+// Reconcile compares the xattr tags of every path with its embedded
+// subjects and returns the files where they disagree.
+func Reconcile(paths []string) []Mismatch {
+	var mismatches []Mismatch
+	for _, path := range paths {
+		tags, err := xattr.GetTagsFromPath(path)
+		if err != nil {
+			mismatches = append(mismatches, Mismatch{Path: path, Err: err})
+			continue
+		}
+		subjects, err := EmbeddedSubjects(path)
+		if err != nil {
+			mismatches = append(mismatches, Mismatch{Path: path, Err: err})
+			continue
+		}
+
+		m := Mismatch{
+			Path:         path,
+			OnlyXattr:    difference(StoredTags(tags), subjects),
+			OnlyEmbedded: difference(subjects, StoredTags(tags)),
+		}
+		if len(m.OnlyXattr) > 0 || len(m.OnlyEmbedded) > 0 {
+			mismatches = append(mismatches, m)
+		}
+	}
+	return mismatches
+}
+
+// StoredTags drops the empty tags left by stray commas in the attribute.
+func StoredTags(tags []string) []string {
+	return slices.DeleteFunc(slices.Clone(tags), func(t string) bool { return t == "" })
+}
+
+// difference returns the elements of a missing from b.
+func difference(a, b []string) []string {
+	set := xattr.CreateHashSet(b)
+	var diff []string
+	for _, v := range a {
+		if !set[v] {
+			diff = append(diff, v)
+		}
+	}
+	return diff
+}
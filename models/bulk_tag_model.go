@@ -0,0 +1,399 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	keymaps "Bonalioteko/Keymaps"
+	"Bonalioteko/journal"
+	"Bonalioteko/metadata"
+	"Bonalioteko/search"
+	"Bonalioteko/xattr"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// BulkTagModel adds and removes tags across the marked books at once. Every
+// tag is listed with how many of the books carry it: all, some or none.
+type BulkTagModel struct {
+	books []metadata.Book
+	// paths lists the paths of the books, in the same order.
+	paths []string
+	// tags maps the path of every book to its tags.
+	tags map[string][]string
+	// names lists the tags of the books, and the ones added since, which
+	// stay listed when no book carries them anymore.
+	names  []string
+	cursor int
+
+	// adding is set while input holds the tags being added.
+	adding bool
+	input  textinput.Model
+
+	// report tells what the last change did to every book, in the order
+	// of books. It is shown, under summary, until a key is pressed.
+	report  []search.TagEdit
+	summary string
+
+	// embedTags mirrors the tags into the EPUB's dc:subject elements.
+	embedTags bool
+
+	Width  int
+	Height int
+
+	Styles Styles
+	Help   help.Model
+	KeyMap keymaps.KeyMap
+
+	err error
+}
+
+type ExitBulkTagViewMsg struct{}
+
+// BulkTagsUpdatedMsg maps the paths of the books whose tags the bulk tag
+// editor changed to their new tags.
+type BulkTagsUpdatedMsg struct {
+	Tags map[string][]string
+	// entry is the change, for the journal to undo it.
+	entry journal.Entry
+}
+
+func NewBulkTagModel(books []metadata.Book, pathTags map[string][]string, embedTags bool) BulkTagModel {
+	m := BulkTagModel{
+		books:     books,
+		tags:      make(map[string][]string, len(books)),
+		input:     textinput.New(),
+		embedTags: embedTags,
+		Width:     defaultWidth,
+		Height:    defaultHeight,
+		Styles:    DefaultStyles(),
+		Help:      help.New(),
+		KeyMap:    keymaps.DefaultKeyMap(),
+	}
+	for _, book := range books {
+		m.paths = append(m.paths, book.Path)
+		m.tags[book.Path] = pathTags[book.Path]
+		for _, tag := range pathTags[book.Path] {
+			if !slices.Contains(m.names, tag) {
+				m.names = append(m.names, tag)
+			}
+		}
+	}
+	slices.Sort(m.names)
+	m.input.Prompt = "Add: "
+	m.input.Placeholder = "comma-separated tags"
+	return m
+}
+
+func (m BulkTagModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m BulkTagModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Width, m.Height = msg.Width, msg.Height
+		m.Help.Width = msg.Width
+		m.input.Width = max(1, msg.Width-lipgloss.Width(m.input.Prompt)-1)
+
+	case tea.MouseMsg:
+		if d := wheel(msg); d != 0 && m.report == nil && !m.adding {
+			return m.Update(wheelKey(d))
+		}
+		if !leftClick(msg) {
+			return m, nil
+		}
+		if m.err != nil || m.report != nil {
+			m.err, m.report = nil, nil
+			return m, nil
+		}
+		return m.click(msg.X, msg.Y)
+
+	case tagsRestoredMsg:
+		for path, tags := range msg.Tags {
+			if _, ok := m.tags[path]; !ok {
+				continue
+			}
+			m.tags[path] = tags
+			for _, tag := range tags {
+				if !slices.Contains(m.names, tag) {
+					m.names = append(m.names, tag)
+				}
+			}
+		}
+
+	case tea.KeyMsg:
+		if m.err != nil || m.report != nil {
+			m.err, m.report = nil, nil
+			return m, nil
+		}
+		if m.adding {
+			switch {
+			case key.Matches(msg, m.KeyMap.Enter):
+				tags, err := parseTags(m.input.Value())
+				if err != nil {
+					m.err = err
+					return m, nil
+				}
+				if len(tags) == 0 {
+					return m, nil
+				}
+				m.adding = false
+				m.input.Reset()
+				m.input.Blur()
+				return m, m.apply(tags, true)
+			case key.Matches(msg, m.KeyMap.Quit):
+				m.adding = false
+				m.input.Blur()
+				return m, nil
+			}
+			m.input, cmd = m.input.Update(msg)
+			return m, cmd
+		}
+
+		switch {
+		case key.Matches(msg, m.KeyMap.Undo):
+			return m, undo(false)
+		case key.Matches(msg, m.KeyMap.Redo):
+			return m, undo(true)
+		case key.Matches(msg, m.KeyMap.CursorUp):
+			m.cursor = max(0, m.cursor-1)
+		case key.Matches(msg, m.KeyMap.CursorDown):
+			m.cursor = max(0, min(m.cursor+1, len(m.names)-1))
+		case key.Matches(msg, m.KeyMap.SpaceBar):
+			// Tags some books lack are added to them, and tags all of
+			// them carry are removed.
+			if tag, ok := m.highlightedTag(); ok {
+				share, _ := search.TagShare(tag, m.paths, m.tags)
+				return m, m.apply([]string{tag}, share != search.ShareAll)
+			}
+		case msg.String() == "d":
+			if tag, ok := m.highlightedTag(); ok {
+				return m, m.apply([]string{tag}, false)
+			}
+		case msg.String() == "a":
+			m.adding = true
+			return m, m.input.Focus()
+		case key.Matches(msg, m.KeyMap.Quit):
+			return m, func() tea.Msg { return ExitBulkTagViewMsg{} }
+		}
+	}
+	return m, nil
+}
+
+// highlightedTag returns the tag under the cursor, if any.
+func (m BulkTagModel) highlightedTag() (string, bool) {
+	if m.cursor < 0 || m.cursor >= len(m.names) {
+		return "", false
+	}
+	return m.names[m.cursor], true
+}
+
+// apply adds the tags to every book, or removes them, with
+// search.EditTags, and reports what it did to each of them.
+func (m *BulkTagModel) apply(tags []string, add bool) tea.Cmd {
+	for _, tag := range tags {
+		if !slices.Contains(m.names, tag) {
+			m.names = append(m.names, tag)
+		}
+	}
+
+	write := func(path string, tags []string) error {
+		return writeTags(path, tags, m.embedTags)
+	}
+	m.report = search.EditTags(m.paths, tags, add, xattr.GetTagsFromPath, write)
+	updated := make(map[string][]string)
+	var changes []journal.Change
+	for _, e := range m.report {
+		switch {
+		case e.Err == nil:
+			m.tags[e.Path] = e.After
+			updated[e.Path] = e.After
+			changes = append(changes, journal.Change{Path: e.Path, Before: e.Before, After: e.After})
+		default:
+			// A failed change leaves the tags as they are on the file,
+			// which may have been changed elsewhere.
+			if tags, err := xattr.GetTagsFromPath(e.Path); err == nil {
+				m.tags[e.Path] = tags
+				updated[e.Path] = tags
+			}
+		}
+	}
+	changed, failed := search.CountEdits(m.report)
+
+	verb, action, prep := "Added", "add", "to"
+	if !add {
+		verb, action, prep = "Removed", "remove", "from"
+	}
+	m.summary = fmt.Sprintf("%s %s %s %d of %d books", verb, strings.Join(tags, ", "), prep, changed, len(m.books))
+	if failed > 0 {
+		m.summary += fmt.Sprintf(", %d failed", failed)
+	}
+	if len(updated) == 0 {
+		return nil
+	}
+	entry := newEntry(fmt.Sprintf("%s %s %s %d books", action, strings.Join(tags, ", "), prep, changed), changes...)
+	return func() tea.Msg { return BulkTagsUpdatedMsg{Tags: updated, entry: entry} }
+}
+
+func (m BulkTagModel) View() string {
+	if m.err != nil {
+		return fmt.Sprintf("error: %v\n\nPress any key to continue", m.err)
+	}
+	if m.report != nil {
+		return lipgloss.NewStyle().MaxWidth(m.Width).MaxHeight(m.Height).Render(m.reportView())
+	}
+	return lipgloss.NewStyle().MaxWidth(m.Width).MaxHeight(m.Height).Render(m.body())
+}
+
+// body renders the header, the tags, the tags being typed, the buttons and
+// the help.
+func (m BulkTagModel) body() string {
+	parts := []string{m.headerView(), strings.Join(m.tagLines(), "\n"), ""}
+	if m.adding {
+		parts = append(parts, m.input.View())
+	}
+	parts = append(parts, buttonsView(m.Styles, m.buttons()), m.helpView())
+	return lipgloss.JoinVertical(lipgloss.Left, parts...)
+}
+
+// headerView tells how many books are edited.
+func (m BulkTagModel) headerView() string {
+	return m.Styles.highlighted.Render(fmt.Sprintf("Tags of %d books", len(m.books))) + "\n"
+}
+
+// buttons are the buttons under the tags.
+func (m BulkTagModel) buttons() []button {
+	if m.adding {
+		return []button{{"Add", enterKey}, {"Cancel", escKey}}
+	}
+	return []button{{"Add tag (a)", runeKey('a')}, {"All/none (space)", spaceKey}, {"Remove (d)", runeKey('d')}, {"Back (esc)", escKey}}
+}
+
+// tagsHeight returns the number of tags shown at once.
+func (m BulkTagModel) tagsHeight() int {
+	other := lipgloss.Height(m.headerView()) + 2 + lipgloss.Height(m.helpView())
+	if m.adding {
+		other += lipgloss.Height(m.input.View())
+	}
+	return max(1, m.Height-other)
+}
+
+// tagLines lists the tags around the cursor, each with a box telling
+// whether all the books carry it ([x]), some of them ([-]) or none ([ ]),
+// and how many do.
+func (m BulkTagModel) tagLines() []string {
+	if len(m.names) == 0 {
+		return []string{m.Styles.greyed.Render("No tags yet")}
+	}
+	start, end := scrollWindow(len(m.names), m.cursor, m.tagsHeight())
+	lines := make([]string, 0, end-start)
+	cursorWidth := lipgloss.Width(">")
+	for i, tag := range m.names[start:end] {
+		i += start
+		box := "[ ]"
+		share, n := search.TagShare(tag, m.paths, m.tags)
+		switch share {
+		case search.ShareAll:
+			box = m.Styles.selectedtag.Render("[x]")
+		case search.ShareSome:
+			box = m.Styles.virtualtag.Render("[-]")
+		}
+		count := m.Styles.greyed.Render(fmt.Sprintf(" %d/%d", n, len(m.books)))
+		name := ansi.Truncate(tag, max(1, m.Width-cursorWidth-4-lipgloss.Width(count)), "…")
+		if i == m.cursor {
+			lines = append(lines, m.Styles.cursor.Render(">")+box+" "+m.Styles.highlightedtag.Render(name)+count)
+			continue
+		}
+		lines = append(lines, strings.Repeat(" ", cursorWidth)+box+" "+m.Styles.tagnames.Render(name)+count)
+	}
+	return lines
+}
+
+// reportView lists what the last change did to every book, as many as fit.
+func (m BulkTagModel) reportView() string {
+	footer := m.Styles.greyed.Render("Press any key to continue")
+	height := max(1, m.Height-4)
+	lines := []string{m.Styles.highlighted.Render(m.summary), ""}
+	for i, e := range m.report {
+		if i == height-1 && len(m.report) > height {
+			lines = append(lines, m.Styles.greyed.Render(fmt.Sprintf("… and %d more", len(m.report)-i)))
+			break
+		}
+		title := m.books[i].Title
+		var line string
+		switch {
+		case e.Err != nil:
+			line = m.Styles.errorText.Render("✗ "+title) + m.Styles.greyed.Render(": "+e.Err.Error())
+		case e.Changed():
+			line = m.Styles.selectedtag.Render("✓ " + title)
+		default:
+			line = m.Styles.greyed.Render("· " + title + ": unchanged")
+		}
+		lines = append(lines, ansi.Truncate(line, m.Width, "…"))
+	}
+	return strings.Join(append(lines, "", footer), "\n")
+}
+
+// click handles a click at column x and line y: clicking a tag highlights
+// it, clicking its box adds it to all the books or removes it, and clicking
+// a button presses its key.
+func (m BulkTagModel) click(x, y int) (tea.Model, tea.Cmd) {
+	y -= lipgloss.Height(m.headerView())
+	lines := m.tagLines()
+	if y >= 0 && y < len(lines) && len(m.names) > 0 && !m.adding {
+		start, _ := scrollWindow(len(m.names), m.cursor, m.tagsHeight())
+		m.cursor = start + y
+		if x >= 1 && x < 4 {
+			return m.Update(spaceKey)
+		}
+		return m, nil
+	}
+
+	y -= len(lines) + 1
+	if m.adding {
+		y -= lipgloss.Height(m.input.View())
+	}
+	if y == 0 {
+		if k, ok := buttonAt(m.buttons(), x); ok {
+			return m.Update(k)
+		}
+	}
+	return m, nil
+}
+
+func (m BulkTagModel) helpView() string {
+	return m.Styles.HelpStyle.Render(m.Help.View(m))
+}
+
+func (m BulkTagModel) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{
+		m.KeyMap.CursorUp,
+		m.KeyMap.CursorDown,
+	}, {
+		m.KeyMap.Undo,
+		m.KeyMap.Redo,
+	}, {
+		m.KeyMap.Quit,
+		m.KeyMap.CloseFullHelp,
+	}}
+}
+
+// ShortHelp returns bindings to show in the abbreviated help view. It's part
+// of the help.KeyMap interface.
+func (m BulkTagModel) ShortHelp() []key.Binding {
+	return []key.Binding{
+		m.KeyMap.CursorUp,
+		m.KeyMap.CursorDown,
+		m.KeyMap.Quit,
+		m.KeyMap.ShowFullHelp,
+	}
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"container/list"
+	"errors"
+	"image"
+	"log"
+	"strings"
+
+	"Bonalioteko/cover"
+	"Bonalioteko/metadata"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// previewCoverID is the kitty image id of the cover in the preview.
+const previewCoverID = 1
+
+// coverPages is the number of gallery pages of thumbnails kept in memory,
+// besides the one of the preview.
+const coverPages = 3
+
+// coverEntry is the thumbnail of the book at path, nil while it loads or
+// when the book has none, along with its last renderings in the preview and
+// in the gallery.
+type coverEntry struct {
+	path          string
+	img           image.Image
+	preview, tile coverRendering
+}
+
+// coverSet keeps the thumbnails last shown, so that scrolling through a
+// large library doesn't keep every one of them in memory. The ones dropped
+// are read again from the disk cache when they are shown again.
+type coverSet struct {
+	entries map[string]*list.Element
+	// order lists the entries, the most recently shown first.
+	order *list.List
+}
+
+func newCoverSet() *coverSet {
+	return &coverSet{entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// get returns the entry of the book at path, or nil when it has none.
+func (s *coverSet) get(path string) *coverEntry {
+	if e, ok := s.entries[path]; ok {
+		return e.Value.(*coverEntry)
+	}
+	return nil
+}
+
+// use marks the entry of the book at path as shown, adding it when it has
+// none, and drops the entries shown least recently beyond size. It reports
+// whether the entry was added.
+func (s *coverSet) use(path string, size int) bool {
+	if e, ok := s.entries[path]; ok {
+		s.order.MoveToFront(e)
+		return false
+	}
+	s.entries[path] = s.order.PushFront(&coverEntry{path: path})
+	for s.order.Len() > max(1, size) {
+		last := s.order.Back()
+		delete(s.entries, last.Value.(*coverEntry).path)
+		s.order.Remove(last)
+	}
+	return true
+}
+
+// coverRendering is a cover drawn in cols×rows cells as the kitty image id.
+type coverRendering struct {
+	cols, rows, id int
+	lines          []string
+}
+
+type coverLoadedMsg struct {
+	path string
+	img  image.Image
+	err  error
+}
+
+// previewShown reports whether the preview is on screen.
+func (m Model) previewShown() bool {
+	return m.state == normalView && (!m.singleColumn() || m.focus == previewPane)
+}
+
+// loadCover reads the thumbnails of the books on screen in the background:
+// the highlighted book when the preview shows it, or the books of the
+// gallery page.
+func (m *Model) loadCover() tea.Cmd {
+	if m.coverProtocol == cover.Off {
+		return nil
+	}
+	if m.state == galleryView {
+		var cmds []tea.Cmd
+		for _, book := range m.galleryPage() {
+			cmds = append(cmds, m.loadCoverOf(book.Path))
+		}
+		return tea.Batch(cmds...)
+	}
+	if !m.previewShown() {
+		return nil
+	}
+	book, ok := m.highlightedBook()
+	if !ok {
+		return nil
+	}
+	return m.loadCoverOf(book.Path)
+}
+
+// loadCoverOf reads the thumbnail of the book at path when it isn't in
+// memory.
+func (m *Model) loadCoverOf(path string) tea.Cmd {
+	if !m.covers.use(path, m.coverCapacity()) {
+		return nil
+	}
+	cache := m.coverCache
+	return func() tea.Msg {
+		img, err := cache.Thumbnail(path)
+		return coverLoadedMsg{path: path, img: img, err: err}
+	}
+}
+
+// setCover stores a loaded thumbnail. Books whose cover can't be read show
+// none.
+func (m *Model) setCover(msg coverLoadedMsg) {
+	if msg.err != nil && !errors.Is(msg.err, metadata.ErrNoCover) {
+		log.Printf("Warning: cover of %s: %v", msg.path, msg.err)
+	}
+	if entry := m.covers.get(msg.path); entry != nil {
+		entry.img = msg.img
+	}
+}
+
+// coverCapacity returns the number of thumbnails kept in memory: a few
+// pages of the gallery, and the cover of the preview.
+func (m Model) coverCapacity() int {
+	cols, rows, _ := m.galleryLayout()
+	return coverPages*cols*rows + 1
+}
+
+// forgetCoverRenderings drops the renderings of the covers, for when the
+// cells change size.
+func (m *Model) forgetCoverRenderings() {
+	for e := m.covers.order.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*coverEntry)
+		entry.preview.lines, entry.tile.lines = nil, nil
+	}
+}
+
+// render draws the cover fitted in maxCols×maxRows cells and centred in
+// maxCols. The rendering is kept in last for as long as it is drawn the
+// same.
+func (e *coverEntry) render(last *coverRendering, p cover.Protocol, cell cover.Cell, maxCols, maxRows, id int) []string {
+	cols, rows := cover.Fit(e.img.Bounds().Size(), cell, maxCols, maxRows)
+	if last.lines == nil || last.cols != cols || last.rows != rows || last.id != id {
+		lines := cover.Render(e.img, p, cell, cols, rows, id)
+		margin := strings.Repeat(" ", (maxCols-cols)/2)
+		for i := range lines {
+			lines[i] = margin + lines[i]
+		}
+		*last = coverRendering{cols: cols, rows: rows, id: id, lines: lines}
+	}
+	return last.lines
+}
+
+// coverLines renders the cover of the book centred in width cells and at
+// most half of height lines for the preview, or nothing when it has none or
+// it isn't loaded yet.
+func (m Model) coverLines(path string, width, height int) []string {
+	entry := m.covers.get(path)
+	if entry == nil || entry.img == nil {
+		return nil
+	}
+	return entry.render(&entry.preview, m.coverProtocol, m.coverCell, width, max(1, height/2), previewCoverID)
+}
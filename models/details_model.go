@@ -0,0 +1,322 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	keymaps "Bonalioteko/Keymaps"
+	"Bonalioteko/journal"
+	"Bonalioteko/metadata"
+	"Bonalioteko/search"
+	"Bonalioteko/xattr"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// DetailsModel shows everything known about a book, its description
+// included, and edits its tags in place.
+type DetailsModel struct {
+	book        metadata.Book
+	tags        []string
+	description string
+
+	// offset is the line the details are scrolled to.
+	offset int
+
+	// editing is set while tagInput holds the tags being edited.
+	editing  bool
+	tagInput textinput.Model
+
+	// embedTags mirrors the tags into the EPUB's dc:subject elements.
+	embedTags bool
+
+	Width  int
+	Height int
+
+	Styles Styles
+	Help   help.Model
+	KeyMap keymaps.KeyMap
+
+	err error
+}
+
+type ExitDetailsViewMsg struct{}
+
+func NewDetailsModel(book metadata.Book, tags []string, embedTags bool) DetailsModel {
+	m := DetailsModel{
+		book:        book,
+		tags:        tags,
+		description: metadata.DescriptionText(book.Description),
+		tagInput:    textinput.New(),
+		embedTags:   embedTags,
+		Width:       defaultWidth,
+		Height:      defaultHeight,
+		Styles:      DefaultStyles(),
+		Help:        help.New(),
+		KeyMap:      keymaps.DefaultKeyMap(),
+	}
+	m.tagInput.Prompt = "Tags: "
+	m.tagInput.Placeholder = "comma-separated tags"
+	return m
+}
+
+func (m DetailsModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m DetailsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Width, m.Height = msg.Width, msg.Height
+		m.Help.Width = msg.Width
+		m.tagInput.Width = max(1, msg.Width-lipgloss.Width(m.tagInput.Prompt)-1)
+		m.scroll(0)
+		return m, nil
+
+	case tea.MouseMsg:
+		if d := wheel(msg); d != 0 && !m.editing {
+			m.scroll(d * wheelLines)
+			return m, nil
+		}
+		if !leftClick(msg) {
+			return m, nil
+		}
+		if m.err != nil {
+			m.err = nil
+			return m, nil
+		}
+		return m.click(msg.X, msg.Y)
+
+	case tagsRestoredMsg:
+		if tags, ok := msg.Tags[m.book.Path]; ok {
+			m.tags = tags
+		}
+
+	case tea.KeyMsg:
+		if m.err != nil {
+			m.err = nil
+			return m, nil
+		}
+		if m.editing {
+			switch {
+			case key.Matches(msg, m.KeyMap.Enter):
+				return m, m.saveTags()
+			case key.Matches(msg, m.KeyMap.Quit):
+				m.editing = false
+				m.tagInput.Blur()
+				return m, nil
+			}
+			m.tagInput, cmd = m.tagInput.Update(msg)
+			return m, cmd
+		}
+
+		page := m.bodyHeight()
+		switch {
+		case key.Matches(msg, m.KeyMap.Undo):
+			return m, undo(false)
+		case key.Matches(msg, m.KeyMap.Redo):
+			return m, undo(true)
+		case key.Matches(msg, m.KeyMap.Edit):
+			m.editing = true
+			m.offset = 0
+			m.tagInput.SetValue(strings.Join(m.tags, ", "))
+			m.tagInput.CursorEnd()
+			return m, m.tagInput.Focus()
+		case key.Matches(msg, m.KeyMap.CursorUp):
+			m.scroll(-1)
+		case key.Matches(msg, m.KeyMap.CursorDown):
+			m.scroll(1)
+		case key.Matches(msg, m.KeyMap.PageUp):
+			m.scroll(-page)
+		case key.Matches(msg, m.KeyMap.PageDown):
+			m.scroll(page)
+		case key.Matches(msg, m.KeyMap.Home):
+			m.offset = 0
+		case key.Matches(msg, m.KeyMap.End):
+			m.scroll(len(m.lines()))
+		case key.Matches(msg, m.KeyMap.Enter):
+			m.err = OpenFile(m.book.Path)
+		case key.Matches(msg, m.KeyMap.Quit):
+			return m, func() tea.Msg { return ExitDetailsViewMsg{} }
+		}
+	}
+	return m, nil
+}
+
+// saveTags replaces the tags of the book with the ones typed.
+func (m *DetailsModel) saveTags() tea.Cmd {
+	tags, err := parseTags(m.tagInput.Value())
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	before, err := xattr.GetTagsFromPath(m.book.Path)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	if err := writeTags(m.book.Path, tags, m.embedTags); err != nil {
+		m.err = err
+		return nil
+	}
+	path := m.book.Path
+	entry := newEntry("set the tags of "+m.book.Title, journal.Change{Path: path, Before: before, After: tags})
+	m.tags = tags
+	m.editing = false
+	m.tagInput.Blur()
+	return func() tea.Msg { return TagsUpdatedMsg{NewTags: tags, filename: path, entry: entry} }
+}
+
+// parseTags splits a comma-separated list of tags, leaving out the empty and
+// repeated ones. Virtual tags and tags with control characters are refused.
+func parseTags(s string) ([]string, error) {
+	var tags []string
+	for tag := range strings.SplitSeq(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+		if search.IsVirtual(tag) {
+			return nil, fmt.Errorf("%q is reserved for a virtual tag", tag)
+		}
+		if strings.ContainsFunc(tag, unicode.IsControl) {
+			return nil, fmt.Errorf("tag %q has control characters", tag)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// scroll moves the details by delta lines, within the content.
+func (m *DetailsModel) scroll(delta int) {
+	last := len(m.lines()) - m.bodyHeight()
+	m.offset = max(0, min(m.offset+delta, last))
+}
+
+// start returns the first line of details on screen.
+func (m DetailsModel) start() int {
+	return max(0, min(m.offset, len(m.lines())-m.bodyHeight()))
+}
+
+// bodyHeight returns the number of lines of details shown at once.
+func (m DetailsModel) bodyHeight() int {
+	return max(1, m.Height-lipgloss.Height(m.helpView()))
+}
+
+// titleLines renders the title wrapped to the width of the terminal.
+func (m DetailsModel) titleLines() []string {
+	return strings.Split(lipgloss.NewStyle().Width(m.Width).Render(m.Styles.highlighted.Render(m.book.Title)), "\n")
+}
+
+// tagLines renders the tags, or the input and its buttons while they are
+// edited.
+func (m DetailsModel) tagLines() []string {
+	if m.editing {
+		return []string{m.tagInput.View(), buttonsView(m.Styles, m.editButtons())}
+	}
+	tags := m.Styles.greyed.Render("none")
+	if len(m.tags) > 0 {
+		tags = m.Styles.tagnames.Render(strings.Join(m.tags, ", "))
+	}
+	return strings.Split(lipgloss.NewStyle().Width(m.Width).Render(m.Styles.greyed.Render("Tags: ")+tags), "\n")
+}
+
+// editButtons are the buttons under the tags being edited.
+func (m DetailsModel) editButtons() []button {
+	return []button{{"Save", enterKey}, {"Cancel", escKey}}
+}
+
+// click handles a click at column x and line y: clicking the tags edits
+// them, and clicking a button presses its key.
+func (m DetailsModel) click(x, y int) (tea.Model, tea.Cmd) {
+	line := m.start() + y - len(m.titleLines())
+	switch {
+	case line < 0 || line >= len(m.tagLines()):
+	case !m.editing:
+		return m.Update(runeKey('e'))
+	case line == 1:
+		if k, ok := buttonAt(m.editButtons(), x); ok {
+			return m.Update(k)
+		}
+	}
+	return m, nil
+}
+
+// lines renders the details wrapped to the width of the terminal: the
+// title and the tags, the other fields, the values the overrides replaced,
+// and the description.
+func (m DetailsModel) lines() []string {
+	wrap := lipgloss.NewStyle().Width(m.Width)
+	lines := append(m.titleLines(), m.tagLines()...)
+	lines = append(lines, "")
+	lines = append(lines, fieldLines(m.Styles, bookFields(m.book, nil), m.Width)...)
+
+	if !m.book.Overrides.IsZero() {
+		original := []bookField{
+			{"Title", m.book.Original.Title},
+			{"Authors", strings.Join(m.book.Original.Authors, ", ")},
+			{"Series", m.book.Original.Series},
+			{"Series index", m.book.Original.SeriesIndex},
+		}
+		original = slices.DeleteFunc(original, func(f bookField) bool { return f.value == "" })
+		if len(original) > 0 {
+			lines = append(lines, "", m.Styles.greyed.Render("Overridden, in the file:"))
+			lines = append(lines, fieldLines(m.Styles, original, m.Width)...)
+		}
+	}
+
+	if m.description != "" {
+		lines = append(lines, "", m.Styles.greyed.Render("Description"))
+		lines = append(lines, strings.Split(wrap.Render(m.description), "\n")...)
+	}
+	return lines
+}
+
+func (m DetailsModel) View() string {
+	if m.err != nil {
+		return fmt.Sprintf("error: %v\n\nPress any key to continue", m.err)
+	}
+	lines := m.lines()
+	start := m.start()
+	end := min(len(lines), start+m.bodyHeight())
+	body := lipgloss.NewStyle().Height(m.bodyHeight()).Render(strings.Join(lines[start:end], "\n"))
+
+	view := lipgloss.JoinVertical(lipgloss.Left, body, m.helpView())
+	return lipgloss.NewStyle().MaxWidth(m.Width).MaxHeight(m.Height).Render(view)
+}
+
+func (m DetailsModel) helpView() string {
+	return m.Styles.HelpStyle.Render(m.Help.View(m))
+}
+
+func (m DetailsModel) FullHelp() [][]key.Binding {
+	return [][]key.Binding{m.ShortHelp()}
+}
+
+// ShortHelp returns bindings to show in the abbreviated help view. It's part
+// of the help.KeyMap interface.
+func (m DetailsModel) ShortHelp() []key.Binding {
+	if m.editing {
+		return []key.Binding{
+			key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "save tags")),
+			key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "cancel")),
+		}
+	}
+	return []key.Binding{
+		m.KeyMap.CursorUp,
+		m.KeyMap.CursorDown,
+		m.KeyMap.PageDown,
+		key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "edit tags")),
+		m.KeyMap.Undo,
+		m.KeyMap.Enter,
+		m.KeyMap.Quit,
+	}
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"fmt"
+
+	"Bonalioteko/search"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxFacetValues is the number of values listed per facet, besides the
+// selected ones.
+const maxFacetValues = 8
+
+// facetTitles are the headings of the facets in the panel.
+var facetTitles = map[string]string{
+	"author":    "Author",
+	"lang":      "Language",
+	"publisher": "Publisher",
+	"decade":    "Decade",
+	"format":    "Format",
+}
+
+// facetRow is a value listed in the facet panel.
+type facetRow struct {
+	facet string
+	value string
+	count int
+}
+
+// facetRows returns the values of the facet panel: the most frequent values
+// of every facet among the current results, followed by the selected values
+// that didn't make it.
+func (m Model) facetRows() []facetRow {
+	var rows []facetRow
+	for _, facet := range search.Facets {
+		counts := m.facets.Counts(m.unfaceted, facet)
+		listed := make(map[string]bool)
+		for i, c := range counts {
+			if i >= maxFacetValues && !m.facets.Has(facet, c.Value) {
+				continue
+			}
+			rows = append(rows, facetRow{facet: facet, value: c.Value, count: c.Count})
+			listed[c.Value] = true
+		}
+		for _, v := range m.facets[facet] {
+			if !listed[v] {
+				rows = append(rows, facetRow{facet: facet, value: v})
+			}
+		}
+	}
+	return rows
+}
+
+// updateFacetPanel handles the keys pressed while the facet panel has the
+// focus.
+func (m *Model) updateFacetPanel(msg tea.KeyMsg) {
+	rows := m.facetRows()
+	switch {
+	case key.Matches(msg, m.KeyMap.FocusFacets), key.Matches(msg, m.KeyMap.Quit):
+		m.focusPane(booksPane)
+
+	case key.Matches(msg, m.KeyMap.CursorUp):
+		// Above the first value are the tags of the sidebar.
+		if m.facetCursor <= 0 {
+			m.facetFocus = false
+			return
+		}
+		m.facetCursor--
+
+	case key.Matches(msg, m.KeyMap.CursorDown):
+		m.facetCursor = min(len(rows)-1, m.facetCursor+1)
+
+	case key.Matches(msg, m.KeyMap.SpaceBar):
+		m.toggleFacet()
+	}
+}
+
+// toggleFacet selects the value under the facet cursor, or unselects it.
+func (m *Model) toggleFacet() {
+	rows := m.facetRows()
+	if m.facetCursor < 0 || m.facetCursor >= len(rows) {
+		return
+	}
+	row := rows[m.facetCursor]
+	m.facets.Toggle(row.facet, row.value)
+	m.refreshResults()
+
+	// Keep the cursor on the value, which moves as the counts change.
+	for i, r := range m.facetRows() {
+		if r.facet == row.facet && r.value == row.value {
+			m.facetCursor = i
+		}
+	}
+}
+
+// facetLines renders the lines of the facet panel, and returns the value
+// every line lists, -1 for the headings, and the line of the cursor.
+func (m Model) facetLines() (lines []string, values []int, cursorLine int) {
+	facet := ""
+	for i, row := range m.facetRows() {
+		if row.facet != facet {
+			facet = row.facet
+			lines = append(lines, m.Styles.greyed.Render(facetTitles[facet]))
+			values = append(values, -1)
+		}
+
+		label := fmt.Sprintf("%s (%d)", truncate(row.value, 20), row.count)
+		prefix := "  "
+		if m.facetFocus && i == m.facetCursor {
+			prefix = m.Styles.cursor.Render(m.cursor) + " "
+			cursorLine = len(lines)
+		}
+		switch {
+		case m.facets.Has(row.facet, row.value):
+			label = m.Styles.selectedtag.Render("+" + label)
+		case m.facetFocus && i == m.facetCursor:
+			label = m.Styles.highlightedtag.Render(label)
+		}
+		lines = append(lines, prefix+label)
+		values = append(values, i)
+	}
+	return lines, values, cursorLine
+}
+
+// truncate shortens s to n runes, ending it with an ellipsis.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
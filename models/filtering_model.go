@@ -3,8 +3,18 @@ package models
 import (
 	"fmt"
 	"io"
+	"regexp"
+	"slices"
+	"unicode/utf8"
+
+	"Bonalioteko/metadata"
+	"Bonalioteko/query"
+	"Bonalioteko/search"
+	"Bonalioteko/xattr"
 
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -14,19 +24,49 @@ type ResultItem interface {
 	isTag() bool
 }
 
+// TagState is the part a tag of the tag bar plays in the filter.
+type TagState int
+
+const (
+	// TagNeutral tags don't affect the filter.
+	TagNeutral TagState = iota
+	// TagInclude tags are required by the filter.
+	TagInclude
+	// TagExclude tags remove the books carrying them.
+	TagExclude
+)
+
+// Next returns the state following s in the neutral, include, exclude
+// cycle.
+func (s TagState) Next() TagState {
+	return (s + 1) % 3
+}
+
 type (
-	TitleItem struct{ Book string }
+	TitleItem struct{ Book metadata.Book }
 	TagItem   struct {
 		Tag    string
-		status bool
+		status TagState
 	}
 )
 
 func (i TitleItem) Title() string       { return "" }
 func (i TitleItem) Description() string { return "" }
-func (t TitleItem) FilterValue() string { return t.Book }
+func (t TitleItem) FilterValue() string { return t.Book.Title }
 func (t TitleItem) isTag() bool         { return false }
 
+// authorSeparator separates the title from the authors in label.
+const authorSeparator = " — "
+
+// label is the text of the item while searching: the title followed by the
+// authors, which are searched too.
+func (t TitleItem) label() string {
+	if len(t.Book.Authors) == 0 {
+		return t.Book.Title
+	}
+	return t.Book.Title + authorSeparator + t.Book.Author()
+}
+
 func (i TagItem) Title() string       { return "" }
 func (i TagItem) Description() string { return "" }
 func (t TagItem) FilterValue() string { return t.Tag }
@@ -58,7 +98,7 @@ func (d Bonadelegate) Update(msg tea.Msg, lm *list.Model) tea.Cmd {
 
 		if msg.String() == " " {
 			if tag, ok := lm.SelectedItem().(*TagItem); ok {
-				tag.status = !tag.status
+				tag.status = tag.status.Next()
 
 				return func() tea.Msg {
 					return TagFilterMsg{TagItem: tag}
@@ -69,18 +109,35 @@ func (d Bonadelegate) Update(msg tea.Msg, lm *list.Model) tea.Cmd {
 	return nil
 }
 
+// tagStyle returns the style of a tag for its state.
+func (d Bonadelegate) tagStyle(t *TagItem) lipgloss.Style {
+	switch t.status {
+	case TagInclude:
+		return d.styles.selected
+	case TagExclude:
+		return d.styles.excluded
+	}
+	if search.IsVirtual(t.Tag) {
+		return d.styles.virtual
+	}
+	return d.styles.item
+}
+
 func (d Bonadelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	fmt.Fprint(w, ansi.Truncate(d.line(m, index, item), m.Width(), "…"))
+}
+
+// line renders an item of the list, before it is cut to the width of the
+// list.
+func (d Bonadelegate) line(m list.Model, index int, item list.Item) string {
+	matches := m.MatchesForItem(index)
 	switch m.FilterState() {
 	case list.Filtering:
 		switch v := item.(type) {
 		case *TitleItem:
-			fmt.Fprintf(w, "📖%s ", d.styles.item.Render(v.Book))
+			return fmt.Sprintf("📖%s ", d.highlight(v.label(), matches, d.styles.item))
 		case *TagItem:
-			if !v.status {
-				fmt.Fprintf(w, "  🏷 %s", d.styles.item.Render(v.Tag))
-			} else {
-				fmt.Fprintf(w, "  🏷 %s", d.styles.selected.Render(v.Tag))
-			}
+			return fmt.Sprintf("  🏷 %s", d.highlight(v.Tag, matches, d.tagStyle(v)))
 		}
 
 	case list.FilterApplied, list.Unfiltered:
@@ -88,22 +145,185 @@ func (d Bonadelegate) Render(w io.Writer, m list.Model, index int, item list.Ite
 			prefix := "> "
 			switch v := item.(type) {
 			case *TitleItem:
-				fmt.Fprintf(w, "📖%s ", d.styles.cursor.Render(prefix+v.Book))
+				return fmt.Sprintf("📖%s%s ", d.styles.cursor.Render(prefix), d.highlight(v.label(), matches, d.styles.cursor))
 			case *TagItem:
-				fmt.Fprintf(w, "🏷 %s", d.styles.cursor.Render(v.Tag))
+				return fmt.Sprintf("🏷 %s", d.highlight(v.Tag, matches, d.styles.cursor))
 			}
-			return
 		}
 
 		switch v := item.(type) {
 		case *TitleItem:
-			fmt.Fprintf(w, " 📖 %s ", d.styles.greyed.Render(v.Book))
+			return fmt.Sprintf(" 📖 %s ", d.highlight(v.label(), matches, d.styles.greyed))
+		case *TagItem:
+			return fmt.Sprintf("🏷 %s", d.highlight(v.Tag, matches, d.tagStyle(v)))
+		}
+	}
+	return ""
+}
+
+// highlight renders s in style, with the matched runes standing out.
+func (d Bonadelegate) highlight(s string, matches []int, style lipgloss.Style) string {
+	if len(matches) == 0 {
+		return style.Render(s)
+	}
+	return lipgloss.StyleRunes(s, matches, style.Inherit(d.styles.match), style)
+}
+
+// searchFilter returns the filter of the list. Field terms such as
+// author:tolstoy or year:1890..1910 keep the books whose metadata matches
+// them. The rest of the text, when made of known tags combined with query
+// operators such as `philosophy and not unread`, keeps the books matching
+// the tag query; any other text ranks the tags and the books by their
+// titles, authors and tags.
+func searchFilter(items []list.Item, tags map[string][]string) list.FilterFunc {
+	bookTags := search.BookTags(tags)
+	fields := make([][]search.Field, len(items))
+	for i, item := range items {
+		switch v := item.(type) {
+		case *TitleItem:
+			fields[i] = search.BookFields(v.Book, bookTags[v.Book.Path])
 		case *TagItem:
-			if !v.status {
-				fmt.Fprintf(w, "🏷 %s", d.styles.item.Render(v.Tag))
-			} else {
-				fmt.Fprintf(w, "🏷 %s", d.styles.selected.Render(v.Tag))
+			fields[i] = []search.Field{{Text: v.Tag, Weight: search.TagWeight}}
+		}
+	}
+
+	return func(term string, _ []string) []list.Rank {
+		s, err := search.Parse(term)
+		if err != nil {
+			return nil
+		}
+
+		// Candidates are the indexes of the items the field terms keep.
+		var candidates []int
+		for i, item := range items {
+			switch v := item.(type) {
+			case *TitleItem:
+				if s.Match(v.Book) {
+					candidates = append(candidates, i)
+				}
+			case *TagItem:
+				if !s.HasTerms() {
+					candidates = append(candidates, i)
+				}
 			}
 		}
+
+		if s.Text == "" {
+			ranks := make([]list.Rank, len(candidates))
+			for i, index := range candidates {
+				ranks[i] = list.Rank{Index: index}
+			}
+			return ranks
+		}
+
+		if node, ok := search.TagQuery(s.Text, tags); ok {
+			var paths []string
+			for _, index := range candidates {
+				if t, ok := items[index].(*TitleItem); ok {
+					paths = append(paths, t.Book.Path)
+				}
+			}
+			matches := xattr.CreateHashSet(query.Eval(node, tags, paths))
+
+			var ranks []list.Rank
+			for _, index := range candidates {
+				if t, ok := items[index].(*TitleItem); ok && matches[t.Book.Path] {
+					ranks = append(ranks, list.Rank{Index: index})
+				}
+			}
+			return ranks
+		}
+
+		docs := make([][]search.Field, len(candidates))
+		for i, index := range candidates {
+			docs[i] = fields[index]
+		}
+		var ranks []list.Rank
+		for _, match := range search.Rank(s.Text, docs) {
+			index := candidates[match.Index]
+			ranks = append(ranks, list.Rank{Index: index, MatchedIndexes: labelMatches(items[index], match.Highlights)})
+		}
+		return ranks
+	}
+}
+
+// labelMatches turns the matched runes of the search fields of an item into
+// indexes in the text the delegate renders for it. Matches in the tags of a
+// book are not shown.
+func labelMatches(item list.Item, highlights [][]int) []int {
+	if _, ok := item.(*TitleItem); !ok {
+		return highlights[0]
+	}
+	title := item.(*TitleItem).Book.Title
+	matches := slices.Clone(highlights[0])
+	offset := utf8.RuneCountInString(title + authorSeparator)
+	for _, i := range highlights[1] {
+		matches = append(matches, offset+i)
+	}
+	return matches
+}
+
+// regexFilter returns the filter of the list in regex mode: the text is a
+// regular expression matched, regardless of case, against the tags and the
+// titles and authors of the books, which are kept in their order.
+func regexFilter(items []list.Item) list.FilterFunc {
+	return func(term string, _ []string) []list.Rank {
+		re, err := compileFilterRegex(term)
+		if err != nil {
+			return nil
+		}
+		var ranks []list.Rank
+		for i, item := range items {
+			var text string
+			switch v := item.(type) {
+			case *TitleItem:
+				text = v.label()
+			case *TagItem:
+				text = v.Tag
+			}
+			spans := re.FindAllStringIndex(text, -1)
+			if spans == nil {
+				continue
+			}
+			ranks = append(ranks, list.Rank{Index: i, MatchedIndexes: runeIndexes(text, spans)})
+		}
+		return ranks
+	}
+}
+
+// compileFilterRegex compiles the text of the filter in regex mode. Case
+// is ignored unless the pattern turns it back on with (?-i).
+func compileFilterRegex(term string) (*regexp.Regexp, error) {
+	// The pattern is checked alone, so that errors don't show the flag.
+	if _, err := regexp.Compile(term); err != nil {
+		return nil, err
+	}
+	return regexp.MustCompile("(?i)" + term), nil
+}
+
+// runeIndexes returns the indexes of the runes of s within the byte spans.
+func runeIndexes(s string, spans [][]int) []int {
+	var indexes []int
+	n := 0
+	for i := range s {
+		for _, span := range spans {
+			if i >= span[0] && i < span[1] {
+				indexes = append(indexes, n)
+				break
+			}
+		}
+		n++
+	}
+	return indexes
+}
+
+// filterError returns why the text of the filter can't be searched for,
+// such as an invalid regular expression or field term.
+func filterError(term string, regex bool) error {
+	if regex {
+		_, err := compileFilterRegex(term)
+		return err
 	}
+	_, err := search.Parse(term)
+	return err
 }
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	keymaps "Bonalioteko/Keymaps"
+	"Bonalioteko/fulltext"
+	"Bonalioteko/metadata"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// fullTextLimit is the number of books a full-text search lists.
+const fullTextLimit = 20
+
+// FullTextModel searches the contents of the books. The index is brought up
+// to date before every search, so only the books changed since are read.
+type FullTextModel struct {
+	library []metadata.Book
+	titles  map[string]string
+	index   *fulltext.Index
+
+	input    textinput.Model
+	searched string
+	results  []fulltext.Result
+	cursor   int
+	status   string
+
+	Width  int
+	Height int
+
+	Styles Styles
+	Help   help.Model
+	KeyMap keymaps.KeyMap
+
+	err error
+}
+
+type (
+	ExitFullTextViewMsg struct{}
+	fullTextResultsMsg  struct {
+		query   string
+		index   *fulltext.Index
+		results []fulltext.Result
+		status  string
+		err     error
+	}
+)
+
+func NewFullTextModel(library []metadata.Book) FullTextModel {
+	m := FullTextModel{
+		library: library,
+		titles:  make(map[string]string, len(library)),
+		input:   textinput.New(),
+		Width:   defaultWidth,
+		Height:  defaultHeight,
+		Styles:  DefaultStyles(),
+		Help:    help.New(),
+		KeyMap:  keymaps.DefaultKeyMap(),
+	}
+	for _, b := range library {
+		m.titles[b.Path] = b.Title
+	}
+	m.input.Prompt = "full text: "
+	m.input.Placeholder = "words from a passage"
+	m.input.Focus()
+	return m
+}
+
+func (m FullTextModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+// search updates the index and searches it for q.
+func (m FullTextModel) search(q string) tea.Cmd {
+	ix := m.index
+	var paths []string
+	for _, b := range m.library {
+		paths = append(paths, b.Path)
+	}
+	return func() tea.Msg {
+		msg := fullTextResultsMsg{query: q, index: ix}
+		if ix == nil {
+			path, err := fulltext.DefaultPath()
+			if err == nil {
+				ix, err = fulltext.Open(path)
+			}
+			if err != nil {
+				msg.err = err
+				return msg
+			}
+			msg.index = ix
+		}
+
+		res := ix.Update(paths)
+		if res.Indexed > 0 || res.Removed > 0 {
+			msg.err = ix.Save()
+		}
+		msg.status = fmt.Sprintf("%d books indexed, %d unreadable", ix.Len()-len(res.Failed), len(res.Failed))
+
+		results, err := ix.Search(q, fullTextLimit)
+		msg.results = results
+		if msg.err == nil {
+			msg.err = err
+		}
+		return msg
+	}
+}
+
+func (m FullTextModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Width, m.Height = msg.Width, msg.Height
+		m.Help.Width = msg.Width
+		m.input.Width = max(1, msg.Width-lipgloss.Width(m.input.Prompt)-1)
+		return m, nil
+
+	case fullTextResultsMsg:
+		m.index = msg.index
+		m.err = msg.err
+		m.status = msg.status
+		if msg.query == m.searched {
+			m.results = msg.results
+			m.cursor = 0
+		}
+		return m, nil
+
+	case tea.MouseMsg:
+		if d := wheel(msg); d != 0 {
+			m.cursor = max(0, min(m.cursor+d, len(m.results)-1))
+		} else if leftClick(msg) {
+			m.click(msg.Y)
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.KeyMap.Enter):
+			q := strings.TrimSpace(m.input.Value())
+			if q != m.searched {
+				m.searched = q
+				m.status = "searching…"
+				return m, m.search(q)
+			}
+			if m.cursor < len(m.results) {
+				m.err = OpenFile(m.results[m.cursor].Path)
+			}
+			return m, nil
+
+		case key.Matches(msg, m.KeyMap.NextField):
+			m.cursor = min(m.cursor+1, max(0, len(m.results)-1))
+			return m, nil
+
+		case key.Matches(msg, m.KeyMap.PrevField):
+			m.cursor = max(m.cursor-1, 0)
+			return m, nil
+
+		case key.Matches(msg, m.KeyMap.Quit):
+			return m, func() tea.Msg { return ExitFullTextViewMsg{} }
+		}
+	}
+
+	m.input, cmd = m.input.Update(msg)
+	return m, cmd
+}
+
+// highlight renders the snippet with its highlighted words styled.
+func (m FullTextModel) highlight(r fulltext.Result) string {
+	var s strings.Builder
+	last := 0
+	for _, h := range r.Highlights {
+		s.WriteString(r.Snippet[last:h[0]])
+		s.WriteString(m.Styles.highlighted.Render(r.Snippet[h[0]:h[1]]))
+		last = h[1]
+	}
+	s.WriteString(r.Snippet[last:])
+	return s.String()
+}
+
+func (m FullTextModel) View() string {
+	header, footer := m.headerView(), m.footerView()
+	results := m.resultsView(m.resultsHeight())
+	view := lipgloss.JoinVertical(lipgloss.Left, header+results+footer, m.helpView())
+	return lipgloss.NewStyle().MaxWidth(m.Width).MaxHeight(m.Height).Render(view)
+}
+
+// headerView renders the input and the state of the search above the
+// results.
+func (m FullTextModel) headerView() string {
+	var s strings.Builder
+	s.WriteString(m.input.View() + "\n")
+	if m.status != "" {
+		s.WriteString(m.Styles.greyed.Render(m.status) + "\n")
+	}
+	s.WriteString("\n")
+
+	if m.searched != "" && len(m.results) == 0 && m.status != "searching…" {
+		s.WriteString(m.Styles.greyed.Render("no book contains all of these words") + "\n")
+	}
+	return s.String()
+}
+
+// footerView renders the error under the results, if any.
+func (m FullTextModel) footerView() string {
+	if m.err == nil {
+		return ""
+	}
+	return "\n" + m.Styles.errorText.Render(m.err.Error()) + "\n"
+}
+
+// resultsHeight returns the number of lines left for the results.
+func (m FullTextModel) resultsHeight() int {
+	return m.Height - lipgloss.Height(m.headerView()) - lipgloss.Height(m.footerView()) - lipgloss.Height(m.helpView())
+}
+
+// click highlights the result at line y.
+func (m *FullTextModel) click(y int) {
+	y -= strings.Count(m.headerView(), "\n")
+	blocks, start := m.resultBlocks(m.resultsHeight())
+	for i := start; i < len(blocks) && y >= 0; i++ {
+		if y < lipgloss.Height(blocks[i])-1 {
+			m.cursor = i
+			return
+		}
+		y -= lipgloss.Height(blocks[i]) - 1
+	}
+}
+
+// resultsView renders the results that fit in height lines.
+func (m FullTextModel) resultsView(height int) string {
+	blocks, start := m.resultBlocks(height)
+	var s strings.Builder
+	used := 0
+	for _, b := range blocks[start:] {
+		if used += lipgloss.Height(b) - 1; used > height && s.Len() > 0 {
+			break
+		}
+		s.WriteString(b)
+	}
+	return s.String()
+}
+
+// resultBlocks renders every result, and returns the first one shown in
+// height lines: the first one, or the one leading to the cursor once it goes
+// past them.
+func (m FullTextModel) resultBlocks(height int) (blocks []string, start int) {
+	blocks = make([]string, len(m.results))
+	for i, r := range m.results {
+		title := m.titles[r.Path]
+		if title == "" {
+			title = r.Path
+		}
+		var b strings.Builder
+		if i == m.cursor {
+			b.WriteString(m.Styles.cursor.Render("> ") + m.Styles.highlighted.Render(title))
+		} else {
+			b.WriteString("  " + m.Styles.choices.Render(title))
+		}
+		b.WriteString(m.Styles.greyed.Render(" — "+r.Chapter) + "\n")
+		b.WriteString(lipgloss.NewStyle().PaddingLeft(4).Width(max(10, m.Width)).Render(m.highlight(r)) + "\n")
+		blocks[i] = b.String()
+	}
+
+	used := 0
+	for i := 0; i <= m.cursor && i < len(blocks); i++ {
+		used += lipgloss.Height(blocks[i]) - 1
+		for used > height && start < i {
+			used -= lipgloss.Height(blocks[start]) - 1
+			start++
+		}
+	}
+	return blocks, start
+}
+
+func (m FullTextModel) helpView() string {
+	return m.Styles.HelpStyle.Render(m.Help.View(m))
+}
+
+func (m FullTextModel) FullHelp() [][]key.Binding {
+	return [][]key.Binding{m.ShortHelp()}
+}
+
+// ShortHelp returns bindings to show in the abbreviated help view. It's part
+// of the help.KeyMap interface.
+func (m FullTextModel) ShortHelp() []key.Binding {
+	return []key.Binding{
+		key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "search/open")),
+		m.KeyMap.NextField,
+		m.KeyMap.PrevField,
+		m.KeyMap.Quit,
+	}
+}
@@ -0,0 +1,303 @@
+package models
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"Bonalioteko/cover"
+	"Bonalioteko/metadata"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+const (
+	// galleryCoverCols is the width of a cover of the gallery, and
+	// galleryGap the space between two of them.
+	galleryCoverCols = 16
+	galleryGap       = 2
+	// galleryTextRows is the number of lines under a cover: the title and
+	// the authors.
+	galleryTextRows = 2
+	// galleryCoverID is the kitty image id of the first cover of the
+	// gallery page. The others follow it.
+	galleryCoverID = previewCoverID + 1
+)
+
+// openGallery shows books as a grid of covers, starting at the one at
+// cursor. The gallery goes back to the current view when it is closed.
+func (m *Model) openGallery(books []metadata.Book, cursor int) {
+	m.galleryBooks = books
+	m.galleryCursor = cursor
+	m.galleryTop = 0
+	m.galleryReturn = m.state
+	m.state = galleryView
+	m.scrollGallery()
+}
+
+// closeGallery goes back to the view the gallery was opened from, with the
+// book highlighted in the gallery highlighted there too.
+func (m *Model) closeGallery() {
+	m.state = m.galleryReturn
+	book, ok := m.galleryBook()
+	if !ok {
+		return
+	}
+	switch m.state {
+	case filterView:
+		for i, item := range m.filterModel.VisibleItems() {
+			if title, ok := item.(*TitleItem); ok && title.Book.Path == book.Path {
+				m.filterModel.Select(i)
+				break
+			}
+		}
+	default:
+		for i := range m.books {
+			if m.books[i].Path == book.Path {
+				m.highlighted = i
+				m.scrollToCursor()
+				break
+			}
+		}
+	}
+}
+
+// filteredBooks returns the books left by the applied filter, and the
+// position of the selected one among them.
+func (m Model) filteredBooks() (books []metadata.Book, cursor int) {
+	selected := m.filterModel.SelectedItem()
+	for _, item := range m.filterModel.VisibleItems() {
+		if title, ok := item.(*TitleItem); ok {
+			if item == selected {
+				cursor = len(books)
+			}
+			books = append(books, title.Book)
+		}
+	}
+	return books, cursor
+}
+
+// galleryBook returns the book under the gallery cursor, if any.
+func (m Model) galleryBook() (metadata.Book, bool) {
+	if m.galleryCursor < 0 || m.galleryCursor >= len(m.galleryBooks) {
+		return metadata.Book{}, false
+	}
+	return m.galleryBooks[m.galleryCursor], true
+}
+
+// galleryLayout returns the number of covers per row, the number of rows on
+// screen and the height of the covers. The covers are as tall as a 2:3
+// book, less when the terminal is too short for a row of them.
+func (m Model) galleryLayout() (cols, rows, coverRows int) {
+	height := max(1, m.Height-lipgloss.Height(m.galleryHelpView())-1)
+	cols = max(1, (m.Width+galleryGap)/(galleryCoverCols+galleryGap))
+	_, coverRows = cover.Fit(image.Pt(2, 3), m.coverCell, galleryCoverCols, height)
+	coverRows = max(1, min(coverRows, height-galleryTextRows))
+	// Rows are a line apart.
+	rows = max(1, (height+1)/(coverRows+galleryTextRows+1))
+	return cols, rows, coverRows
+}
+
+// galleryPage returns the books of the gallery on screen.
+func (m Model) galleryPage() []metadata.Book {
+	cols, rows, _ := m.galleryLayout()
+	start := min(len(m.galleryBooks), m.galleryTop*cols)
+	return m.galleryBooks[start:min(len(m.galleryBooks), start+cols*rows)]
+}
+
+// scrollGallery keeps the gallery cursor within the books and scrolls the
+// gallery so that its row is on screen.
+func (m *Model) scrollGallery() {
+	cols, rows, _ := m.galleryLayout()
+	m.galleryCursor = max(0, min(m.galleryCursor, len(m.galleryBooks)-1))
+	row := m.galleryCursor / cols
+	if row < m.galleryTop {
+		m.galleryTop = row
+	}
+	if row >= m.galleryTop+rows {
+		m.galleryTop = row - rows + 1
+	}
+	lastRow := (len(m.galleryBooks) - 1) / cols
+	m.galleryTop = max(0, min(m.galleryTop, lastRow-rows+1))
+}
+
+// updateGallery handles the keys of the gallery.
+func (m *Model) updateGallery(msg tea.KeyMsg) tea.Cmd {
+	cols, rows, _ := m.galleryLayout()
+	switch {
+	case key.Matches(msg, m.KeyMap.CursorLeft):
+		m.galleryCursor--
+	case key.Matches(msg, m.KeyMap.CursorRight):
+		m.galleryCursor++
+	case key.Matches(msg, m.KeyMap.CursorUp):
+		if m.galleryCursor >= cols {
+			m.galleryCursor -= cols
+		}
+	case key.Matches(msg, m.KeyMap.CursorDown):
+		// The last row can be shorter than the others.
+		if m.galleryCursor/cols < (len(m.galleryBooks)-1)/cols {
+			m.galleryCursor += cols
+		}
+	case key.Matches(msg, m.KeyMap.PageUp):
+		m.galleryCursor -= cols * rows
+	case key.Matches(msg, m.KeyMap.PageDown):
+		m.galleryCursor += cols * rows
+	case key.Matches(msg, m.KeyMap.Home):
+		m.galleryCursor = 0
+	case key.Matches(msg, m.KeyMap.End):
+		m.galleryCursor = len(m.galleryBooks) - 1
+	case key.Matches(msg, m.KeyMap.Enter):
+		if book, ok := m.galleryBook(); ok {
+			if err := OpenFile(book.Path); err != nil {
+				m.err = err
+			}
+		}
+	case key.Matches(msg, m.KeyMap.Gallery), key.Matches(msg, m.KeyMap.Quit):
+		m.closeGallery()
+		return nil
+	}
+	m.scrollGallery()
+	return nil
+}
+
+// updateGalleryMouse selects the cover clicked on, and scrolls the gallery
+// by a row per turn of the wheel.
+func (m *Model) updateGalleryMouse(msg tea.MouseMsg) {
+	if d := wheel(msg); d != 0 {
+		m.updateGallery(wheelKey(d))
+		return
+	}
+	if !leftClick(msg) || msg.Y < 1 {
+		return
+	}
+	cols, rows, coverRows := m.galleryLayout()
+	// The rows of covers start under the header, a line apart.
+	slot := coverRows + galleryTextRows + 1
+	row, dy := (msg.Y-1)/slot, (msg.Y-1)%slot
+	col, dx := msg.X/(galleryCoverCols+galleryGap), msg.X%(galleryCoverCols+galleryGap)
+	if row >= rows || dy == slot-1 || col >= cols || dx >= galleryCoverCols {
+		return
+	}
+	if i := (m.galleryTop+row)*cols + col; i < len(m.galleryBooks) {
+		m.galleryCursor = i
+	}
+}
+
+// galleryTile renders a book of the gallery: its cover, or a frame with its
+// title when it has none, above its title and authors. The image is drawn
+// as the kitty image id.
+func (m Model) galleryTile(book metadata.Book, selected bool, coverRows, id int) []string {
+	blank := strings.Repeat(" ", galleryCoverCols)
+	var art []string
+	entry := m.covers.get(book.Path)
+	hasCover := entry != nil && entry.img != nil
+	if hasCover {
+		art = entry.render(&entry.tile, m.coverProtocol, m.coverCell, galleryCoverCols, coverRows, id)
+	} else if coverRows > 2 {
+		frame := m.Styles.pane.Width(galleryCoverCols-2).Height(coverRows-2).
+			Align(lipgloss.Center, lipgloss.Center).
+			Render(m.Styles.greyed.Render(ansi.Truncate(book.Title, galleryCoverCols-2, "…")))
+		art = strings.Split(frame, "\n")
+	}
+
+	lines := make([]string, 0, coverRows+galleryTextRows)
+	for i := range coverRows {
+		line := blank
+		if i < len(art) {
+			line = padRight(art[i], galleryCoverCols)
+		}
+		lines = append(lines, line)
+	}
+	if !hasCover {
+		// The slot may still show the image of the book it held before.
+		lines[0] = cover.Clear(m.coverProtocol, id) + lines[0]
+	}
+
+	title := ansi.Truncate(book.Title, galleryCoverCols-1, "…")
+	if selected {
+		title = m.Styles.cursor.Render(m.cursor) + m.Styles.highlighted.Render(title)
+	} else {
+		title = " " + m.Styles.choices.Render(title)
+	}
+	lines = append(lines,
+		padRight(title, galleryCoverCols),
+		padRight(m.Styles.greyed.Render(ansi.Truncate(book.Author(), galleryCoverCols, "…")), galleryCoverCols))
+	return lines
+}
+
+// padRight pads s with spaces to width cells.
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(0, width-ansi.StringWidth(s)))
+}
+
+// clearGallery returns the escapes removing the kitty images of the gallery
+// page from the screen.
+func (m Model) clearGallery() string {
+	if m.coverProtocol != cover.Kitty {
+		return ""
+	}
+	cols, rows, _ := m.galleryLayout()
+	var b strings.Builder
+	for i := range cols * rows {
+		b.WriteString(cover.Clear(m.coverProtocol, galleryCoverID+i))
+	}
+	return b.String()
+}
+
+// galleryView renders the page of the gallery under a line telling where
+// it is, with the keys of the gallery below.
+func (m Model) galleryView() string {
+	cols, rows, coverRows := m.galleryLayout()
+	page := m.galleryPage()
+	first := m.galleryTop * cols
+
+	header := m.Styles.greyed.Render(fmt.Sprintf("%d books", len(m.galleryBooks)))
+	if len(m.galleryBooks) > 0 {
+		header = m.Styles.greyed.Render(fmt.Sprintf("%d/%d books · sort: %s", m.galleryCursor+1, len(m.galleryBooks), m.sortOrder()))
+	}
+	// Slots left empty at the end still hold the images they showed.
+	for i := len(page); i < cols*rows; i++ {
+		header = cover.Clear(m.coverProtocol, galleryCoverID+i) + header
+	}
+	lines := []string{ansi.Truncate(header, m.Width, "…")}
+
+	gap := strings.Repeat(" ", galleryGap)
+	for row := 0; row*cols < len(page); row++ {
+		if row > 0 {
+			lines = append(lines, "")
+		}
+		var tiles [][]string
+		for col := range cols {
+			i := row*cols + col
+			if i >= len(page) {
+				break
+			}
+			tiles = append(tiles, m.galleryTile(page[i], first+i == m.galleryCursor, coverRows, galleryCoverID+i))
+		}
+		for y := range coverRows + galleryTextRows {
+			parts := make([]string, len(tiles))
+			for i, tile := range tiles {
+				parts[i] = tile[y]
+			}
+			lines = append(lines, strings.Join(parts, gap))
+		}
+	}
+
+	body := lipgloss.NewStyle().Height(m.Height - lipgloss.Height(m.galleryHelpView())).Render(strings.Join(lines, "\n"))
+	return lipgloss.JoinVertical(lipgloss.Left, body, m.galleryHelpView())
+}
+
+// galleryHelpView lists the keys of the gallery.
+func (m Model) galleryHelpView() string {
+	return m.Styles.HelpStyle.MaxWidth(m.Width).Render(m.Help.ShortHelpView([]key.Binding{
+		m.KeyMap.CursorLeft,
+		m.KeyMap.CursorRight,
+		m.KeyMap.CursorUp,
+		m.KeyMap.CursorDown,
+		m.KeyMap.Enter,
+		m.KeyMap.Gallery,
+	}))
+}
@@ -3,46 +3,40 @@ package models
 import (
 	"context"
 	"fmt"
-	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
+	"strings"
 	"time"
 
+	"Bonalioteko/config"
+	"Bonalioteko/history"
+	"Bonalioteko/metadata"
+	"Bonalioteko/query"
+	"Bonalioteko/search"
 	"Bonalioteko/xattr"
 
 	"github.com/charmbracelet/bubbles/list"
-	"github.com/pirmd/epub"
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/text/collate"
 )
 
 func (m *Model) moveCursorUp() {
 	m.highlighted--
-	if m.highlighted < 0 {
-		m.highlighted = 0
-	}
-	if m.highlighted < m.min {
-		m.min--
-		m.max--
-	}
+	m.scrollToCursor()
 }
 
 func (m *Model) moveCursorDown() {
 	m.highlighted++
-	if m.highlighted >= len(m.choices) {
-		m.highlighted = len(m.choices) - 1
-	}
-	if m.highlighted > m.max {
-		m.min++
-		m.max++
-	}
+	m.scrollToCursor()
 }
 
 func (m *Model) moveTagSelectorRight() {
 	m.highlightedtagpos++
-	if m.highlightedtagpos >= len(m.tagnames) {
-		m.highlightedtagpos = len(m.tagnames) - 1
+	if m.highlightedtagpos >= m.tagBarLen() {
+		m.highlightedtagpos = m.tagBarLen() - 1
 	}
 	if m.highlightedtagpos > m.maxtag {
 		m.mintag++
@@ -61,146 +55,495 @@ func (m *Model) moveTagSelectorLeft() {
 	}
 }
 
-func getTitlesFromPaths(paths []string) []string {
-	var titles []string
-	if paths == nil {
-		return titles
+// tagBarLen returns the number of entries of the tag bar: the saved
+// collections followed by the tags.
+func (m Model) tagBarLen() int {
+	return len(m.config.Collections) + len(m.tagnames)
+}
+
+// highlightedTagItem returns the tag under the tag cursor, unless the cursor
+// is on a collection.
+func (m Model) highlightedTagItem() (*TagItem, bool) {
+	i := m.highlightedtagpos - len(m.config.Collections)
+	if i < 0 || i >= len(m.tagnames) {
+		return nil, false
 	}
+	return m.tagnames[i], true
+}
 
-	for _, p := range paths {
-		metadata, err := epub.GetMetadataFromFile(p)
-		if err != nil || len(metadata.Title) == 0 {
-			titles = append(titles, filepath.Base(p))
-			continue
+// highlightedBook returns the book under the cursor, if any.
+func (m Model) highlightedBook() (metadata.Book, bool) {
+	if m.highlighted < 0 || m.highlighted >= len(m.books) {
+		return metadata.Book{}, false
+	}
+	return m.books[m.highlighted], true
+}
+
+// replaceBook swaps the book with the same path for book in the library
+// and in the current results.
+func (m *Model) replaceBook(book metadata.Book) {
+	for _, books := range [][]metadata.Book{m.library, m.books} {
+		for i := range books {
+			if books[i].Path == book.Path {
+				books[i] = book
+			}
 		}
-		titles = append(titles, metadata.Title[0])
 	}
-	return titles
+	m.setListItems(m.listItems())
 }
 
-func (m *Model) selectOrDeselectTag() {
-	targetTag := m.tagnames[m.highlightedtagpos]
+// setListItems replaces the items of the filter list, along with the filter
+// that evaluates searches against them.
+func (m *Model) setListItems(items []list.Item) {
+	m.filterModel.Filter = m.filterFunc(items)
+	m.filterModel.SetItems(items)
+}
 
-	isSelected := slices.ContainsFunc(m.selectedTags, func(t *TagItem) bool {
-		return t.Tag == targetTag.Tag
-	})
+// filterFunc returns the filter of the list for the search mode.
+func (m Model) filterFunc(items []list.Item) list.FilterFunc {
+	if m.regexMode {
+		return regexFilter(items)
+	}
+	return searchFilter(items, m.tags)
+}
 
-	if isSelected {
-		m.selectedTags = slices.DeleteFunc(m.selectedTags, func(t *TagItem) bool {
-			return t.Tag == targetTag.Tag // Compare tag names instead of memory addresses
-		})
+// toggleRegexMode switches the filter between searches and regular
+// expressions, and filters the list again.
+func (m *Model) toggleRegexMode() {
+	m.regexMode = !m.regexMode
+	m.filterModel.FilterInput.Prompt = "Filter: "
+	if m.regexMode {
+		m.filterModel.FilterInput.Prompt = "Regex: "
+	}
+	m.filterModel.Filter = m.filterFunc(m.filterModel.Items())
+	m.setFilterText(m.filterModel.FilterValue())
+}
 
-		targetTag.status = false
-	} else {
-		m.selectedTags = append(m.selectedTags, targetTag)
-		targetTag.status = true
+// setFilterText replaces the text of the filter being typed.
+func (m *Model) setFilterText(s string) {
+	m.filterModel.SetFilterText(s)
+	m.filterModel.SetFilterState(list.Filtering)
+}
+
+// browseHistory shows the previous or next search of the history in the
+// filter.
+func (m *Model) browseHistory(prev bool) {
+	s, ok := m.searches.Next()
+	if prev {
+		s, ok = m.searches.Prev(m.filterModel.FilterValue())
 	}
+	if ok {
+		m.setFilterText(s)
+	}
+}
 
-	if len(m.selectedTags) == 0 {
-		m.choices = m.initialChoices
-		m.ebookPaths = find(m.rootdir, ".epub")
-		m.highlighted = 0
-		NewTagsToPath := SetTagToPathMap(m.ebookPaths)
-		uniqueTags := xattr.GetUniqueTags(NewTagsToPath)
+// addSearch records an accepted search in the history file.
+func (m *Model) addSearch(s string) {
+	m.searches.Add(s)
+	if err := m.searches.Save(); err != nil {
+		log.Printf("Warning: could not save the search history: %v", err)
+	}
+}
 
-		var newItems []list.Item
-		newItems, m.tagnames = GetFilterListItems(uniqueTags, m.choices)
-		m.filterModel.SetItems(newItems)
-		m.highlighted = 0
-		m.highlightedtagpos = 0
+// loadSearchHistory reads the search history from the state directory. The
+// history is kept in memory only when it has no file.
+func loadSearchHistory() *history.History {
+	path, err := history.DefaultPath()
+	if err != nil {
+		log.Printf("Warning: search history: %v", err)
+		return &history.History{}
+	}
+	h, err := history.Load(path)
+	if err != nil {
+		log.Printf("Warning: search history: %v", err)
+	}
+	return h
+}
 
-	} else {
-		selectedTagString := GetTagStrings(m.selectedTags)
-		m.ebookPaths = xattr.MultipleTagsFilter(selectedTagString, m.tags)
-		m.choices = getTitlesFromPaths(m.ebookPaths)
-		NewTagsToPath := SetTagToPathMap(m.ebookPaths)
-		uniqueTags := xattr.GetUniqueTags(NewTagsToPath)
-
-		var newTagItems []*TagItem
-		for _, tagName := range uniqueTags {
-			status := false
-
-			for _, oldTag := range m.tagnames {
-				if oldTag.Tag == tagName {
-					status = oldTag.status
-					break
-				}
-			}
+// loadViewState overrides the settings with the state of the views saved in
+// the state directory.
+func loadViewState(settings *config.SettingsConfig) {
+	path, err := config.ViewStatePath()
+	if err != nil {
+		log.Printf("Warning: view state: %v", err)
+		return
+	}
+	s, err := config.LoadViewState(path)
+	if err != nil {
+		log.Printf("Warning: view state: %v", err)
+		return
+	}
+	s.Apply(settings)
+}
 
-			newTagItems = append(newTagItems, &TagItem{
-				Tag:    tagName,
-				status: status,
-			})
+// saveViewState saves the state of the views held in the settings to the
+// state directory.
+func saveViewState(settings config.SettingsConfig) {
+	path, err := config.ViewStatePath()
+	if err == nil {
+		err = config.ViewStateOf(settings).Save(path)
+	}
+	if err != nil {
+		log.Printf("Warning: view state: %v", err)
+	}
+}
+
+// listItems returns the tags and books shown by the filter list.
+func (m Model) listItems() []list.Item {
+	var items []list.Item
+	for _, tagPtr := range m.tagnames {
+		items = append(items, tagPtr)
+	}
+	for _, book := range m.books {
+		items = append(items, &TitleItem{Book: book})
+	}
+	return items
+}
+
+// tagQuery builds the query selected in the tag bar: the included tags
+// joined with and, or with or when toggled, minus the excluded tags.
+func (m Model) tagQuery() query.Node {
+	var included, excluded []query.Node
+	for _, tag := range m.selectedTags {
+		switch tag.status {
+		case TagInclude:
+			included = append(included, query.Tag{Name: tag.Tag})
+		case TagExclude:
+			excluded = append(excluded, query.Not{X: query.Tag{Name: tag.Tag}})
 		}
+	}
 
-		m.tagnames = newTagItems
+	var nodes []query.Node
+	switch {
+	case len(included) > 1 && m.joinOr:
+		nodes = append(nodes, query.Or(included))
+	default:
+		nodes = append(nodes, included...)
+	}
+	nodes = append(nodes, excluded...)
 
-		var newItems []list.Item
-		for _, tagPtr := range m.tagnames {
-			newItems = append(newItems, tagPtr)
+	switch len(nodes) {
+	case 0:
+		return nil
+	case 1:
+		return nodes[0]
+	}
+	return query.And(nodes)
+}
+
+// applyTagFilter narrows the library down to the books of the open
+// collection matching the query of the tag bar and the selected facets,
+// keeping the library order.
+func (m *Model) applyTagFilter() {
+	library := m.collectionBooks()
+	if node := m.tagQuery(); node != nil {
+		paths := query.Eval(node, m.tags, metadata.Paths(library))
+		library = metadata.WithPaths(library, paths)
+	}
+	m.unfaceted = library
+	m.books = m.facets.Narrow(library)
+	m.tagCounts = make(map[string]int)
+	for tag, paths := range tagsWithin(m.tags, metadata.Paths(m.books)) {
+		m.tagCounts[tag] = len(paths)
+	}
+	m.scrollToCursor()
+}
+
+// collectionBooks evaluates the open collection against the library. It
+// returns the whole library when no collection is open.
+func (m *Model) collectionBooks() []metadata.Book {
+	if m.collection == "" {
+		return m.library
+	}
+	col, ok := m.config.Collection(m.collection)
+	if !ok {
+		m.collection = ""
+		return m.library
+	}
+	books, err := search.Select(m.library, m.tags, col.Query, col.Search)
+	if err != nil {
+		m.err = fmt.Errorf("collection %s: %w", col.Name, err)
+		m.collection = ""
+		return m.library
+	}
+	if col.Sort != "" {
+		metadata.SortBooks(books, m.sortOrder(), m.locale)
+	}
+	return books
+}
+
+// sortOrder returns the order of the book list: the one of the open
+// collection, if it has one, or the sort setting.
+func (m Model) sortOrder() metadata.SortOrder {
+	if col, ok := m.config.Collection(m.collection); ok && col.Sort != "" {
+		if o, err := metadata.ParseSortOrder(col.Sort); err == nil {
+			return o
 		}
-		for _, choice := range m.choices {
-			newItems = append(newItems, &TitleItem{Book: choice})
+	}
+	return m.sort
+}
+
+// setSort changes the order of the book list and saves it in the view
+// state, so that it is kept across restarts.
+func (m *Model) setSort(o metadata.SortOrder) {
+	m.sort = o
+	metadata.SortBooks(m.library, o, m.locale)
+	m.refreshResults()
+
+	m.config.Settings.Sort = o.String()
+	saveViewState(m.config.Settings)
+}
+
+// toggleCollection opens the collection, or closes it when it is open.
+func (m *Model) toggleCollection(name string) {
+	if m.collection == name {
+		m.collection = ""
+	} else {
+		m.collection = name
+	}
+	m.refreshResults()
+}
+
+// newCollection returns the collection showing the current results: the
+// open collection narrowed down by the tag bar and the search.
+func (m Model) newCollection(name, text string) config.Collection {
+	col := config.Collection{Name: name, Search: text, Sort: m.sortOrder().String()}
+	var nodes query.And
+	if open, ok := m.config.Collection(m.collection); ok {
+		if node, err := query.Parse(open.Query); err == nil && node != nil {
+			nodes = append(nodes, node)
 		}
-		m.filterModel.SetItems(newItems)
-		m.highlighted = 0
-		m.highlightedtagpos = 0
+		col.Search = strings.TrimSpace(open.Search + " " + text)
 	}
+	if node := m.tagQuery(); node != nil {
+		nodes = append(nodes, node)
+	}
+	switch len(nodes) {
+	case 0:
+	case 1:
+		col.Query = nodes[0].String()
+	default:
+		col.Query = nodes.String()
+	}
+	return col
 }
 
-func SetTagToPathMap(paths []string) map[string][]string {
-	result := make(map[string][]string)
-	for _, path := range paths {
-		tags, _ := xattr.GetTagsFromPath(path)
-		xattr.AddTagAndFile(path, tags, result)
+// saveCollection adds the collection to the config file and the tag bar.
+func (m *Model) saveCollection(col config.Collection) error {
+	cfg := m.config
+	cfg.Collections = slices.Clone(cfg.Collections)
+	cfg.SaveCollection(col)
+	if err := config.WriteCollections(cfg.Collections); err != nil {
+		return err
 	}
-	return result
+	m.config = cfg
+	return nil
 }
 
-func find(root, ext string) []string {
-	var filename []string
-	filepath.WalkDir(root, func(s string, d fs.DirEntry, e error) error {
-		if e != nil {
-			return e
+// cycleTag moves the highlighted tag to its next state: neutral tags get
+// included, included tags excluded and excluded tags neutral again.
+func (m *Model) cycleTag() {
+	if i := m.highlightedtagpos; i >= 0 && i < len(m.config.Collections) {
+		m.toggleCollection(m.config.Collections[i].Name)
+		return
+	}
+	targetTag, ok := m.highlightedTagItem()
+	if !ok {
+		return
+	}
+
+	m.selectedTags = slices.DeleteFunc(m.selectedTags, func(t *TagItem) bool {
+		return t.Tag == targetTag.Tag // Compare tag names instead of memory addresses
+	})
+
+	targetTag.status = targetTag.status.Next()
+	if targetTag.status != TagNeutral {
+		m.selectedTags = append(m.selectedTags, targetTag)
+	}
+
+	m.refreshResults()
+}
+
+// refreshResults reapplies the tag bar query and lists the tags again with
+// their counts in the new results.
+func (m *Model) refreshResults() {
+	if tag, ok := m.highlightedTagItem(); ok {
+		defer m.highlightTag(tag.Tag)
+	}
+
+	m.applyTagFilter()
+	m.setTagItems()
+	m.setListItems(m.listItems())
+	m.highlighted = 0
+	m.scrollToCursor()
+}
+
+// reorderTags lists the tags again in the order of the tag bar, keeping the
+// tag cursor on the highlighted tag.
+func (m *Model) reorderTags() {
+	if tag, ok := m.highlightedTagItem(); ok {
+		defer m.highlightTag(tag.Tag)
+	}
+	m.setTagItems()
+	m.setListItems(m.listItems())
+}
+
+// setTagItems lists every tag of the library in the tag bar, in its order.
+// Tags keep the state they were cycled to. Tags that no result carries stay
+// listed, so that they can be cycled back and widen an or query.
+func (m *Model) setTagItems() {
+	status := make(map[string]TagState, len(m.tagnames))
+	for _, t := range m.tagnames {
+		status[t.Tag] = t.status
+	}
+	collator := collate.New(m.locale, collate.Loose, collate.Numeric)
+	tags := search.OrderTags(xattr.GetUniqueTags(m.tags), m.tagOrder,
+		m.config.Settings.PinnedTags, m.config.Settings.ManualTags, m.tagCounts, collator.CompareString)
+
+	m.tagnames = make([]*TagItem, len(tags))
+	for i, tag := range tags {
+		m.tagnames[i] = &TagItem{Tag: tag, status: status[tag]}
+	}
+	// The selected tags point at the items they were cycled on.
+	for i, selected := range m.selectedTags {
+		if j := slices.IndexFunc(m.tagnames, func(t *TagItem) bool { return t.Tag == selected.Tag }); j >= 0 {
+			m.selectedTags[i] = m.tagnames[j]
 		}
-		if filepath.Ext(d.Name()) == ext {
-			filename = append(filename, s)
+	}
+}
+
+// pinned reports whether the tag is pinned at the front of the tag bar.
+func (m Model) pinned(tag string) bool {
+	return slices.Contains(m.config.Settings.PinnedTags, tag)
+}
+
+// dimmed reports whether selecting the tag would leave no result: no
+// result carries it and it would not widen an or query.
+func (m Model) dimmed(t *TagItem) bool {
+	if t.status != TagNeutral || m.tagCounts[t.Tag] > 0 {
+		return false
+	}
+	if m.joinOr {
+		for _, s := range m.selectedTags {
+			if s.status == TagInclude {
+				return false
+			}
 		}
-		return nil
-	})
-	return filename
+	}
+	return true
+}
+
+// setTagBar changes the order of the tag bar and saves it in the view
+// state, so that it is kept across restarts.
+func (m *Model) setTagBar(order search.TagOrder, pinned, manual []string) {
+	m.config.Settings.TagOrder = string(order)
+	m.config.Settings.PinnedTags = pinned
+	m.config.Settings.ManualTags = manual
+	saveViewState(m.config.Settings)
+	m.tagOrder = order
+	m.reorderTags()
 }
 
-func GetEpubTitles(directory string) []string {
-	var titlesSlice []string
-	for _, path := range find(directory, ".epub") {
-		metadata, err := epub.GetMetadataFromFile(path)
-		if err != nil || len(metadata.Title) == 0 {
-			log.Printf("Warning: could not read metadata for %s: %v", path, err)
-			titlesSlice = append(titlesSlice, filepath.Base(path))
-			continue
+// togglePin pins the highlighted tag at the front of the tag bar, or
+// unpins it.
+func (m *Model) togglePin() {
+	tag, ok := m.highlightedTagItem()
+	if !ok {
+		return
+	}
+	pinned := slices.Clone(m.config.Settings.PinnedTags)
+	if i := slices.Index(pinned, tag.Tag); i >= 0 {
+		pinned = slices.Delete(pinned, i, i+1)
+	} else {
+		pinned = append(pinned, tag.Tag)
+	}
+	m.setTagBar(m.tagOrder, pinned, m.config.Settings.ManualTags)
+}
+
+// moveTag moves the highlighted tag by delta places. Pinned tags move among
+// the pinned ones. The others switch the tag bar to the manual order,
+// starting from the order on screen.
+func (m *Model) moveTag(delta int) {
+	tag, ok := m.highlightedTagItem()
+	if !ok {
+		return
+	}
+	pinned := m.config.Settings.PinnedTags
+	if m.pinned(tag.Tag) {
+		m.setTagBar(m.tagOrder, swapped(pinned, tag.Tag, delta), m.config.Settings.ManualTags)
+		return
+	}
+	var order []string
+	for _, t := range m.tagnames {
+		if !m.pinned(t.Tag) {
+			order = append(order, t.Tag)
 		}
-		titlesSlice = append(titlesSlice, metadata.Title[0])
 	}
-	return titlesSlice
+	m.setTagBar(search.TagsManual, pinned, swapped(order, tag.Tag, delta))
+}
+
+// swapped returns a copy of list with tag swapped with the one delta places
+// away, if there is one.
+func swapped(list []string, tag string, delta int) []string {
+	list = slices.Clone(list)
+	i := slices.Index(list, tag)
+	if j := i + delta; i >= 0 && j >= 0 && j < len(list) {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+// reloadTags reads the tags of the library again after books were tagged,
+// and reapplies the tag bar.
+func (m *Model) reloadTags() tea.Cmd {
+	m.tags = loadTags(m.rootdir, m.library)
+	m.reorderTags()
+	if m.highlightedtagpos >= m.tagBarLen() {
+		m.highlightedtagpos = max(0, m.tagBarLen()-1)
+	}
+	return func() tea.Msg { return TagFilterMsg{} }
 }
 
-func GetFilterListItems(tagStrings []string, choicesinit []string) (listItems []list.Item, sharedTags []*TagItem) {
-	for _, t := range tagStrings {
-		sharedTags = append(sharedTags, &TagItem{Tag: t, status: false})
+// libraryTagCounts counts the books of the library carrying each tag.
+func (m Model) libraryTagCounts() map[string]int {
+	counts := make(map[string]int, len(m.tags))
+	for tag, paths := range m.tags {
+		counts[tag] = len(paths)
 	}
+	return counts
+}
+
+// highlightTag moves the tag cursor to the tag, or to the first tag when
+// it is no longer in the tag bar.
+func (m *Model) highlightTag(tag string) {
+	m.highlightedtagpos = len(m.config.Collections) + max(0, slices.IndexFunc(m.tagnames, func(t *TagItem) bool {
+		return t.Tag == tag
+	}))
+}
 
-	combinedList := initItems(choicesinit, tagStrings)
-	for _, tagPtr := range sharedTags {
-		listItems = append(listItems, tagPtr)
+// loadTags reads the tags of the books below rootdir and adds the virtual
+// tags of the library.
+func loadTags(rootdir string, library []metadata.Book) map[string][]string {
+	tags := xattr.GetXattrMapTagToFilePath(rootdir)
+	if shadowed := search.AddVirtualTags(tags, library, time.Now()); len(shadowed) > 0 {
+		log.Printf("Warning: tags hidden by the virtual tags of the same name: %s", strings.Join(shadowed, ", "))
 	}
+	return tags
+}
 
-	for _, tagPtr := range combinedList {
-		if v, ok := tagPtr.(*TitleItem); ok {
-			listItems = append(listItems, v)
+// tagsWithin returns the tags, virtual ones included, of the books at
+// paths.
+func tagsWithin(tags map[string][]string, paths []string) map[string][]string {
+	within := xattr.CreateHashSet(paths)
+	result := make(map[string][]string)
+	for tag, tagPaths := range tags {
+		for _, path := range tagPaths {
+			if within[path] {
+				result[tag] = append(result[tag], path)
+			}
 		}
 	}
-	return listItems, sharedTags
+	return result
 }
 
 // OpenFile opens a file using the default application associated with its file type
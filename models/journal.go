@@ -0,0 +1,159 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"Bonalioteko/journal"
+	"Bonalioteko/metadata"
+	"Bonalioteko/xattr"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// undoMsg asks for the last change to the tags to be undone, or for the
+// last one undone to be redone.
+type undoMsg struct {
+	redo bool
+}
+
+// undo returns the command asking for the last change to the tags to be
+// undone, or redone.
+func undo(redo bool) tea.Cmd {
+	return func() tea.Msg { return undoMsg{redo: redo} }
+}
+
+// tagsRestoredMsg maps the paths of the books an undo or a redo changed to
+// their tags, for the views showing them to follow.
+type tagsRestoredMsg struct {
+	Tags map[string][]string
+}
+
+// tagStore reads and writes the tags of the books for the journal.
+type tagStore struct {
+	embedTags bool
+}
+
+func (s tagStore) Tags(path string) ([]string, error) {
+	return xattr.GetTagsFromPath(path)
+}
+
+func (s tagStore) SetTags(path string, tags []string) error {
+	return writeTags(path, tags, s.embedTags)
+}
+
+// writeTags replaces the tags of the file, in the EPUB too when embedTags
+// is set. The tags are put back when the EPUB can't be written, so that a
+// failed change leaves the file as it was.
+func writeTags(path string, tags []string, embedTags bool) error {
+	if !embedTags {
+		return xattr.SetTags(path, tags)
+	}
+	before, err := xattr.GetTagsFromPath(path)
+	if err != nil {
+		return err
+	}
+	if err := xattr.SetTags(path, tags); err != nil {
+		return err
+	}
+	if err := metadata.WriteSubjects(path, metadata.StoredTags(tags)); err != nil {
+		return restoreTags(path, before, err)
+	}
+	return nil
+}
+
+// restoreTags puts back the tags the file had before a change whose EPUB
+// couldn't be written, err, and returns err.
+func restoreTags(path string, before []string, err error) error {
+	if restoreErr := xattr.SetTags(path, before); restoreErr != nil {
+		return errors.Join(err, fmt.Errorf("restoring the tags: %w", restoreErr))
+	}
+	return err
+}
+
+// newEntry returns the journal entry of a change made now.
+func newEntry(action string, changes ...journal.Change) journal.Entry {
+	return journal.Entry{Time: time.Now(), Action: action, Changes: changes}
+}
+
+// loadJournal reads the journal of the tag changes from the state
+// directory. The journal is kept in memory only when it has no file.
+func loadJournal() *journal.Journal {
+	path, err := journal.DefaultPath()
+	if err != nil {
+		log.Printf("Warning: tag journal: %v", err)
+		return &journal.Journal{}
+	}
+	j, err := journal.Load(path)
+	if err != nil {
+		log.Printf("Warning: tag journal: %v", err)
+	}
+	return j
+}
+
+// record adds a change to the tags to the journal.
+func (m *Model) record(e journal.Entry) {
+	if len(e.Changes) == 0 {
+		return
+	}
+	m.reloadJournal()
+	m.journal.Record(e)
+	if err := m.journal.Save(); err != nil {
+		log.Printf("Warning: tag journal: %v", err)
+	}
+}
+
+// reloadJournal reads the journal again, for the changes recorded from the
+// command line since it was loaded to be kept.
+func (m *Model) reloadJournal() {
+	if err := m.journal.Reload(); err != nil {
+		log.Printf("Warning: tag journal: %v", err)
+	}
+}
+
+// undo undoes the last change to the tags, or redoes the last one undone,
+// and tells the views showing the books it changed.
+func (m *Model) undo(redo bool) tea.Cmd {
+	m.reloadJournal()
+	store := tagStore{embedTags: m.config.Settings.EmbedTags}
+	var e journal.Entry
+	var err error
+	if redo {
+		e, err = m.journal.Redo(store)
+	} else {
+		e, err = m.journal.Undo(store)
+	}
+	if saveErr := m.journal.Save(); saveErr != nil {
+		log.Printf("Warning: tag journal: %v", saveErr)
+	}
+	switch {
+	case errors.Is(err, journal.ErrNothingToUndo), errors.Is(err, journal.ErrNothingToRedo):
+		m.notice = err.Error()
+		return nil
+	case err != nil:
+		m.err = err
+		return nil
+	}
+
+	m.notice = "undid: " + e.Action
+	if redo {
+		m.notice = "redid: " + e.Action
+	}
+	restored := tagsRestoredMsg{Tags: make(map[string][]string, len(e.Changes))}
+	for _, c := range e.Changes {
+		tags := c.Before
+		if redo {
+			tags = c.After
+		}
+		restored.Tags[c.Path] = tags
+		m.pathTags[c.Path] = tags
+	}
+	for _, child := range []*tea.Model{&m.tagModel, &m.detailsModel, &m.bulkTagModel} {
+		if *child != nil {
+			*child, _ = (*child).Update(restored)
+		}
+	}
+	return m.reloadTags()
+}
@@ -0,0 +1,532 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"Bonalioteko/search"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// Size of the terminal assumed until the first tea.WindowSizeMsg.
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// pane is a part of the browse view that can have the focus.
+type pane int
+
+const (
+	// sidebarPane lists the collections, the tags and the facets.
+	sidebarPane pane = iota
+	booksPane
+	// previewPane shows the metadata of the highlighted book.
+	previewPane
+	paneCount
+)
+
+var paneTitles = [paneCount]string{"Tags", "Books", "Preview"}
+
+const (
+	// threePaneWidth is the narrowest terminal the panes are shown side by
+	// side in. Narrower ones show the focused pane alone.
+	threePaneWidth = 90
+	// sidebarWidth is the width of the sidebar, borders included.
+	sidebarWidth = 30
+)
+
+// singleColumn reports whether the terminal is too narrow for the panes to
+// be side by side.
+func (m Model) singleColumn() bool {
+	return m.Width < threePaneWidth
+}
+
+// paneWidths returns the widths of the panes within their borders. In a
+// single column every pane takes the whole width.
+func (m Model) paneWidths() [paneCount]int {
+	if m.singleColumn() {
+		w := max(1, m.Width-2)
+		return [paneCount]int{w, w, w}
+	}
+	preview := (m.Width - sidebarWidth) * 2 / 5
+	books := m.Width - sidebarWidth - preview
+	return [paneCount]int{sidebarWidth - 2, books - 2, preview - 2}
+}
+
+// paneHeight returns the height of the panes within their borders.
+func (m Model) paneHeight() int {
+	height := m.Height - lipgloss.Height(m.helpView()) - 2
+	if m.singleColumn() {
+		// The line naming the panes.
+		height--
+	}
+	return max(1, height)
+}
+
+// bookRows returns the number of books the list shows at once.
+func (m Model) bookRows() int {
+	width := m.paneWidths()[booksPane]
+	return max(1, m.paneHeight()-lipgloss.Height(m.booksHeader(width)))
+}
+
+// scrollToCursor keeps the highlighted book within the books and scrolls
+// the list so that it is visible.
+func (m *Model) scrollToCursor() {
+	rows := m.bookRows()
+	m.highlighted = max(0, min(m.highlighted, len(m.books)-1))
+	if m.highlighted < m.min {
+		m.min = m.highlighted
+	}
+	if m.highlighted >= m.min+rows {
+		m.min = m.highlighted - rows + 1
+	}
+	// Fill the list when it grows taller.
+	m.min = max(0, min(m.min, len(m.books)-rows))
+	m.max = m.min + rows - 1
+	m.previewOffset = 0
+}
+
+// focusPane gives the focus to p. The facets are left when the sidebar
+// loses the focus.
+func (m *Model) focusPane(p pane) {
+	m.focus = (p + paneCount) % paneCount
+	if m.focus != sidebarPane {
+		m.facetFocus = false
+	}
+}
+
+// updatePanes handles the keys that depend on the focused pane, and reports
+// whether msg was one of them. The other keys work the same in every pane.
+func (m *Model) updatePanes(msg tea.KeyMsg) bool {
+	switch {
+	case key.Matches(msg, m.KeyMap.NextPane):
+		m.focusPane(m.focus + 1)
+		return true
+	case key.Matches(msg, m.KeyMap.PrevPane):
+		m.focusPane(m.focus - 1)
+		return true
+	case key.Matches(msg, m.KeyMap.FocusFacets) && !m.facetFocus:
+		m.focusPane(sidebarPane)
+		m.facetFocus = true
+		return true
+	}
+
+	switch m.focus {
+	case sidebarPane:
+		if m.facetFocus {
+			m.updateFacetPanel(msg)
+			return true
+		}
+		switch {
+		case key.Matches(msg, m.KeyMap.CursorUp):
+			m.moveTagSelectorLeft()
+			return true
+		case key.Matches(msg, m.KeyMap.CursorDown):
+			// Below the last tag are the facets.
+			if m.highlightedtagpos >= m.tagBarLen()-1 && len(m.facetRows()) > 0 {
+				m.facetFocus = true
+				m.facetCursor = 0
+				return true
+			}
+			m.moveTagSelectorRight()
+			return true
+		}
+
+	case previewPane:
+		page := m.paneHeight()
+		switch {
+		case key.Matches(msg, m.KeyMap.CursorUp):
+			m.scrollPreview(-1)
+		case key.Matches(msg, m.KeyMap.CursorDown):
+			m.scrollPreview(1)
+		case key.Matches(msg, m.KeyMap.PageUp):
+			m.scrollPreview(-page)
+		case key.Matches(msg, m.KeyMap.PageDown):
+			m.scrollPreview(page)
+		case key.Matches(msg, m.KeyMap.Home):
+			m.previewOffset = 0
+		case key.Matches(msg, m.KeyMap.End):
+			m.scrollPreview(len(m.previewLines(m.paneWidths()[previewPane], page)))
+		default:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// paneAt returns the pane at column x and line y of the browse view, and
+// the cell within its content there, which is outside of it on its border.
+func (m Model) paneAt(x, y int) (p pane, cx, cy int, ok bool) {
+	widths := m.paneWidths()
+	if m.singleColumn() {
+		// Below the line naming the panes.
+		return m.focus, x - 1, y - 2, y >= 1 && y < m.paneHeight()+3
+	}
+	left := 0
+	for p := range paneCount {
+		if x < left+widths[p]+2 {
+			return p, x - left - 1, y - 1, y < m.paneHeight()+2
+		}
+		left += widths[p] + 2
+	}
+	return 0, 0, 0, false
+}
+
+// tabAt returns the pane named at column x of the line naming the panes.
+func tabAt(x int) (pane, bool) {
+	left := 0
+	for p, title := range paneTitles {
+		width := lipgloss.Width(title)
+		if x >= left && x < left+width {
+			return pane(p), true
+		}
+		// The separator.
+		left += width + 3
+	}
+	return 0, false
+}
+
+// updateBrowseMouse handles the mouse in the browse view. Clicking a pane
+// gives it the focus, and clicks on the tags, facets and books act as
+// Space and the cursor keys do. The wheel scrolls the pane under the
+// pointer.
+func (m *Model) updateBrowseMouse(msg tea.MouseMsg) {
+	if m.singleColumn() && msg.Y == 0 {
+		if p, ok := tabAt(msg.X); ok && leftClick(msg) {
+			m.focusPane(p)
+		}
+		return
+	}
+	p, x, y, ok := m.paneAt(msg.X, msg.Y)
+	if !ok {
+		return
+	}
+	width, height := m.paneWidths()[p], m.paneHeight()
+
+	if d := wheel(msg); d != 0 {
+		switch {
+		case p == previewPane:
+			m.scrollPreview(d * wheelLines)
+		case p == booksPane:
+			m.highlighted += d
+			m.scrollToCursor()
+		case m.facetFocus:
+			m.facetCursor = max(0, min(m.facetCursor+d, len(m.facetRows())-1))
+		case d < 0:
+			m.moveTagSelectorLeft()
+		default:
+			m.moveTagSelectorRight()
+		}
+		return
+	}
+
+	if !leftClick(msg) {
+		return
+	}
+	if p != m.focus {
+		m.focusPane(p)
+	}
+	if x < 0 || x >= width || y < 0 || y >= height {
+		return
+	}
+	switch p {
+	case sidebarPane:
+		lines, cursorLine := m.sidebarLines(width)
+		start, _ := scrollWindow(len(lines), cursorLine, height)
+		if start+y >= len(lines) {
+			return
+		}
+		switch line := lines[start+y]; {
+		case line.entry >= 0:
+			m.facetFocus = false
+			m.highlightedtagpos = line.entry
+			m.cycleTag()
+		case line.facet >= 0:
+			m.facetFocus = true
+			m.facetCursor = line.facet
+			m.toggleFacet()
+		}
+
+	case booksPane:
+		if m.singleColumn() {
+			lines, spans, cursorLine := m.tagBarLayout(width)
+			start, end := m.tagBarWindow(len(lines), cursorLine)
+			if y < end-start {
+				for i, span := range spans {
+					if span.line == start+y && x >= span.start && x < span.end {
+						m.highlightedtagpos = i
+						m.cycleTag()
+					}
+				}
+				return
+			}
+		}
+		row := y - lipgloss.Height(m.booksHeader(width))
+		if row >= 0 && m.min+row < len(m.books) {
+			m.highlighted = m.min + row
+			m.scrollToCursor()
+			// Ctrl marks the book clicked on, and Shift the books up to it.
+			switch {
+			case msg.Ctrl:
+				m.toggleMark()
+				m.highlighted = m.min + row
+				m.scrollToCursor()
+			case msg.Shift:
+				m.markRange()
+			}
+		}
+	}
+}
+
+// scrollWindow returns the range of n lines to show in height lines so that
+// the line at cursor is visible, keeping it near the middle.
+func scrollWindow(n, cursor, height int) (start, end int) {
+	start = max(0, min(cursor-height/2, n-height))
+	return start, min(n, start+height)
+}
+
+// pinMark starts the names of the pinned tags in the tag bar.
+const pinMark = "•"
+
+// tagBarEntries renders the collections and the tags of the tag bar, with
+// the number of results carrying each tag. The highlighted one starts with
+// the cursor.
+func (m Model) tagBarEntries() []string {
+	var entries []string
+	for i, col := range m.config.Collections {
+		var entry string
+		if m.highlightedtagpos == i {
+			entry = m.Styles.cursor.Render(m.cursor)
+		}
+		switch {
+		case col.Name == m.collection:
+			entry += m.Styles.selectedtag.Render("★" + col.Name)
+		case m.highlightedtagpos == i:
+			entry += m.Styles.highlightedtag.Render("★" + col.Name)
+		default:
+			entry += m.Styles.collection.Render("★" + col.Name)
+		}
+		entries = append(entries, entry)
+	}
+	for i, tagPtr := range m.tagnames {
+		i += len(m.config.Collections)
+		var entry string
+		if m.highlightedtagpos == i {
+			entry = m.Styles.cursor.Render(m.cursor)
+		}
+		name := tagPtr.Tag
+		if m.pinned(tagPtr.Tag) {
+			name = pinMark + name
+		}
+		switch {
+		case tagPtr.status == TagInclude:
+			entry += m.Styles.selectedtag.Render("+" + name)
+		case tagPtr.status == TagExclude:
+			entry += m.Styles.excludedtag.Render("-" + name)
+		case m.highlightedtagpos == i:
+			entry += m.Styles.highlightedtag.Render(name)
+		case m.dimmed(tagPtr):
+			entry += m.Styles.dimmedtag.Render(name)
+		case search.IsVirtual(tagPtr.Tag):
+			entry += m.Styles.virtualtag.Render(name)
+		default:
+			entry += m.Styles.tagnames.Render(name)
+		}
+		entry += m.Styles.greyed.Render(fmt.Sprintf(" (%d)", m.tagCounts[tagPtr.Tag]))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// tagBarSpan is where an entry of the wrapped tag bar is: its line and the
+// columns it takes.
+type tagBarSpan struct {
+	line, start, end int
+}
+
+// tagBarLayout wraps the tag bar to width. It returns the lines, where every
+// entry went, and the line of the highlighted one.
+func (m Model) tagBarLayout(width int) (lines []string, spans []tagBarSpan, cursorLine int) {
+	var line string
+	for i, entry := range m.tagBarEntries() {
+		entry = ansi.Truncate(entry, width, "…")
+		if line != "" && lipgloss.Width(line)+1+lipgloss.Width(entry) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		start := lipgloss.Width(line)
+		line += entry
+		spans = append(spans, tagBarSpan{line: len(lines), start: start, end: lipgloss.Width(line)})
+		if i == m.highlightedtagpos {
+			cursorLine = len(lines)
+		}
+	}
+	return append(lines, line), spans, cursorLine
+}
+
+// tagBarWindow returns the lines of the wrapped tag bar on screen. When it
+// takes more than a quarter of the screen, only the lines around the
+// highlighted entry are shown.
+func (m Model) tagBarWindow(n, cursorLine int) (start, end int) {
+	return scrollWindow(n, cursorLine, max(1, m.Height/4))
+}
+
+// tagBarView wraps the tag bar to width, for the book list of a single
+// column.
+func (m Model) tagBarView(width int) string {
+	lines, _, cursorLine := m.tagBarLayout(width)
+	start, end := m.tagBarWindow(len(lines), cursorLine)
+	return strings.Join(lines[start:end], "\n")
+}
+
+// sidebarLine is a line of the sidebar, with the entry of the tag bar or the
+// facet value it lists, -1 when it lists none.
+type sidebarLine struct {
+	text         string
+	entry, facet int
+}
+
+// sidebarLines lists the tag bar and the facets, one per line, and returns
+// the line of the cursor.
+func (m Model) sidebarLines(width int) (lines []sidebarLine, cursorLine int) {
+	lines = []sidebarLine{{m.Styles.greyed.Render(ansi.Truncate("Tags · "+string(m.tagOrder), width, "…")), -1, -1}}
+	indent := strings.Repeat(" ", lipgloss.Width(m.cursor))
+	for i, entry := range m.tagBarEntries() {
+		if i == m.highlightedtagpos {
+			cursorLine = len(lines)
+		} else {
+			entry = indent + entry
+		}
+		lines = append(lines, sidebarLine{ansi.Truncate(entry, width, "…"), i, -1})
+	}
+
+	facetLines, values, facetCursor := m.facetLines()
+	if len(facetLines) > 0 {
+		lines = append(lines, sidebarLine{"", -1, -1})
+		if m.facetFocus {
+			cursorLine = len(lines) + facetCursor
+		}
+		for i, line := range facetLines {
+			lines = append(lines, sidebarLine{ansi.Truncate(line, width, "…"), -1, values[i]})
+		}
+	}
+	return lines, cursorLine
+}
+
+// sidebarView renders the sidebar scrolled so that the cursor is visible.
+func (m Model) sidebarView(width, height int) string {
+	lines, cursorLine := m.sidebarLines(width)
+	start, end := scrollWindow(len(lines), cursorLine, height)
+	texts := make([]string, 0, end-start)
+	for _, line := range lines[start:end] {
+		texts = append(texts, line.text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// booksHeader renders what is above the books: the tag bar when the
+// sidebar isn't beside them, and the state of the results.
+func (m Model) booksHeader(width int) string {
+	var lines []string
+	if m.singleColumn() {
+		lines = append(lines, m.tagBarView(width))
+	}
+	if m.collection != "" {
+		lines = append(lines, m.Styles.greyed.Render(ansi.Truncate("collection: "+m.collection, width, "…")))
+	}
+	lines = append(lines, m.Styles.greyed.Render(ansi.Truncate("sort: "+m.sortOrder().String(), width, "…")))
+	if m.notice != "" {
+		lines = append(lines, m.Styles.greyed.Render(ansi.Truncate(m.notice, width, "…")))
+	}
+	if len(m.marked) > 0 {
+		lines = append(lines, m.Styles.greyed.Render(ansi.Truncate(fmt.Sprintf("marked: %d (e to tag them, esc to unmark)", len(m.marked)), width, "…")))
+	}
+	if node := m.tagQuery(); node != nil {
+		lines = append(lines, m.Styles.greyed.Render(ansi.Truncate("query: "+node.String(), width, "…")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// bookListView renders the rows of books from the top of the scrolled list.
+func (m Model) bookListView(rows, width int) string {
+	var lines []string
+	cursorWidth := lipgloss.Width(m.cursor)
+	for i := m.min; i < len(m.books) && i < m.min+rows; i++ {
+		title := m.books[i].Title
+		if m.marked[m.books[i].Path] {
+			title = m.Styles.selectedtag.Render(markMark) + title
+		}
+		title = ansi.Truncate(title, max(1, width-cursorWidth), "…")
+		if m.highlighted == i {
+			lines = append(lines, m.Styles.cursor.Render(m.cursor)+m.Styles.highlighted.Render(title))
+			continue
+		}
+		lines = append(lines, m.Styles.choices.Render(strings.Repeat(" ", cursorWidth)+title))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// paneContent renders the inside of a pane.
+func (m Model) paneContent(p pane, width, height int) string {
+	switch p {
+	case sidebarPane:
+		return m.sidebarView(width, height)
+	case previewPane:
+		return m.previewView(width, height)
+	}
+	header := m.booksHeader(width)
+	rows := max(1, height-lipgloss.Height(header))
+	return lipgloss.JoinVertical(lipgloss.Left, header, m.bookListView(rows, width))
+}
+
+// paneView renders a pane within its border, which stands out when the
+// pane has the focus.
+func (m Model) paneView(p pane, width, height int) string {
+	content := lipgloss.NewStyle().MaxWidth(width).MaxHeight(height).Render(m.paneContent(p, width, height))
+	style := m.Styles.pane
+	if p == m.focus {
+		style = m.Styles.focusedPane
+	}
+	return style.Width(width).Height(height).Render(content)
+}
+
+// paneTabs names the panes of a single column, the shown one standing out.
+func (m Model) paneTabs() string {
+	var tabs []string
+	for p, title := range paneTitles {
+		if pane(p) == m.focus {
+			tabs = append(tabs, m.Styles.highlighted.Render(title))
+		} else {
+			tabs = append(tabs, m.Styles.greyed.Render(title))
+		}
+	}
+	return strings.Join(tabs, m.Styles.greyed.Render(" │ "))
+}
+
+// browseView lays the sidebar, the books and the preview out side by side,
+// or shows the focused one alone on narrow terminals, with the help below.
+func (m Model) browseView() string {
+	widths := m.paneWidths()
+	height := m.paneHeight()
+
+	var body string
+	if m.singleColumn() {
+		body = lipgloss.JoinVertical(lipgloss.Left, m.paneTabs(), m.paneView(m.focus, widths[m.focus], height))
+	} else {
+		var panes []string
+		for p := range paneCount {
+			panes = append(panes, m.paneView(p, widths[p], height))
+		}
+		body = lipgloss.JoinHorizontal(lipgloss.Top, panes...)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, body, m.helpView())
+}
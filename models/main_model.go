@@ -1,19 +1,29 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"strings"
 
 	keymaps "Bonalioteko/Keymaps"
+	"Bonalioteko/config"
+	"Bonalioteko/cover"
+	"Bonalioteko/history"
+	"Bonalioteko/journal"
+	"Bonalioteko/metadata"
+	"Bonalioteko/search"
 	"Bonalioteko/xattr"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/davecgh/go-spew/spew"
+	"golang.org/x/text/language"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -22,6 +32,12 @@ const (
 	filterView modelState = iota
 	normalView
 	tagView
+	metadataView
+	collectionView
+	fullTextView
+	detailsView
+	galleryView
+	bulkTagView
 )
 
 type modelState int
@@ -30,25 +46,43 @@ type Model struct {
 	dump    io.Writer
 	err     error
 	rootdir string
+	config  config.Config
 
 	state modelState
 
-	filterModel list.Model
-	tagModel    tea.Model
+	filterModel   list.Model
+	tagModel      tea.Model
+	metadataModel tea.Model
+	// fullTextModel is kept between searches along with its index.
+	fullTextModel tea.Model
+	detailsModel  tea.Model
+	bulkTagModel  tea.Model
 
-	ebookPaths []string
+	// library holds every book below rootdir and books the ones left after
+	// the tag filter.
+	library []metadata.Book
+	books   []metadata.Book
 
-	choices        []string
-	initialChoices []string
-	cursor         string
-	highlighted    int
+	cursor      string
+	highlighted int
+
+	// marked holds the paths of the books marked for bulk tag editing, and
+	// markAnchor the position of the book last toggled.
+	marked     map[string]bool
+	markAnchor int
 
 	min int
 	max int
 
+	Width      int
 	Height     int
 	AutoHeight bool
 
+	// focus is the pane the keys go to, and previewOffset the line the
+	// preview is scrolled to.
+	focus         pane
+	previewOffset int
+
 	tags map[string][]string
 
 	highlightedtagpos int
@@ -57,6 +91,42 @@ type Model struct {
 	selectedTags   []*TagItem
 	selectedtagNum int
 
+	// joinOr joins the selected tags with or instead of and.
+	joinOr bool
+
+	// searches is the history of the filter, and regexMode makes the filter
+	// match regular expressions.
+	searches  *history.History
+	regexMode bool
+
+	// journal records the changes to the tags for them to be undone, and
+	// notice tells what the last undo or redo did until a key is pressed.
+	journal *journal.Journal
+	notice  string
+
+	// sort orders the library, collated for locale.
+	sort   metadata.SortOrder
+	locale language.Tag
+
+	// tagOrder orders the tag bar, and tagCounts counts the results
+	// carrying each tag.
+	tagOrder  search.TagOrder
+	tagCounts map[string]int
+
+	// collection is the name of the open saved collection, if any.
+	collection string
+	// collectionInput reads the name of the collection being saved, and
+	// collectionSearch holds the search it is saved with.
+	collectionInput  textinput.Model
+	collectionSearch string
+
+	// facets holds the values selected in the facet panel, and unfaceted
+	// the results before the facets narrow them down.
+	facets      search.Selection
+	unfaceted   []metadata.Book
+	facetFocus  bool
+	facetCursor int
+
 	mintag int
 	maxtag int
 
@@ -64,6 +134,21 @@ type Model struct {
 
 	pathTags map[string][]string
 
+	// covers holds the thumbnails of the books last shown, read from
+	// coverCache and drawn with coverProtocol in cells of coverCell pixels.
+	covers        *coverSet
+	coverCache    cover.Cache
+	coverProtocol cover.Protocol
+	coverCell     cover.Cell
+
+	// galleryBooks are the books of the cover gallery, galleryCursor the
+	// highlighted one and galleryTop the first row on screen. galleryReturn
+	// is the view the gallery was opened from.
+	galleryBooks  []metadata.Book
+	galleryCursor int
+	galleryTop    int
+	galleryReturn modelState
+
 	KeyMap keymaps.KeyMap
 	Help   help.Model
 }
@@ -76,7 +161,17 @@ type Styles struct {
 	tagnames       lipgloss.Style
 	highlightedtag lipgloss.Style
 	selectedtag    lipgloss.Style
+	excludedtag    lipgloss.Style
+	virtualtag     lipgloss.Style
+	dimmedtag      lipgloss.Style
+	collection     lipgloss.Style
 	HelpStyle      lipgloss.Style
+	errorText      lipgloss.Style
+	greyed         lipgloss.Style
+
+	pane        lipgloss.Style
+	focusedPane lipgloss.Style
+	button      lipgloss.Style
 }
 
 type delegateStyles struct {
@@ -84,6 +179,9 @@ type delegateStyles struct {
 	greyed    lipgloss.Style
 	item      lipgloss.Style
 	selected  lipgloss.Style
+	excluded  lipgloss.Style
+	virtual   lipgloss.Style
+	match     lipgloss.Style
 	tag       lipgloss.Style
 	HelpStyle lipgloss.Style
 }
@@ -94,42 +192,63 @@ func NewStyles() delegateStyles {
 	s.cursor = lipgloss.NewStyle().Foreground(lipgloss.Color("202"))
 	s.item = lipgloss.NewStyle().Foreground(lipgloss.Color("02"))
 	s.selected = lipgloss.NewStyle().Foreground(lipgloss.Color("201"))
+	s.excluded = lipgloss.NewStyle().Strikethrough(true).Foreground(lipgloss.Color("1"))
+	s.virtual = lipgloss.NewStyle().Italic(true).Foreground(lipgloss.Color("6"))
+	s.match = lipgloss.NewStyle().Bold(true).Underline(true).Foreground(lipgloss.Color("212"))
 	s.tag = lipgloss.NewStyle().Foreground(lipgloss.Color("5"))
 	s.HelpStyle = lipgloss.NewStyle().Padding(1, 0, 0, 2)
 	return s
 }
 
-func initItems(choices []string, tags []string) []ResultItem {
-	var items []ResultItem
-	for _, title := range choices {
-		items = append(items, &TitleItem{Book: title})
-	}
-	for _, tag := range tags {
-		items = append(items, &TagItem{Tag: tag})
-	}
-	return items
-}
+func InitialModel(dump *os.File, cfg config.Config) Model {
+	loadViewState(&cfg.Settings)
+	rootdir := cfg.Settings.EbookDir
+	library := metadata.Scan(rootdir)
+	tagsMap := loadTags(rootdir, library)
 
-func InitialModel(dump *os.File, rootdir string) Model {
-	tagsMap := xattr.GetXattrMapTagToFilePath(rootdir)
+	order, err := metadata.ParseSortOrder(cfg.Settings.Sort)
+	if err != nil {
+		log.Printf("Warning: sort setting: %v", err)
+	}
+	locale := metadata.Locale(cfg.Settings.Locale)
+	metadata.SortBooks(library, order, locale)
 
-	tagStrings := xattr.GetUniqueTags(tagsMap)
-	choicesinit := GetEpubTitles(rootdir)
+	tagOrder, err := search.ParseTagOrder(cfg.Settings.TagOrder)
+	if err != nil {
+		log.Printf("Warning: tag order setting: %v", err)
+	}
 
-	listItems, sharedTags := GetFilterListItems(tagStrings, choicesinit)
+	protocol, err := cover.ParseProtocol(cfg.Settings.Covers, os.Getenv)
+	if err != nil {
+		log.Printf("Warning: covers setting: %v", err)
+	}
+	coverCache, err := cover.DefaultCache()
+	if err != nil {
+		log.Printf("Warning: cover cache: %v", err)
+		protocol = cover.Off
+	}
 
-	return Model{
+	m := Model{
 		dump:        dump,
 		state:       normalView,
 		rootdir:     rootdir,
-		filterModel: list.New(listItems, Bonadelegate{styles: NewStyles()}, 80, 40),
-
-		ebookPaths:     find(rootdir, ".epub"),
-		choices:        choicesinit,
-		initialChoices: choicesinit,
-		cursor:         ">",
-		Height:         0,
-		highlighted:    0,
+		config:      cfg,
+		filterModel: list.New(nil, Bonadelegate{styles: NewStyles()}, defaultWidth, defaultHeight),
+
+		library:     library,
+		books:       library,
+		sort:        order,
+		locale:      locale,
+		tagOrder:    tagOrder,
+		searches:    loadSearchHistory(),
+		journal:     loadJournal(),
+		unfaceted:   library,
+		facets:      search.Selection{},
+		cursor:      ">",
+		focus:       booksPane,
+		Width:       defaultWidth,
+		Height:      defaultHeight,
+		highlighted: 0,
 
 		Styles: DefaultStyles(),
 		min:    0,
@@ -137,8 +256,6 @@ func InitialModel(dump *os.File, rootdir string) Model {
 
 		tags: tagsMap,
 
-		tagnames: sharedTags,
-
 		highlightedtagpos: 0,
 		mintag:            0,
 		maxtag:            0,
@@ -148,7 +265,16 @@ func InitialModel(dump *os.File, rootdir string) Model {
 		pathTags: xattr.GetXattrMapFilePathToTag(rootdir),
 		KeyMap:   keymaps.DefaultKeyMap(),
 		Help:     help.New(),
+
+		covers:        newCoverSet(),
+		coverCache:    coverCache,
+		coverProtocol: protocol,
+		coverCell:     cover.TerminalCell(),
 	}
+	m.applyTagFilter()
+	m.setTagItems()
+	m.setListItems(m.listItems())
+	return m
 }
 
 func DefaultStyles() Styles {
@@ -163,12 +289,22 @@ func DefaultStylesWithRenderer(r *lipgloss.Renderer) Styles {
 
 		tagnames:       r.NewStyle().Foreground(lipgloss.Color("5")),
 		selectedtag:    r.NewStyle().Italic(true).Foreground(lipgloss.Color("2")),
+		excludedtag:    r.NewStyle().Strikethrough(true).Foreground(lipgloss.Color("1")),
+		virtualtag:     r.NewStyle().Italic(true).Foreground(lipgloss.Color("6")),
+		dimmedtag:      r.NewStyle().Faint(true).Foreground(lipgloss.Color("238")),
+		collection:     r.NewStyle().Foreground(lipgloss.Color("3")),
 		highlightedtag: r.NewStyle().Foreground(lipgloss.Color("12")),
+		errorText:      r.NewStyle().Foreground(lipgloss.Color("9")),
+		greyed:         r.NewStyle().Foreground(lipgloss.Color("241")),
+
+		pane:        r.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("241")),
+		focusedPane: r.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.Color("212")),
+		button:      r.NewStyle().Foreground(lipgloss.Color("212")),
 	}
 }
 
 func (m Model) Init() tea.Cmd {
-	return nil
+	return m.loadCover()
 }
 
 type SpecialString string
@@ -188,86 +324,168 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 
 	case tea.WindowSizeMsg:
-		m.Height = 100
-		m.max = m.Height - 1
-		m.filterModel.SetSize(30, 30)
+		m.Width, m.Height = msg.Width, msg.Height
+		m.Help.Width = msg.Width
+		m.filterModel.SetSize(msg.Width, msg.Height)
+		if cell := cover.TerminalCell(); cell != m.coverCell {
+			// Sixel covers are drawn in pixels.
+			m.coverCell = cell
+			m.forgetCoverRenderings()
+		}
+		m.scrollToCursor()
+		m.scrollGallery()
+		for _, child := range []*tea.Model{&m.tagModel, &m.metadataModel, &m.fullTextModel, &m.detailsModel, &m.bulkTagModel} {
+			if *child != nil {
+				*child, cmd = (*child).Update(msg)
+				cmds = append(cmds, cmd)
+			}
+		}
 
 	case TagFilterMsg:
 		m.selectedTags = nil
 		for _, tag := range m.tagnames {
-			if tag.status {
+			if tag.status != TagNeutral {
 				m.selectedTags = append(m.selectedTags, tag)
 			}
 		}
-		if len(m.selectedTags) == 0 {
-			m.choices = m.initialChoices
-			m.ebookPaths = find(m.rootdir, ".epub")
-		} else {
-			tagStrings := GetTagStrings(m.selectedTags)
-			m.ebookPaths = xattr.MultipleTagsFilter(tagStrings, m.tags)
-			m.choices = getTitlesFromPaths(m.ebookPaths)
-		}
+		m.applyTagFilter()
+		m.reorderTags()
 
 	case ExitTagViewMsg:
 		m.state = normalView
 
-	case TagsUpdatedMsg:
-		m.pathTags[msg.filename] = msg.NewTags
+	case ExitMetadataViewMsg:
+		m.state = normalView
 
-		allTagsMap := xattr.GetXattrMapTagToFilePath(m.rootdir)
-		m.tags = allTagsMap
-		uniqueTags := xattr.GetUniqueTags(allTagsMap)
+	case metadataSaveErrMsg:
+		m.metadataModel, cmd = m.metadataModel.Update(msg)
+		return m, cmd
 
-		var newTagItems []*TagItem
-		for _, tagName := range uniqueTags {
-			status := false
+	case ExitFullTextViewMsg:
+		m.state = normalView
 
-			for _, oldTag := range m.tagnames {
-				if oldTag.Tag == tagName {
-					status = oldTag.status
-					break
-				}
-			}
+	case ExitDetailsViewMsg:
+		m.state = normalView
 
-			newTagItems = append(newTagItems, &TagItem{
-				Tag:    tagName,
-				status: status,
-			})
-		}
+	case ExitBulkTagViewMsg:
+		m.state = normalView
 
-		m.tagnames = newTagItems
-		if m.highlightedtagpos >= len(m.tagnames) {
-			m.highlightedtagpos = max(0, len(m.tagnames)-1)
+	case coverLoadedMsg:
+		m.setCover(msg)
+
+	case fullTextResultsMsg:
+		m.fullTextModel, cmd = m.fullTextModel.Update(msg)
+		return m, cmd
+
+	case MetadataUpdatedMsg:
+		m.tags = loadTags(m.rootdir, m.library)
+		m.replaceBook(msg.Book)
+		m.state = normalView
+
+	case TagsUpdatedMsg:
+		m.pathTags[msg.filename] = msg.NewTags
+		m.record(msg.entry)
+		return m, m.reloadTags()
+
+	case BulkTagsUpdatedMsg:
+		for path, tags := range msg.Tags {
+			m.pathTags[path] = tags
 		}
+		m.record(msg.entry)
+		return m, m.reloadTags()
 
-		m.choices = getTitlesFromPaths(m.ebookPaths)
-		m.selectedTags = nil
-		updatedFilterItems, updatedSharedTags := GetFilterListItems(xattr.GetUniqueTags(m.tags), m.choices)
-		m.filterModel.SetItems(updatedFilterItems)
-		m.tagnames = updatedSharedTags
-		return m, func() tea.Msg { return TagFilterMsg{} }
+	case undoMsg:
+		return m, m.undo(msg.redo)
+
+	case tea.MouseMsg:
+		return m.updateMouse(msg)
 
 	case tea.KeyMsg:
 		if m.err != nil {
 			m.err = nil
 			return m, nil
 		}
+		m.notice = ""
 		switch state := m.state; state {
 		case filterView:
+			if m.filterModel.FilterState() == list.FilterApplied && key.Matches(msg, m.KeyMap.Gallery) {
+				m.openGallery(m.filteredBooks())
+				break
+			}
+			if m.filterModel.FilterState() == list.FilterApplied && key.Matches(msg, m.KeyMap.SaveCollection) {
+				if m.regexMode {
+					m.err = errors.New("regular expressions can't be saved in collections")
+					return m, nil
+				}
+				return m, m.startSaveCollection(m.filterModel.FilterValue())
+			}
+			filtering := m.filterModel.FilterState() == list.Filtering
+			if filtering {
+				switch {
+				case key.Matches(msg, m.KeyMap.HistoryPrev):
+					m.browseHistory(true)
+					return m, nil
+				case key.Matches(msg, m.KeyMap.HistoryNext):
+					m.browseHistory(false)
+					return m, nil
+				case key.Matches(msg, m.KeyMap.ToggleRegex):
+					m.toggleRegexMode()
+					return m, nil
+				}
+			}
 			m.filterModel, cmd = m.filterModel.Update(msg)
 			cmds = append(cmds, cmd)
 
-			if m.filterModel.FilterState() == list.Unfiltered {
+			switch m.filterModel.FilterState() {
+			case list.Unfiltered:
 				m.state = normalView
+			case list.FilterApplied:
+				if filtering {
+					m.addSearch(m.filterModel.FilterValue())
+				}
 			}
 
 			return m, tea.Batch(cmds...)
 
 		case tagView:
 			m.tagModel, cmd = m.tagModel.Update(msg)
-			cmds = append(cmds, cmd)
+
+		case metadataView:
+			m.metadataModel, cmd = m.metadataModel.Update(msg)
+
+		case fullTextView:
+			m.fullTextModel, cmd = m.fullTextModel.Update(msg)
+
+		case detailsView:
+			m.detailsModel, cmd = m.detailsModel.Update(msg)
+
+		case bulkTagView:
+			m.bulkTagModel, cmd = m.bulkTagModel.Update(msg)
+
+		case galleryView:
+			cmd = m.updateGallery(msg)
+
+		case collectionView:
+			switch {
+			case key.Matches(msg, m.KeyMap.CancelWhileFiltering):
+				m.state = normalView
+			case key.Matches(msg, m.KeyMap.Enter):
+				name := strings.TrimSpace(m.collectionInput.Value())
+				if name == "" {
+					break
+				}
+				if err := m.saveCollection(m.newCollection(name, m.collectionSearch)); err != nil {
+					m.err = err
+				}
+				m.state = normalView
+			default:
+				m.collectionInput, cmd = m.collectionInput.Update(msg)
+			}
 
 		default:
+			if m.updatePanes(msg) {
+				break
+			}
 			switch {
 
 			case key.Matches(msg, m.KeyMap.CursorUp):
@@ -276,6 +494,22 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case key.Matches(msg, m.KeyMap.CursorDown):
 				m.moveCursorDown()
 
+			case key.Matches(msg, m.KeyMap.PageUp):
+				m.highlighted -= m.bookRows()
+				m.scrollToCursor()
+
+			case key.Matches(msg, m.KeyMap.PageDown):
+				m.highlighted += m.bookRows()
+				m.scrollToCursor()
+
+			case key.Matches(msg, m.KeyMap.Home):
+				m.highlighted = 0
+				m.scrollToCursor()
+
+			case key.Matches(msg, m.KeyMap.End):
+				m.highlighted = len(m.books) - 1
+				m.scrollToCursor()
+
 			case key.Matches(msg, m.KeyMap.CursorRight):
 				m.moveTagSelectorRight()
 
@@ -283,28 +517,105 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.moveTagSelectorLeft()
 
 			case key.Matches(msg, m.KeyMap.SpaceBar):
-				m.selectOrDeselectTag()
+				m.cycleTag()
+
+			case key.Matches(msg, m.KeyMap.ToggleJoin):
+				m.joinOr = !m.joinOr
+				m.refreshResults()
+
+			case key.Matches(msg, m.KeyMap.FullText):
+				if m.fullTextModel == nil {
+					m.fullTextModel = m.sized(NewFullTextModel(m.library))
+				}
+				m.state = fullTextView
+				cmd = m.fullTextModel.Init()
+
+			case key.Matches(msg, m.KeyMap.SaveCollection):
+				cmd = m.startSaveCollection("")
+
+			case key.Matches(msg, m.KeyMap.Gallery):
+				m.openGallery(m.books, m.highlighted)
+
+			case key.Matches(msg, m.KeyMap.Sort):
+				m.setSort(m.sort.NextKey())
+
+			case key.Matches(msg, m.KeyMap.ReverseSort):
+				m.setSort(metadata.SortOrder{Key: m.sort.Key, Desc: !m.sort.Desc})
+
+			case key.Matches(msg, m.KeyMap.TagOrder):
+				m.setTagBar(m.tagOrder.Next(), m.config.Settings.PinnedTags, m.config.Settings.ManualTags)
+
+			case key.Matches(msg, m.KeyMap.PinTag):
+				m.togglePin()
+
+			case key.Matches(msg, m.KeyMap.MoveTagUp):
+				m.moveTag(-1)
+
+			case key.Matches(msg, m.KeyMap.MoveTagDown):
+				m.moveTag(1)
 
 			case key.Matches(msg, m.KeyMap.Filter):
 				m.state = filterView
+				m.searches.Reset()
 				m.filterModel, cmd = m.filterModel.Update(msg)
 
+			case key.Matches(msg, m.KeyMap.Undo):
+				cmd = m.undo(false)
+
+			case key.Matches(msg, m.KeyMap.Redo):
+				cmd = m.undo(true)
+
+			case key.Matches(msg, m.KeyMap.ToggleMark):
+				m.toggleMark()
+
+			case key.Matches(msg, m.KeyMap.MarkRange):
+				m.markRange()
+
+			case key.Matches(msg, m.KeyMap.MarkAll):
+				m.toggleMarkAll()
+
+			case key.Matches(msg, m.KeyMap.Edit) && len(m.marked) > 0:
+				m.bulkTagModel = m.sized(NewBulkTagModel(m.markedBooks(), m.pathTags, m.config.Settings.EmbedTags))
+				m.state = bulkTagView
+
 			case key.Matches(msg, m.KeyMap.Edit):
-				if len(m.ebookPaths) == 0 || m.highlighted < 0 || m.highlighted >= len(m.ebookPaths) {
+				book, ok := m.highlightedBook()
+				if !ok {
 					break
 				}
-				m.tagModel = NewTagEditModel(m.ebookPaths[m.highlighted], m.pathTags[m.ebookPaths[m.highlighted]])
+				m.tagModel = m.sized(NewTagEditModel(book, m.pathTags[book.Path], m.libraryTagCounts(), m.config.Settings.EmbedTags))
 
 				m.state = tagView
 
+			case key.Matches(msg, m.KeyMap.Details):
+				book, ok := m.highlightedBook()
+				if !ok {
+					break
+				}
+				m.detailsModel = m.sized(NewDetailsModel(book, m.pathTags[book.Path], m.config.Settings.EmbedTags))
+				m.state = detailsView
+
+			case key.Matches(msg, m.KeyMap.EditMetadata):
+				book, ok := m.highlightedBook()
+				if !ok {
+					break
+				}
+				m.metadataModel = m.sized(NewMetadataEditModel(book))
+				m.state = metadataView
+				cmd = m.metadataModel.Init()
+
 			case key.Matches(msg, m.KeyMap.Enter):
-				if len(m.ebookPaths) == 0 || m.highlighted < 0 || m.highlighted >= len(m.ebookPaths){
+				book, ok := m.highlightedBook()
+				if !ok {
 					break
 				}
-				err := OpenFile(m.ebookPaths[m.highlighted])
+				err := OpenFile(book.Path)
 				if err != nil {
 					m.err = err
 				}
+			case key.Matches(msg, m.KeyMap.Quit) && len(m.marked) > 0:
+				m.marked = nil
+
 			case key.Matches(msg, m.KeyMap.Quit):
 				return m, tea.Quit
 			}
@@ -312,64 +623,110 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	default:
 		m.filterModel, cmd = m.filterModel.Update(msg)
-		cmds = append(cmds, cmd)
 
 	}
-	cmds = append(cmds, cmd)
+	cmds = append(cmds, cmd, m.loadCover())
 	return m, tea.Batch(cmds...)
 }
 
 // View model
 func (m Model) View() string {
+	var clear string
+	if !m.previewShown() {
+		clear = cover.Clear(m.coverProtocol, previewCoverID)
+	}
+	if m.state != galleryView {
+		clear += m.clearGallery()
+	}
+	return clear + m.view()
+}
+
+func (m Model) view() string {
 	if m.err != nil {
 		return fmt.Sprintf("error: %v\n\nPress any key to continue", m.err)
 	}
 	switch m.state {
 
 	case filterView:
-		return m.filterModel.View()
+		return m.filterView()
 
 	case tagView:
 		return m.tagModel.View()
 
-	default:
-		var s strings.Builder
-
-		for i, tagPtr := range m.tagnames {
-			if m.highlightedtagpos == i && !tagPtr.status {
-				s.WriteString(m.Styles.cursor.Render(m.cursor) + m.Styles.highlightedtag.Render(tagPtr.Tag) + " ")
-			} else if tagPtr.status && m.highlightedtagpos != i {
-				s.WriteString(m.Styles.selectedtag.Render(tagPtr.Tag) + " ")
-			} else if m.highlightedtagpos == i && tagPtr.status {
-				s.WriteString(m.Styles.cursor.Render(m.cursor) + m.Styles.selectedtag.Render(tagPtr.Tag) + " ")
-			} else {
-				s.WriteString(m.Styles.tagnames.Render(tagPtr.Tag) + " ")
-			}
-		}
-		s.WriteString("\n")
+	case metadataView:
+		return m.metadataModel.View()
 
-		for i, items := range m.choices {
-			if i < m.min || i > m.max {
-				continue
-			}
+	case collectionView:
+		return m.collectionView()
 
-			if m.highlighted == i {
-				highlighted := fmt.Sprint(m.Styles.highlighted.Render(items))
-				s.WriteString(m.Styles.cursor.Render(m.cursor) + m.Styles.highlighted.Render(highlighted))
-				s.WriteRune('\n')
-				continue
-			}
+	case fullTextView:
+		return m.fullTextModel.View()
 
-			s.WriteString(m.Styles.choices.Render(items))
-			s.WriteRune('\n')
+	case detailsView:
+		return m.detailsModel.View()
 
-		}
-		return lipgloss.Place(50, 50, lipgloss.Center, lipgloss.Center, lipgloss.JoinVertical(lipgloss.Top, s.String(), m.helpView()))
+	case bulkTagView:
+		return m.bulkTagModel.View()
+
+	case galleryView:
+		return m.galleryView()
+
+	default:
+		return m.browseView()
 	}
 }
 
+// sized gives a view the size of the terminal.
+func (m Model) sized(view tea.Model) tea.Model {
+	view, _ = view.Update(tea.WindowSizeMsg{Width: m.Width, Height: m.Height})
+	return view
+}
+
+// filterView shows the filter list. While the text is being typed, the
+// line above it tells what is wrong with the text, or the keys of the
+// filter.
+func (m Model) filterView() string {
+	if m.filterModel.FilterState() != list.Filtering {
+		return m.filterModel.View()
+	}
+	line := m.Help.ShortHelpView([]key.Binding{m.KeyMap.HistoryPrev, m.KeyMap.HistoryNext, m.KeyMap.ToggleRegex})
+	if err := filterError(m.filterModel.FilterValue(), m.regexMode); err != nil {
+		line = m.Styles.errorText.Render("✗ " + err.Error())
+	}
+	l := m.filterModel
+	l.SetHeight(l.Height() - 1)
+	return lipgloss.JoinVertical(lipgloss.Left, line, l.View())
+}
+
+// startSaveCollection asks for the name of a collection saving the current
+// results along with the search.
+func (m *Model) startSaveCollection(search string) tea.Cmd {
+	m.collectionSearch = search
+	m.collectionInput = textinput.New()
+	m.collectionInput.Prompt = "save collection as: "
+	m.collectionInput.SetValue(m.collection)
+	m.state = collectionView
+	return m.collectionInput.Focus()
+}
+
+// collectionView shows the name prompt along with what gets saved.
+func (m Model) collectionView() string {
+	return m.collectionHeader() + "\n\n" + buttonsView(m.Styles, m.collectionButtons())
+}
+
+// collectionHeader renders the name prompt and what gets saved.
+func (m Model) collectionHeader() string {
+	col := m.newCollection(m.collectionInput.Value(), m.collectionSearch)
+	return m.collectionInput.View() + "\n\n" + m.Styles.greyed.Render(fmt.Sprintf("query:  %s\nsearch: %s", col.Query, col.Search))
+}
+
+// collectionButtons are the buttons under the name prompt.
+func (m Model) collectionButtons() []button {
+	return []button{{"Save (enter)", enterKey}, {"Cancel (esc)", escKey}}
+}
+
 func (m Model) helpView() string {
-	return m.Styles.HelpStyle.Render(m.Help.View(m))
+	return m.Styles.HelpStyle.MaxWidth(m.Width).Render(m.Help.View(m))
 }
 
 func (m Model) FullHelp() [][]key.Binding {
@@ -378,8 +735,32 @@ func (m Model) FullHelp() [][]key.Binding {
 		m.KeyMap.CursorRight,
 		m.KeyMap.CursorUp,
 		m.KeyMap.CursorDown,
+		m.KeyMap.PageUp,
+		m.KeyMap.PageDown,
+		m.KeyMap.Home,
+		m.KeyMap.End,
 		m.KeyMap.SpaceBar,
+		m.KeyMap.ToggleJoin,
+		m.KeyMap.SaveCollection,
+		m.KeyMap.NextPane,
+		m.KeyMap.PrevPane,
+		m.KeyMap.FocusFacets,
+		m.KeyMap.Gallery,
+		m.KeyMap.FullText,
+		m.KeyMap.Sort,
+		m.KeyMap.ReverseSort,
+		m.KeyMap.TagOrder,
+		m.KeyMap.PinTag,
+		m.KeyMap.MoveTagUp,
+		m.KeyMap.MoveTagDown,
+		m.KeyMap.Undo,
+		m.KeyMap.Redo,
+		m.KeyMap.ToggleMark,
+		m.KeyMap.MarkRange,
+		m.KeyMap.MarkAll,
+		m.KeyMap.Details,
 		m.KeyMap.Edit,
+		m.KeyMap.EditMetadata,
 	}}
 
 	return append(kb,
@@ -397,6 +778,7 @@ func (m Model) ShortHelp() []key.Binding {
 		m.KeyMap.CursorRight,
 		m.KeyMap.CursorUp,
 		m.KeyMap.CursorDown,
+		m.KeyMap.NextPane,
 		m.KeyMap.Filter,
 	}
 
@@ -0,0 +1,71 @@
+package models
+
+import "Bonalioteko/metadata"
+
+// markMark starts the titles of the marked books in the book list.
+const markMark = "✓ "
+
+// toggleMark marks the highlighted book, or unmarks it, and moves the
+// cursor to the next one. Ranges start from it.
+func (m *Model) toggleMark() {
+	book, ok := m.highlightedBook()
+	if !ok {
+		return
+	}
+	if m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	if m.marked[book.Path] {
+		delete(m.marked, book.Path)
+	} else {
+		m.marked[book.Path] = true
+	}
+	m.markAnchor = m.highlighted
+	m.moveCursorDown()
+}
+
+// markRange marks the books from the last one toggled to the highlighted
+// one.
+func (m *Model) markRange() {
+	if len(m.books) == 0 {
+		return
+	}
+	if m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	anchor := max(0, min(m.markAnchor, len(m.books)-1))
+	for i := min(anchor, m.highlighted); i <= max(anchor, m.highlighted); i++ {
+		m.marked[m.books[i].Path] = true
+	}
+}
+
+// toggleMarkAll marks every result, or unmarks them when they are all
+// marked already.
+func (m *Model) toggleMarkAll() {
+	all := true
+	for _, book := range m.books {
+		all = all && m.marked[book.Path]
+	}
+	if m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	for _, book := range m.books {
+		if all {
+			delete(m.marked, book.Path)
+		} else {
+			m.marked[book.Path] = true
+		}
+	}
+}
+
+// markedBooks returns the marked books in the library order. Marks stay
+// on books the results leave out.
+func (m Model) markedBooks() []metadata.Book {
+	var books []metadata.Book
+	for _, book := range m.library {
+		if m.marked[book.Path] {
+			books = append(books, book)
+		}
+	}
+	return books
+}
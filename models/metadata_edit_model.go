@@ -0,0 +1,368 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	keymaps "Bonalioteko/Keymaps"
+	"Bonalioteko/metadata"
+
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// Indexes of the fields of the metadata editor.
+const (
+	titleField = iota
+	authorsField
+	seriesField
+	seriesIndexField
+	languageField
+	descriptionField
+	fieldCount
+)
+
+// overridableFields is the number of leading fields that can be stored as
+// overrides.
+const overridableFields = languageField
+
+var fieldLabels = [fieldCount]string{"Title", "Authors", "Series", "Series index", "Language", "Description"}
+
+// labelWidth is the width of the cursor and the label before each field.
+const labelWidth = 15
+
+// MetadataEditModel edits the metadata of one EPUB, either in the OPF
+// inside the file or as overrides stored in extended attributes.
+type MetadataEditModel struct {
+	book metadata.Book
+	// file holds the values read from the file, without overrides.
+	file metadata.Edit
+
+	// override saves the title, authors and series as overrides instead of
+	// writing them into the file.
+	override bool
+
+	inputs [fieldCount]textinput.Model
+	focus  int
+
+	Width  int
+	Height int
+
+	Styles Styles
+	Help   help.Model
+	KeyMap keymaps.KeyMap
+
+	err error
+}
+
+type (
+	ExitMetadataViewMsg struct{}
+	MetadataUpdatedMsg  struct {
+		Book metadata.Book
+	}
+)
+
+func NewMetadataEditModel(book metadata.Book) MetadataEditModel {
+	m := MetadataEditModel{
+		book:     book,
+		file:     metadata.EditFromBook(book.FileValues()),
+		override: !book.Overrides.IsZero(),
+		Width:    defaultWidth,
+		Height:   defaultHeight,
+		Styles:   DefaultStyles(),
+		Help:     help.New(),
+		KeyMap:   keymaps.DefaultKeyMap(),
+	}
+
+	for i := range m.inputs {
+		ti := textinput.New()
+		ti.Prompt = ""
+		ti.CharLimit = 0
+		ti.Width = 50
+		m.inputs[i] = ti
+	}
+	m.inputs[authorsField].Placeholder = "Author One; Author Two"
+	m.inputs[languageField].Placeholder = "en"
+	m.resetInputs()
+	m.inputs[titleField].Focus()
+
+	return m
+}
+
+// resetInputs fills the form with the values of the current mode.
+func (m *MetadataEditModel) resetInputs() {
+	values := m.file
+	if m.override {
+		values = metadata.EditFromBook(m.book)
+	}
+	m.inputs[titleField].SetValue(values.Title)
+	m.inputs[authorsField].SetValue(strings.Join(values.Authors, "; "))
+	m.inputs[seriesField].SetValue(values.Series)
+	m.inputs[seriesIndexField].SetValue(values.SeriesIndex)
+	m.inputs[languageField].SetValue(values.Language)
+	m.inputs[descriptionField].SetValue(values.Description)
+}
+
+func (m MetadataEditModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+// edit returns the values currently entered in the form.
+func (m MetadataEditModel) edit() metadata.Edit {
+	return metadata.Edit{
+		Title:       strings.TrimSpace(m.inputs[titleField].Value()),
+		Authors:     metadata.SplitAuthors(m.inputs[authorsField].Value()),
+		Series:      strings.TrimSpace(m.inputs[seriesField].Value()),
+		SeriesIndex: strings.TrimSpace(m.inputs[seriesIndexField].Value()),
+		Language:    strings.TrimSpace(m.inputs[languageField].Value()),
+		Description: strings.TrimSpace(m.inputs[descriptionField].Value()),
+	}
+}
+
+// overrides returns the form as overrides. Fields matching the file are
+// left empty so they don't mask later changes to the file.
+func (m MetadataEditModel) overrides() metadata.Overrides {
+	e := m.edit()
+	var o metadata.Overrides
+	if e.Title != m.file.Title {
+		o.Title = e.Title
+	}
+	if strings.Join(e.Authors, "; ") != strings.Join(m.file.Authors, "; ") {
+		o.Authors = e.Authors
+	}
+	if e.Series != m.file.Series {
+		o.Series = e.Series
+	}
+	if e.SeriesIndex != m.file.SeriesIndex {
+		o.SeriesIndex = e.SeriesIndex
+	}
+	return o
+}
+
+// editableFields returns the number of fields editable in the current mode.
+func (m MetadataEditModel) editableFields() int {
+	if m.override {
+		return overridableFields
+	}
+	return fieldCount
+}
+
+func (m *MetadataEditModel) focusField(i int) tea.Cmd {
+	n := m.editableFields()
+	m.inputs[m.focus].Blur()
+	m.focus = (i + n) % n
+	return m.inputs[m.focus].Focus()
+}
+
+// validate checks the form for the current mode.
+func (m MetadataEditModel) validate() error {
+	if !m.override {
+		return m.edit().Validate()
+	}
+	o := m.overrides()
+	if o.SeriesIndex == "" {
+		return nil
+	}
+	if _, err := strconv.ParseFloat(o.SeriesIndex, 64); err != nil {
+		return fmt.Errorf("series index %q is not a number", o.SeriesIndex)
+	}
+	return nil
+}
+
+// save writes the form into the EPUB, or into the overrides, and reloads
+// the book.
+func (m MetadataEditModel) save() tea.Cmd {
+	path := m.book.Path
+	before, after := m.file, m.edit()
+	overrides, override := m.overrides(), m.override
+	return func() tea.Msg {
+		var err error
+		if override {
+			err = metadata.WriteOverrides(path, overrides)
+		} else {
+			err = metadata.WriteEdit(path, before, after)
+		}
+		if err != nil {
+			return metadataSaveErrMsg{err}
+		}
+		book, err := metadata.FromFile(path)
+		if err != nil {
+			return metadataSaveErrMsg{err}
+		}
+		return MetadataUpdatedMsg{Book: book}
+	}
+}
+
+type metadataSaveErrMsg struct{ err error }
+
+func (m MetadataEditModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	switch msg := msg.(type) {
+	case metadataSaveErrMsg:
+		m.err = msg.err
+		return m, nil
+
+	case tea.WindowSizeMsg:
+		m.Width, m.Height = msg.Width, msg.Height
+		m.Help.Width = msg.Width
+		for i := range m.inputs {
+			m.inputs[i].Width = max(10, msg.Width-labelWidth-1)
+		}
+		return m, nil
+
+	case tea.MouseMsg:
+		if leftClick(msg) {
+			return m.click(msg.X, msg.Y)
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, m.KeyMap.Save):
+			if err := m.validate(); err != nil {
+				m.err = err
+				return m, nil
+			}
+			m.err = nil
+			return m, m.save()
+
+		case key.Matches(msg, m.KeyMap.ToggleOverride):
+			m.override = !m.override
+			m.resetInputs()
+			m.err = nil
+			return m, m.focusField(min(m.focus, m.editableFields()-1))
+
+		case key.Matches(msg, m.KeyMap.NextField):
+			return m, m.focusField(m.focus + 1)
+
+		case key.Matches(msg, m.KeyMap.PrevField):
+			return m, m.focusField(m.focus - 1)
+
+		case key.Matches(msg, m.KeyMap.Quit):
+			return m, func() tea.Msg { return ExitMetadataViewMsg{} }
+		}
+	}
+
+	m.inputs[m.focus], cmd = m.inputs[m.focus].Update(msg)
+	return m, cmd
+}
+
+// originalValue returns the hint shown under a field: the value in the file
+// when editing overrides, or the override masking the field otherwise.
+func (m MetadataEditModel) originalValue(field int) string {
+	file := [overridableFields]string{m.file.Title, strings.Join(m.file.Authors, "; "), m.file.Series, m.file.SeriesIndex}
+	o := m.book.Overrides
+	overridden := [overridableFields]string{o.Title, strings.Join(o.Authors, "; "), o.Series, o.SeriesIndex}
+
+	switch {
+	case field >= overridableFields:
+		return ""
+	case m.override && file[field] != "":
+		return "in file: " + file[field]
+	case !m.override && overridden[field] != "":
+		return "overridden by: " + overridden[field]
+	}
+	return ""
+}
+
+func (m MetadataEditModel) View() string {
+	form, _, _ := m.form()
+	view := lipgloss.JoinVertical(lipgloss.Left, form, m.helpView())
+	return lipgloss.NewStyle().MaxWidth(m.Width).MaxHeight(m.Height).Render(view)
+}
+
+// form renders the fields and the buttons under them, and returns the line
+// of every field and the one of the buttons.
+func (m MetadataEditModel) form() (form string, fieldLines [fieldCount]int, buttonsLine int) {
+	var s strings.Builder
+
+	mode := "Editing the metadata inside the file"
+	if m.override {
+		mode = "Editing overrides stored in extended attributes"
+	}
+	// Long paths keep their end, where the file name is.
+	path := m.book.Path
+	if w := lipgloss.Width(path); w > m.Width {
+		path = ansi.TruncateLeft(path, w-m.Width+1, "…")
+	}
+	s.WriteString(m.Styles.highlighted.Render(path) + "\n")
+	s.WriteString(m.Styles.tagnames.Render(mode) + "\n\n")
+
+	for i, input := range m.inputs {
+		fieldLines[i] = strings.Count(s.String(), "\n")
+		label := fmt.Sprintf("%-13s", fieldLabels[i])
+		switch {
+		case i == m.focus:
+			s.WriteString(m.Styles.cursor.Render("> "+label) + input.View())
+		case i >= m.editableFields():
+			s.WriteString(m.Styles.greyed.Render(ansi.Truncate("  "+label+input.Value(), m.Width, "…")))
+		default:
+			s.WriteString(m.Styles.tagnames.Render("  "+label) + input.View())
+		}
+		s.WriteString("\n")
+		if hint := m.originalValue(i); hint != "" {
+			s.WriteString(m.Styles.greyed.Render(ansi.Truncate(strings.Repeat(" ", labelWidth)+hint, m.Width, "…")) + "\n")
+		}
+	}
+
+	buttonsLine = strings.Count(s.String(), "\n") + 1
+	s.WriteString("\n" + buttonsView(m.Styles, m.buttons()) + "\n")
+
+	if m.err != nil {
+		s.WriteString("\n" + m.Styles.errorText.Render(m.err.Error()) + "\n")
+	}
+	return s.String(), fieldLines, buttonsLine
+}
+
+// buttons are the buttons under the fields.
+func (m MetadataEditModel) buttons() []button {
+	mode := "Edit overrides"
+	if m.override {
+		mode = "Edit file"
+	}
+	return []button{{"Save", saveKey}, {mode, overrideKey}, {"Cancel", escKey}}
+}
+
+// click focuses the field clicked on at line y, or presses the key of the
+// button at column x.
+func (m MetadataEditModel) click(x, y int) (tea.Model, tea.Cmd) {
+	_, fieldLines, buttonsLine := m.form()
+	if y == buttonsLine {
+		if k, ok := buttonAt(m.buttons(), x); ok {
+			return m.Update(k)
+		}
+		return m, nil
+	}
+	for i, line := range fieldLines {
+		if line == y && i < m.editableFields() {
+			return m, m.focusField(i)
+		}
+	}
+	return m, nil
+}
+
+func (m MetadataEditModel) helpView() string {
+	return m.Styles.HelpStyle.Render(m.Help.View(m))
+}
+
+func (m MetadataEditModel) FullHelp() [][]key.Binding {
+	return [][]key.Binding{m.ShortHelp()}
+}
+
+// ShortHelp returns bindings to show in the abbreviated help view. It's part
+// of the help.KeyMap interface.
+func (m MetadataEditModel) ShortHelp() []key.Binding {
+	return []key.Binding{
+		m.KeyMap.NextField,
+		m.KeyMap.PrevField,
+		m.KeyMap.Save,
+		m.KeyMap.ToggleOverride,
+		m.KeyMap.Quit,
+	}
+}
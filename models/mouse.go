@@ -0,0 +1,134 @@
+package models
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// wheelLines is the number of lines a turn of the mouse wheel scrolls text
+// by. Lists move their cursor by one item instead.
+const wheelLines = 3
+
+// Keys that buttons stand for.
+var (
+	enterKey    = tea.KeyMsg{Type: tea.KeyEnter}
+	escKey      = tea.KeyMsg{Type: tea.KeyEsc}
+	spaceKey    = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}}
+	upKey       = tea.KeyMsg{Type: tea.KeyUp}
+	downKey     = tea.KeyMsg{Type: tea.KeyDown}
+	saveKey     = tea.KeyMsg{Type: tea.KeyCtrlS}
+	overrideKey = tea.KeyMsg{Type: tea.KeyCtrlO}
+)
+
+// runeKey returns the key typing r.
+func runeKey(r rune) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}
+}
+
+// button is a clickable label of a dialog. Clicking it does what pressing
+// its key does.
+type button struct {
+	label string
+	key   tea.KeyMsg
+}
+
+// buttonsView renders the buttons on one line.
+func buttonsView(styles Styles, buttons []button) string {
+	labels := make([]string, len(buttons))
+	for i, b := range buttons {
+		labels[i] = styles.button.Render("[ " + b.label + " ]")
+	}
+	return strings.Join(labels, " ")
+}
+
+// buttonAt returns the key of the button at column x of the line rendered
+// by buttonsView.
+func buttonAt(buttons []button, x int) (tea.KeyMsg, bool) {
+	left := 0
+	for _, b := range buttons {
+		width := lipgloss.Width(b.label) + 4
+		if x >= left && x < left+width {
+			return b.key, true
+		}
+		left += width + 1
+	}
+	return tea.KeyMsg{}, false
+}
+
+// leftClick reports whether msg is a press of the left button.
+func leftClick(msg tea.MouseMsg) bool {
+	return msg.Action == tea.MouseActionPress && msg.Button == tea.MouseButtonLeft
+}
+
+// wheel returns -1 when msg turns the wheel up, 1 when it turns it down, and
+// 0 for other events.
+func wheel(msg tea.MouseMsg) int {
+	if msg.Action != tea.MouseActionPress {
+		return 0
+	}
+	switch msg.Button {
+	case tea.MouseButtonWheelUp:
+		return -1
+	case tea.MouseButtonWheelDown:
+		return 1
+	}
+	return 0
+}
+
+// wheelKey returns the arrow key a turn of the wheel stands for in lists.
+func wheelKey(d int) tea.KeyMsg {
+	if d < 0 {
+		return upKey
+	}
+	return downKey
+}
+
+// updateMouse handles the mouse in the views of the main model, and hands
+// it to the view on screen otherwise.
+func (m Model) updateMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	if m.err != nil {
+		if leftClick(msg) {
+			m.err = nil
+		}
+		return m, nil
+	}
+
+	switch m.state {
+	case filterView:
+		// The list only scrolls: it doesn't tell where its items are.
+		switch wheel(msg) {
+		case -1:
+			m.filterModel.CursorUp()
+		case 1:
+			m.filterModel.CursorDown()
+		}
+
+	case tagView:
+		m.tagModel, cmd = m.tagModel.Update(msg)
+	case metadataView:
+		m.metadataModel, cmd = m.metadataModel.Update(msg)
+	case fullTextView:
+		m.fullTextModel, cmd = m.fullTextModel.Update(msg)
+	case detailsView:
+		m.detailsModel, cmd = m.detailsModel.Update(msg)
+	case bulkTagView:
+		m.bulkTagModel, cmd = m.bulkTagModel.Update(msg)
+
+	case collectionView:
+		if leftClick(msg) && msg.Y == strings.Count(m.collectionHeader(), "\n")+2 {
+			if k, ok := buttonAt(m.collectionButtons(), msg.X); ok {
+				return m.Update(k)
+			}
+		}
+
+	case galleryView:
+		m.updateGalleryMouse(msg)
+
+	default:
+		m.updateBrowseMouse(msg)
+	}
+	return m, tea.Batch(cmd, m.loadCover())
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"Bonalioteko/cover"
+	"Bonalioteko/metadata"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// bookField is a labelled value of the metadata of a book.
+type bookField struct {
+	label, value string
+}
+
+// bookFields returns the metadata of the book, and its tags when there are
+// any. Fields without a value are left out.
+func bookFields(book metadata.Book, tags []string) []bookField {
+	series := book.Series
+	if series != "" && book.SeriesIndex != "" {
+		series += " #" + book.SeriesIndex
+	}
+	var ids []string
+	for _, id := range book.Identifiers {
+		if id.Scheme != "" {
+			ids = append(ids, strings.ToLower(id.Scheme)+":"+id.Value)
+		} else {
+			ids = append(ids, id.Value)
+		}
+	}
+	var added, modified string
+	if !book.Added.IsZero() {
+		added = book.Added.Format("2006-01-02")
+	}
+	if !book.ModTime.IsZero() {
+		modified = book.ModTime.Format("2006-01-02")
+	}
+	fields := []bookField{
+		{"Authors", book.Author()},
+		{"Series", series},
+		{"Language", book.Language},
+		{"Publisher", book.Publisher},
+		{"Published", book.PublishDate},
+		{"Identifiers", strings.Join(ids, ", ")},
+		{"Tags", strings.Join(tags, ", ")},
+		{"Size", humanSize(book.Size)},
+		{"Added", added},
+		{"Modified", modified},
+		{"Path", book.Path},
+	}
+	return slices.DeleteFunc(fields, func(f bookField) bool { return f.value == "" })
+}
+
+// fieldLines renders the fields one per line, wrapped to width.
+func fieldLines(styles Styles, fields []bookField, width int) []string {
+	wrap := lipgloss.NewStyle().Width(width)
+	var lines []string
+	for _, f := range fields {
+		field := styles.greyed.Render(f.label+": ") + f.value
+		lines = append(lines, strings.Split(wrap.Render(field), "\n")...)
+	}
+	return lines
+}
+
+// previewLines renders the cover and the metadata of the highlighted book
+// for a preview of width×height cells.
+func (m Model) previewLines(width, height int) []string {
+	book, ok := m.highlightedBook()
+	if !ok {
+		return []string{cover.Clear(m.coverProtocol, previewCoverID) + m.Styles.greyed.Render("no book")}
+	}
+	title := lipgloss.NewStyle().Width(width).Render(m.Styles.highlighted.Render(book.Title))
+	lines := strings.Split(title, "\n")
+	if art := m.coverLines(book.Path, width, height); len(art) > 0 {
+		lines = slices.Concat(art, []string{""}, lines)
+	} else {
+		lines[0] = cover.Clear(m.coverProtocol, previewCoverID) + lines[0]
+	}
+	lines = append(lines, "")
+	return append(lines, fieldLines(m.Styles, bookFields(book, m.pathTags[book.Path]), width)...)
+}
+
+// previewView renders the preview in height lines, from the line it is
+// scrolled to.
+func (m Model) previewView(width, height int) string {
+	lines := m.previewLines(width, height)
+	start := max(0, min(m.previewOffset, len(lines)-height))
+	end := min(len(lines), start+height)
+	if start > 0 {
+		// A kitty cover stays where it was drawn, over the scrolled text.
+		lines[start] = cover.Clear(m.coverProtocol, previewCoverID) + lines[start]
+	}
+	return strings.Join(lines[start:end], "\n")
+}
+
+// scrollPreview scrolls the preview by delta lines.
+func (m *Model) scrollPreview(delta int) {
+	last := len(m.previewLines(m.paneWidths()[previewPane], m.paneHeight())) - m.paneHeight()
+	m.previewOffset = max(0, min(m.previewOffset+delta, last))
+}
+
+// humanSize formats a size in bytes with a binary unit, as size: terms
+// take them.
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
@@ -2,8 +2,12 @@ package models
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
+	"Bonalioteko/journal"
+	"Bonalioteko/metadata"
+	"Bonalioteko/search"
 	"Bonalioteko/xattr"
 
 	keymaps "Bonalioteko/Keymaps"
@@ -13,6 +17,7 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 )
 
 const (
@@ -20,20 +25,35 @@ const (
 	editTagView
 )
 
+// maxSuggestions is the number of completions offered at once.
+const maxSuggestions = 5
+
 type TagEditModel struct {
 	modelState modelState
+	book       metadata.Book
 	fileName   string
 	Tags       []string
 	cursor     int
 	Styles     Styles
 	Width      int
-	max        int
+	Height     int
 	Help       help.Model
 	KeyMap     keymaps.KeyMap
-	height     int
 
 	textInput textinput.Model
 	err       error
+
+	// counts maps the tags of the library to the number of books carrying
+	// them, which the tags being typed are completed from. suggestions
+	// are the completions of the tag being typed, and suggestion the one
+	// Tab accepts.
+	counts      map[string]int
+	suggestions []string
+	suggestion  int
+
+	// embedTags mirrors every tag change into the EPUB's dc:subject
+	// elements.
+	embedTags bool
 }
 
 type ExitTagViewMsg struct {
@@ -42,28 +62,65 @@ type ExitTagViewMsg struct {
 type TagsUpdatedMsg struct {
 	NewTags  []string
 	filename string
+	// entry is the change, for the journal to undo it.
+	entry journal.Entry
 }
 
 func initialTextInputModel() textinput.Model {
 	ti := textinput.New()
-	ti.Placeholder = "New tag:"
+	ti.Placeholder = "tags, separated by commas"
 	ti.Focus()
-	ti.CharLimit = 50
+	ti.CharLimit = 256
 	return ti
 }
 
-func NewTagEditModel(fileName string, Tags []string) TagEditModel {
+func NewTagEditModel(book metadata.Book, Tags []string, counts map[string]int, embedTags bool) TagEditModel {
 	return TagEditModel{
-		fileName:  fileName,
+		book:      book,
+		fileName:  book.Path,
 		Tags:      Tags,
 		cursor:    0,
 		Styles:    DefaultStyles(),
-		Width:     30,
+		Width:     defaultWidth,
+		Height:    defaultHeight,
 		KeyMap:    keymaps.DefaultKeyMap(),
-		height:    10,
 		textInput: initialTextInputModel(),
 		Help:      help.New(),
+		embedTags: embedTags,
+		counts:    counts,
+	}
+}
+
+// syncSubjects writes the current tags into the EPUB when embedding is
+// enabled.
+func (m TagEditModel) syncSubjects() error {
+	if !m.embedTags {
+		return nil
 	}
+	return metadata.WriteSubjects(m.fileName, metadata.StoredTags(m.Tags))
+}
+
+// tagsChanged reads the tags of the book again after a change made to the
+// ones before, mirrors them into the EPUB, and returns the command telling
+// the other views. When the EPUB can't be written, the tags before are put
+// back and the change isn't journaled, as nothing was changed.
+func (m *TagEditModel) tagsChanged(action string, before []string) tea.Cmd {
+	tags, err := xattr.GetTagsFromPath(m.fileName)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	m.Tags = tags
+	var entry journal.Entry
+	if err := m.syncSubjects(); err != nil {
+		m.err = restoreTags(m.fileName, before, err)
+		tags = before
+		m.Tags = before
+	} else {
+		entry = newEntry(action, journal.Change{Path: m.fileName, Before: before, After: tags})
+	}
+	path := m.fileName
+	return func() tea.Msg { return TagsUpdatedMsg{NewTags: tags, filename: path, entry: entry} }
 }
 
 func (m TagEditModel) Init() tea.Cmd {
@@ -75,8 +132,26 @@ func (m TagEditModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
-		m.Width = 30
-		m.max = m.Width - 1
+		m.Width, m.Height = msg.Width, msg.Height
+		m.Help.Width = msg.Width
+		m.textInput.Width = max(1, msg.Width-lipgloss.Width(m.textInput.Prompt)-1)
+
+	case tea.MouseMsg:
+		if !leftClick(msg) {
+			return m, nil
+		}
+		if m.err != nil {
+			m.err = nil
+			return m, nil
+		}
+		return m.click(msg.X, msg.Y)
+
+	case tagsRestoredMsg:
+		if tags, ok := msg.Tags[m.fileName]; ok {
+			m.Tags = tags
+			m.cursor = max(0, min(m.cursor, len(m.Tags)-1))
+			m.complete()
+		}
 
 	case tea.KeyMsg:
 		if m.err != nil {
@@ -84,36 +159,60 @@ func (m TagEditModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 		if m.modelState == editTagView {
+			switch {
+			case key.Matches(msg, m.KeyMap.Complete):
+				m.acceptSuggestion()
+				return m, nil
+			case key.Matches(msg, m.KeyMap.NextSuggestion):
+				m.suggestion = min(m.suggestion+1, max(0, len(m.suggestions)-1))
+				return m, nil
+			case key.Matches(msg, m.KeyMap.PrevSuggestion):
+				m.suggestion = max(0, m.suggestion-1)
+				return m, nil
+			}
 			m.textInput, cmd = m.textInput.Update(msg)
 
 			switch msg.String() {
 			case "enter":
-				newTag := strings.TrimSpace(m.textInput.Value())
-				if newTag == "" {
+				tags, err := parseTags(m.textInput.Value())
+				if err != nil {
+					m.err = err
 					return m, nil
 				}
-				if err := xattr.Addtag(m.fileName, []byte(newTag)); err != nil {
-					m.err = err
+				if len(tags) == 0 {
 					return m, nil
 				}
-				m.textInput.Reset()
-				var err error
-				m.Tags, err = xattr.GetTagsFromPath(m.fileName)
+				before, err := xattr.GetTagsFromPath(m.fileName)
 				if err != nil {
 					m.err = err
 					return m, nil
 				}
-				cmd = func() tea.Msg { return TagsUpdatedMsg{NewTags: m.Tags, filename: m.fileName} }
+				if err := xattr.Addtag(m.fileName, []byte(strings.Join(tags, ","))); err != nil {
+					m.err = err
+					return m, nil
+				}
+				m.textInput.Reset()
+				cmd = m.tagsChanged(fmt.Sprintf("add %s to %s", strings.Join(tags, ", "), m.book.Title), before)
+				m.complete()
 
 			case "esc":
 				m.textInput.Blur()
 				m.modelState = defaultView
 
+			default:
+				m.complete()
 			}
 
 			return m, cmd
 
 		} else {
+			switch {
+			case key.Matches(msg, m.KeyMap.Undo):
+				return m, undo(false)
+			case key.Matches(msg, m.KeyMap.Redo):
+				return m, undo(true)
+			}
+
 			switch msg.String() {
 			case "right", "l":
 				if m.cursor < len(m.Tags)-1 {
@@ -129,21 +228,23 @@ func (m TagEditModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if len(m.Tags) == 0 || m.cursor < 0 || m.cursor >= len(m.Tags) {
 					break
 				}
-				if err := xattr.RemoveTag(m.fileName, m.Tags[m.cursor]); err != nil {
+				before, err := xattr.GetTagsFromPath(m.fileName)
+				if err != nil {
 					m.err = err
 					return m, nil
 				}
-
-				var err error
-				m.Tags, err = xattr.GetTagsFromPath(m.fileName)
-				if err != nil {
+				tag := m.Tags[m.cursor]
+				if err := xattr.RemoveTag(m.fileName, tag); err != nil {
 					m.err = err
+					return m, nil
 				}
 				m.cursor = 0
-				return m, func() tea.Msg { return TagsUpdatedMsg{NewTags: m.Tags, filename: m.fileName} }
+				return m, m.tagsChanged(fmt.Sprintf("remove %s from %s", tag, m.book.Title), before)
 
 			case "a":
 				m.modelState = editTagView
+				m.complete()
+				cmd = m.textInput.Focus()
 
 			case "esc":
 				cmd = func() tea.Msg { return ExitTagViewMsg{"Exit"} }
@@ -158,49 +259,236 @@ func (m TagEditModel) View() string {
 	if m.err != nil {
 		return fmt.Sprintf("error: %v\n\nPress any key to continue", m.err)
 	}
-	var s string
-	switch m.modelState {
-	case defaultView:
-		s = lipgloss.Place(50, 50, lipgloss.Center, lipgloss.Center, lipgloss.JoinVertical(lipgloss.Top, m.headerView(), m.helpView()))
+	s := lipgloss.Place(m.Width, m.Height, lipgloss.Center, lipgloss.Top, m.body())
+	if m.modelState == defaultView {
+		s = lipgloss.Place(m.Width, m.Height, lipgloss.Center, lipgloss.Center, m.body())
+	}
+	return lipgloss.NewStyle().MaxWidth(m.Width).MaxHeight(m.Height).Render(s)
+}
+
+// body renders the header, along with the tag being typed, the buttons and
+// the help.
+func (m TagEditModel) body() string {
+	bar, _ := centered(buttonsView(m.Styles, m.buttons()), m.Width)
+	if m.modelState == editTagView {
+		return lipgloss.JoinVertical(lipgloss.Top, m.headerView(), m.entryView(), bar, m.helpView())
+	}
+	return lipgloss.JoinVertical(lipgloss.Top, m.headerView(), bar, m.helpView())
+}
+
+// completing splits the text typed into the tags before the one being
+// typed, with their comma, and that one.
+func (m TagEditModel) completing() (before, tag string) {
+	v := m.textInput.Value()
+	i := strings.LastIndex(v, ",")
+	return v[:i+1], v[i+1:]
+}
+
+// complete offers the tags of the library completing the one being typed,
+// leaving out the ones the book or the text already has.
+func (m *TagEditModel) complete() {
+	before, tag := m.completing()
+	exclude := slices.Clone(m.Tags)
+	for t := range strings.SplitSeq(before, ",") {
+		exclude = append(exclude, strings.TrimSpace(t))
+	}
+	m.suggestions = search.CompleteTag(tag, m.counts, exclude)
+	m.suggestions = m.suggestions[:min(len(m.suggestions), maxSuggestions)]
+	m.suggestion = 0
+}
+
+// acceptSuggestion replaces the tag being typed with the highlighted
+// completion, ready for the next tag.
+func (m *TagEditModel) acceptSuggestion() {
+	if m.suggestion >= len(m.suggestions) {
+		return
+	}
+	before, _ := m.completing()
+	if before != "" {
+		before += " "
+	}
+	m.textInput.SetValue(before + m.suggestions[m.suggestion] + ", ")
+	m.textInput.CursorEnd()
+	m.complete()
+}
+
+// entryView renders the tags being typed, the completions of the last one
+// and the tags the book would end up with.
+func (m TagEditModel) entryView() string {
+	return lipgloss.JoinVertical(lipgloss.Top, m.textInput.View(), m.suggestionsView(), m.previewView())
+}
+
+// suggestionsView lists the completions of the tag being typed, with the
+// number of books carrying them.
+func (m TagEditModel) suggestionsView() string {
+	if len(m.suggestions) == 0 {
+		return m.Styles.greyed.Render("no completion")
+	}
+	parts := make([]string, len(m.suggestions))
+	for i, tag := range m.suggestions {
+		count := m.Styles.greyed.Render(fmt.Sprintf(" (%d)", m.counts[tag]))
+		if i == m.suggestion {
+			parts[i] = m.Styles.cursor.Render(m.KeyMap.Complete.Help().Key+" ") + m.Styles.highlightedtag.Render(tag) + count
+			continue
+		}
+		parts[i] = m.Styles.tagnames.Render(tag) + count
+	}
+	return ansi.Truncate(strings.Join(parts, "  "), m.Width, "…")
+}
+
+// previewView shows the tags the book would have once the ones typed are
+// added, the new ones marked with a +, or what is wrong with the text.
+func (m TagEditModel) previewView() string {
+	tags, err := parseTags(m.textInput.Value())
+	if err != nil {
+		return ansi.Truncate(m.Styles.errorText.Render("✗ "+err.Error()), m.Width, "…")
+	}
+	var parts []string
+	for _, tag := range m.Tags {
+		parts = append(parts, m.Styles.tagnames.Render(tag))
+	}
+	added := 0
+	for _, tag := range tags {
+		if !slices.Contains(m.Tags, tag) {
+			parts = append(parts, m.Styles.selectedtag.Render("+"+tag))
+			added++
+		}
+	}
+	if added == 0 {
+		return m.Styles.greyed.Render("nothing to add")
+	}
+	return ansi.Truncate(m.Styles.greyed.Render("→ ")+strings.Join(parts, m.Styles.greyed.Render(", ")), m.Width, "…")
+}
 
-	case editTagView:
-		s = lipgloss.Place(50, 50, lipgloss.Top, lipgloss.Center, lipgloss.JoinVertical(lipgloss.Top, m.headerView(), m.textInput.View(), m.helpView()))
+// buttons are the buttons under the tags.
+func (m TagEditModel) buttons() []button {
+	if m.modelState == editTagView {
+		return []button{{"Add", enterKey}, {"Cancel", escKey}}
 	}
-	return s
+	return []button{{"Add tag", runeKey('a')}, {"Delete", runeKey('d')}, {"Back", escKey}}
 }
 
+// headerView shows the title of the book and its tags, wrapped to the
+// width of the terminal.
 func (m TagEditModel) headerView() string {
-	var (
-		sections    []string
-		availHeight = m.height
-	)
+	title, _ := centered(ansi.Truncate(m.book.Title, m.Width, "…"), m.Width)
+	lines, _ := m.tagLines()
+	return strings.Join(append([]string{title}, lines...), "\n")
+}
 
-	var s strings.Builder
+// chip is where a tag is drawn in the header: its line, the columns it
+// takes, and the column of its delete mark, which only the highlighted tag
+// has.
+type chip struct {
+	line, start, mark, end int
+}
 
-	for i, tagPtr := range m.Tags {
+// tagLines wraps the tags to the width of the terminal, each line centred,
+// and returns where every tag went. Lines that don't fit above the tag
+// being typed, the buttons and the help are left out.
+func (m TagEditModel) tagLines() (lines []string, chips []chip) {
+	var line string
+	var pending []chip
+	flush := func() {
+		centredLine, left := centered(line, m.Width)
+		for _, c := range pending {
+			chips = append(chips, chip{c.line, c.start + left, c.mark + left, c.end + left})
+		}
+		lines = append(lines, centredLine)
+		line, pending = "", nil
+	}
+	for i, tag := range m.Tags {
+		text, mark := m.Styles.tagnames.Render(tag), ""
 		if m.cursor == i {
-			s.WriteString(m.Styles.highlightedtag.Render(tagPtr) + " ")
-		} else {
-			s.WriteString(m.Styles.tagnames.Render(tagPtr) + " ")
+			text, mark = m.Styles.highlightedtag.Render(tag), m.Styles.errorText.Render(" ×")
+		}
+		text = ansi.Truncate(text, max(1, m.Width-lipgloss.Width(mark)), "…")
+		if line != "" && lipgloss.Width(line)+1+lipgloss.Width(text+mark) > m.Width {
+			flush()
+		}
+		if line != "" {
+			line += " "
 		}
+		c := chip{line: len(lines), start: lipgloss.Width(line)}
+		line += text
+		c.mark = lipgloss.Width(line)
+		line += mark
+		c.end = lipgloss.Width(line)
+		pending = append(pending, c)
 	}
+	flush()
+
+	height := m.Height - lipgloss.Height(m.helpView()) - 4
+	if m.modelState == editTagView {
+		// The completions and the preview sit under the tags being typed.
+		height -= lipgloss.Height(m.entryView()) - 1
+	}
+	height = max(1, height)
+	return lines[:min(len(lines), height)], chips
+}
 
-	content := lipgloss.NewStyle().Height(availHeight).Render(s.String())
-	sections = append(sections, m.fileName)
-	sections = append(sections, content)
+// centered pads s with spaces to width cells, centring it, and returns the
+// padding on the left.
+func centered(s string, width int) (string, int) {
+	gap := max(0, width-lipgloss.Width(s))
+	return strings.Repeat(" ", gap/2) + s + strings.Repeat(" ", gap-gap/2), gap / 2
+}
+
+// click handles a click at column x and line y: clicking a tag highlights
+// it, clicking the mark of the highlighted tag deletes it, and clicking a
+// button presses its key.
+func (m TagEditModel) click(x, y int) (tea.Model, tea.Cmd) {
+	top := 0
+	if m.modelState == defaultView {
+		// The body is centred on the screen.
+		top = max(0, m.Height-lipgloss.Height(m.body())) / 2
+	}
+	lines, chips := m.tagLines()
+	y -= top + 1
+	if y >= 0 && y < len(lines) && m.modelState == defaultView {
+		for i, c := range chips {
+			switch {
+			case c.line != y || x < c.start || x >= c.end:
+			case x >= c.mark:
+				return m.Update(runeKey('d'))
+			default:
+				m.cursor = i
+			}
+		}
+		return m, nil
+	}
 
-	return lipgloss.JoinVertical(lipgloss.Center, sections...)
+	y -= len(lines)
+	if m.modelState == editTagView {
+		y -= lipgloss.Height(m.entryView())
+	}
+	if y == 0 {
+		_, left := centered(buttonsView(m.Styles, m.buttons()), m.Width)
+		if k, ok := buttonAt(m.buttons(), x-left); ok {
+			return m.Update(k)
+		}
+	}
+	return m, nil
 }
 
 func (m TagEditModel) helpView() string {
 	return m.Styles.HelpStyle.Render(m.Help.View(m))
 }
 
+// moveKeys are the keys moving through the tags, or through the
+// completions while tags are typed.
+func (m TagEditModel) moveKeys() []key.Binding {
+	if m.modelState == editTagView {
+		return []key.Binding{m.KeyMap.Complete, m.KeyMap.PrevSuggestion, m.KeyMap.NextSuggestion}
+	}
+	return []key.Binding{m.KeyMap.CursorLeft, m.KeyMap.CursorRight}
+}
+
 func (m TagEditModel) FullHelp() [][]key.Binding {
-	kb := [][]key.Binding{{
-		m.KeyMap.CursorRight,
-		m.KeyMap.CursorLeft,
-	}}
+	kb := [][]key.Binding{m.moveKeys()}
+	if m.modelState == defaultView {
+		kb = append(kb, []key.Binding{m.KeyMap.Undo, m.KeyMap.Redo})
+	}
 
 	return append(kb,
 		[]key.Binding{
@@ -212,12 +500,7 @@ func (m TagEditModel) FullHelp() [][]key.Binding {
 // ShortHelp returns bindings to show in the abbreviated help view. It's part
 // of the help.KeyMap interface.
 func (m TagEditModel) ShortHelp() []key.Binding {
-	kb := []key.Binding{
-		m.KeyMap.CursorLeft,
-		m.KeyMap.CursorRight,
-	}
-
-	return append(kb,
+	return append(m.moveKeys(),
 		m.KeyMap.Quit,
 		m.KeyMap.ShowFullHelp,
 	)
@@ -0,0 +1,222 @@
+package query
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func isKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "and", "or", "not":
+		return true
+	}
+	return false
+}
+
+// lex splits the query into tokens.
+func lex(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+
+		case r == '"':
+			start := i
+			var word strings.Builder
+			i++
+			for i < len(runes) && runes[i] != '"' {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				word.WriteRune(runes[i])
+				i++
+			}
+			if i == len(runes) {
+				return nil, &SyntaxError{start, "unterminated quoted tag"}
+			}
+			i++
+			tokens = append(tokens, token{tokWord, word.String(), start})
+
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`()"`, runes[i]) {
+				i++
+			}
+			word := string(runes[start:i])
+			kind := tokWord
+			switch strings.ToLower(word) {
+			case "and":
+				kind = tokAnd
+			case "or":
+				kind = tokOr
+			case "not":
+				kind = tokNot
+			}
+			tokens = append(tokens, token{kind, word, start})
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// Parse parses a query. An empty query returns a nil Node, which matches
+// everything.
+func Parse(s string) (Node, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, nil
+	}
+
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &SyntaxError{t.pos, "unexpected " + describe(t)}
+	}
+	return n, nil
+}
+
+// parseOr parses: and ("or" and)*
+func (p *parser) parseOr() (Node, error) {
+	n, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{n}
+	for p.peek().kind == tokOr {
+		p.next()
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return Or(nodes), nil
+}
+
+// parseAnd parses: unary (["and"] unary)*
+func (p *parser) parseAnd() (Node, error) {
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	nodes := []Node{n}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.next()
+		case tokWord, tokNot, tokLParen:
+		default:
+			if len(nodes) == 1 {
+				return nodes[0], nil
+			}
+			return And(nodes), nil
+		}
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
+
+// parseUnary parses: "not" unary | "(" or ")" | tag
+func (p *parser) parseUnary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNot:
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{x}, nil
+
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if r := p.next(); r.kind != tokRParen {
+			return nil, &SyntaxError{r.pos, "expected ) but found " + describe(r)}
+		}
+		return n, nil
+
+	case tokWord:
+		return Tag{t.text}, nil
+	}
+	return nil, &SyntaxError{t.pos, "expected a tag but found " + describe(t)}
+}
+
+func describe(t token) string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return "'" + t.text + "'"
+}
+
+// HasOperators reports whether s uses any operator, parenthesis or quote,
+// as opposed to being a plain list of words.
+func HasOperators(s string) bool {
+	tokens, err := lex(s)
+	if err != nil {
+		return true
+	}
+	for _, t := range tokens {
+		switch t.kind {
+		case tokAnd, tokOr, tokNot, tokLParen, tokRParen:
+			return true
+		}
+	}
+	return strings.Contains(s, `"`)
+}
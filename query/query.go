@@ -0,0 +1,162 @@
+// Package query implements the boolean tag query language used to filter
+// the library, such as `(philosophy or religion) and not unread`.
+//
+// Terms are tag names, bare or double quoted, with `\` escaping the next
+// character inside quotes. They combine with the or, and and not
+// operators, in increasing order of precedence, and parentheses. Terms
+// written next to each other are joined with and.
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Node is a node of a parsed query.
+type Node interface {
+	// Match reports whether a book whose tags are reported by has matches
+	// the query.
+	Match(has func(tag string) bool) bool
+	String() string
+}
+
+type (
+	// Tag matches the books carrying the tag.
+	Tag struct{ Name string }
+	// Not matches the books that X doesn't match.
+	Not struct{ X Node }
+	// And matches the books matched by every node.
+	And []Node
+	// Or matches the books matched by any node.
+	Or []Node
+)
+
+func (t Tag) Match(has func(string) bool) bool { return has(t.Name) }
+func (n Not) Match(has func(string) bool) bool { return !n.X.Match(has) }
+
+func (a And) Match(has func(string) bool) bool {
+	for _, n := range a {
+		if !n.Match(has) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o Or) Match(has func(string) bool) bool {
+	for _, n := range o {
+		if n.Match(has) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Tag) String() string {
+	if needsQuotes(t.Name) {
+		return `"` + quoteEscaper.Replace(t.Name) + `"`
+	}
+	return t.Name
+}
+
+func (n Not) String() string { return "not " + group(n.X) }
+func (a And) String() string { return join(a, " and ") }
+func (o Or) String() string  { return join(o, " or ") }
+
+// group wraps n in parentheses when it is a binary operation.
+func group(n Node) string {
+	switch n.(type) {
+	case And, Or:
+		return "(" + n.String() + ")"
+	}
+	return n.String()
+}
+
+func join(nodes []Node, op string) string {
+	parts := make([]string, len(nodes))
+	for i, n := range nodes {
+		parts[i] = group(n)
+	}
+	return strings.Join(parts, op)
+}
+
+// quoteEscaper escapes the characters the lexer unescapes in quoted tags.
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// needsQuotes reports whether the tag can't be written as a bare word.
+func needsQuotes(tag string) bool {
+	if tag == "" || isKeyword(tag) {
+		return true
+	}
+	return strings.ContainsFunc(tag, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`"()`, r)
+	})
+}
+
+// Tags returns the tag names referenced by n.
+func Tags(n Node) []string {
+	var tags []string
+	var walk func(Node)
+	walk = func(n Node) {
+		switch n := n.(type) {
+		case Tag:
+			tags = append(tags, n.Name)
+		case Not:
+			walk(n.X)
+		case And:
+			for _, x := range n {
+				walk(x)
+			}
+		case Or:
+			for _, x := range n {
+				walk(x)
+			}
+		}
+	}
+	if n != nil {
+		walk(n)
+	}
+	return tags
+}
+
+// Eval returns the paths of all that match n, using index to look up the
+// paths carrying each tag. A nil query matches everything. The order of all
+// is kept.
+func Eval(n Node, index map[string][]string, all []string) []string {
+	if n == nil {
+		return all
+	}
+	sets := make(map[string]map[string]bool)
+	has := func(path string) func(string) bool {
+		return func(tag string) bool {
+			set, ok := sets[tag]
+			if !ok {
+				set = make(map[string]bool, len(index[tag]))
+				for _, p := range index[tag] {
+					set[p] = true
+				}
+				sets[tag] = set
+			}
+			return set[path]
+		}
+	}
+
+	var result []string
+	for _, path := range all {
+		if n.Match(has(path)) {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// SyntaxError reports a malformed query.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query: %s at position %d", e.Msg, e.Pos+1)
+}
@@ -0,0 +1,123 @@
+package query_test
+
+import (
+	"testing"
+
+	"Bonalioteko/query"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	type testCase struct {
+		query string
+		want  query.Node
+	}
+
+	testCases := []testCase{
+		{query: "", want: nil},
+		{query: "philosophy", want: query.Tag{Name: "philosophy"}},
+		{
+			query: "philosophy OR religion and not unread",
+			want: query.Or{
+				query.Tag{Name: "philosophy"},
+				query.And{query.Tag{Name: "religion"}, query.Not{X: query.Tag{Name: "unread"}}},
+			},
+		},
+		{
+			query: `(philosophy or religion) "science fiction"`,
+			want: query.And{
+				query.Or{query.Tag{Name: "philosophy"}, query.Tag{Name: "religion"}},
+				query.Tag{Name: "science fiction"},
+			},
+		},
+		{query: `not not "and"`, want: query.Not{X: query.Not{X: query.Tag{Name: "and"}}}},
+	}
+
+	for _, tc := range testCases {
+		got, err := query.Parse(tc.query)
+		if err != nil {
+			t.Errorf("Parse(%q): got error:%s", tc.query, err)
+			continue
+		}
+		if !cmp.Equal(tc.want, got) {
+			t.Errorf("Parse(%q): %s", tc.query, cmp.Diff(tc.want, got))
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, q := range []string{"(a or b", "a or", "and a", `"a`, "a )", "not"} {
+		if _, err := query.Parse(q); err == nil {
+			t.Errorf("Parse(%q): expected an error", q)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, q := range []string{
+		"(philosophy or religion) and not unread",
+		`"science fiction" or not (a and b)`,
+	} {
+		n, err := query.Parse(q)
+		if err != nil {
+			t.Fatalf("Parse(%q): got error:%s", q, err)
+		}
+		if n.String() != q {
+			t.Errorf("String(): want %q, got %q", q, n.String())
+		}
+	}
+}
+
+func TestString_RoundTrip(t *testing.T) {
+	for _, name := range []string{
+		`science fiction`,
+		`C:\books`,
+		`read \ later`,
+		`trailing\ `,
+		`say "hi"`,
+		`"\"`,
+		"non\u00a0breaking",
+		`(draft)`,
+		`not`,
+		``,
+	} {
+		n := query.And{query.Tag{Name: name}, query.Not{X: query.Tag{Name: name}}}
+		got, err := query.Parse(n.String())
+		if err != nil {
+			t.Errorf("Parse(%q): got error:%s", n.String(), err)
+			continue
+		}
+		if diff := cmp.Diff(query.Node(n), got); diff != "" {
+			t.Errorf("Parse(%q): mismatch (-want +got):\n%s", n.String(), diff)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	index := map[string][]string{
+		"philosophy": {"a", "b"},
+		"religion":   {"b", "c"},
+		"unread":     {"b", "d"},
+	}
+	all := []string{"a", "b", "c", "d", "e"}
+
+	testCases := map[string][]string{
+		"philosophy or religion":                  {"a", "b", "c"},
+		"(philosophy or religion) and not unread": {"a", "c"},
+		"not philosophy":                          {"c", "d", "e"},
+		"philosophy religion":                     {"b"},
+		"missing":                                 nil,
+		"":                                        all,
+	}
+	for q, want := range testCases {
+		n, err := query.Parse(q)
+		if err != nil {
+			t.Fatalf("Parse(%q): got error:%s", q, err)
+		}
+		got := query.Eval(n, index, all)
+		if !cmp.Equal(want, got) {
+			t.Errorf("Eval(%q): %s", q, cmp.Diff(want, got))
+		}
+	}
+}
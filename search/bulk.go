@@ -0,0 +1,88 @@
+package search
+
+import "slices"
+
+// Share is how many of a set of books carry a tag.
+type Share int
+
+const (
+	ShareNone Share = iota
+	ShareSome
+	ShareAll
+)
+
+// TagShare tells whether all the books at paths, some or none carry the tag,
+// and how many do. tags maps the path of every book to its tags.
+func TagShare(tag string, paths []string, tags map[string][]string) (Share, int) {
+	n := 0
+	for _, path := range paths {
+		if slices.Contains(tags[path], tag) {
+			n++
+		}
+	}
+	switch n {
+	case 0:
+		return ShareNone, n
+	case len(paths):
+		return ShareAll, n
+	}
+	return ShareSome, n
+}
+
+// TagEdit is what adding tags to a book, or removing them, did: its tags
+// went from Before to After, unless Err is set. Before is nil when the tags
+// could not be read.
+type TagEdit struct {
+	Path   string
+	Before []string
+	After  []string
+	Err    error
+}
+
+// Changed tells whether the tags of the book were rewritten.
+func (e TagEdit) Changed() bool {
+	return e.Err == nil && !slices.Equal(e.Before, e.After)
+}
+
+// EditTags adds the tags to every book at paths, or removes them, and
+// tells what it did to each of them, in the order of paths. The tags of a
+// book are read again before they are changed, so that changes made
+// elsewhere are kept, and are written only when they changed. A book that
+// fails is reported and doesn't stop the others.
+func EditTags(paths, tags []string, add bool, read func(path string) ([]string, error), write func(path string, tags []string) error) []TagEdit {
+	edits := make([]TagEdit, 0, len(paths))
+	for _, path := range paths {
+		e := TagEdit{Path: path}
+		e.Before, e.Err = read(path)
+		if e.Err == nil {
+			e.After = slices.Clone(e.Before)
+			for _, tag := range tags {
+				if add && !slices.Contains(e.After, tag) {
+					e.After = append(e.After, tag)
+				}
+				if !add {
+					e.After = slices.DeleteFunc(e.After, func(t string) bool { return t == tag })
+				}
+			}
+			if !slices.Equal(e.Before, e.After) {
+				e.Err = write(path, e.After)
+			}
+		}
+		edits = append(edits, e)
+	}
+	return edits
+}
+
+// CountEdits returns the number of books whose tags were rewritten and of
+// the ones that failed.
+func CountEdits(edits []TagEdit) (changed, failed int) {
+	for _, e := range edits {
+		switch {
+		case e.Err != nil:
+			failed++
+		case e.Changed():
+			changed++
+		}
+	}
+	return changed, failed
+}
@@ -0,0 +1,72 @@
+package search
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// How a tag matches the text being completed, best first.
+const (
+	completesPrefix = iota
+	completesWord
+	completesSubstring
+	completesSubsequence
+	completesNot
+)
+
+// CompleteTag returns the tags completing text, best first: the tags
+// starting with it, then the ones with a word starting with it, then the
+// ones containing it, then the ones holding its letters in order. Tags are
+// compared without case and diacritics. Among equal matches, the tags
+// carried by the most books come first. The tags in exclude and the
+// virtual tags, which can't be added to a book, are left out.
+func CompleteTag(text string, counts map[string]int, exclude []string) []string {
+	w := foldText(strings.TrimSpace(text)).runes
+	type completion struct {
+		tag   string
+		match int
+	}
+	var completions []completion
+	for tag := range counts {
+		if IsVirtual(tag) || slices.Contains(exclude, tag) {
+			continue
+		}
+		if match := completes(w, foldText(tag)); match != completesNot {
+			completions = append(completions, completion{tag, match})
+		}
+	}
+	slices.SortFunc(completions, func(a, b completion) int {
+		if c := cmp.Compare(a.match, b.match); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(counts[b.tag], counts[a.tag]); c != 0 {
+			return c
+		}
+		return strings.Compare(a.tag, b.tag)
+	})
+
+	tags := make([]string, len(completions))
+	for i, c := range completions {
+		tags[i] = c.tag
+	}
+	return tags
+}
+
+// completes tells how the folded tag matches the folded text w. Every tag
+// starts with an empty text.
+func completes(w []rune, tag folded) int {
+	i := indexRunes(tag.runes, w)
+	switch {
+	case i == 0:
+		return completesPrefix
+	case i > 0 && !isWordRune(tag.runes[i-1]):
+		return completesWord
+	case i > 0:
+		return completesSubstring
+	}
+	if _, _, ok := subsequence(w, tag.runes); ok {
+		return completesSubsequence
+	}
+	return completesNot
+}
@@ -0,0 +1,128 @@
+package search
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"Bonalioteko/metadata"
+)
+
+// Facets are the metadata fields offered for browsing, in display order.
+var Facets = []string{"author", "lang", "publisher", "decade", "format"}
+
+// FacetValues returns the values the book has for the facet.
+func FacetValues(b metadata.Book, facet string) []string {
+	var values []string
+	switch facet {
+	case "author":
+		values = b.Authors
+	case "lang":
+		values = []string{strings.ToLower(b.Language)}
+	case "publisher":
+		values = []string{b.Publisher}
+	case "decade":
+		if year := b.Year(); year != 0 {
+			values = []string{strconv.Itoa(year/10*10) + "s"}
+		}
+	case "format":
+		values = []string{b.Format()}
+	}
+
+	var kept []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" && !slices.Contains(kept, v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// Selection holds the selected values of each facet. A book matches when,
+// for every facet with a selection, it has one of the selected values.
+type Selection map[string][]string
+
+// Has reports whether the value of the facet is selected.
+func (s Selection) Has(facet, value string) bool {
+	return slices.Contains(s[facet], value)
+}
+
+// Toggle selects the value of the facet, or deselects it when selected.
+func (s Selection) Toggle(facet, value string) {
+	if i := slices.Index(s[facet], value); i >= 0 {
+		s[facet] = slices.Delete(s[facet], i, i+1)
+		if len(s[facet]) == 0 {
+			delete(s, facet)
+		}
+		return
+	}
+	s[facet] = append(s[facet], value)
+}
+
+// Match reports whether the book matches the selection.
+func (s Selection) Match(b metadata.Book) bool {
+	return s.matchExcept(b, "")
+}
+
+// matchExcept is Match ignoring the selection of one facet.
+func (s Selection) matchExcept(b metadata.Book, skip string) bool {
+	for facet, selected := range s {
+		if facet == skip || len(selected) == 0 {
+			continue
+		}
+		if !slices.ContainsFunc(FacetValues(b, facet), func(v string) bool {
+			return slices.Contains(selected, v)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// Narrow returns the books matching the selection, keeping their order.
+func (s Selection) Narrow(books []metadata.Book) []metadata.Book {
+	if len(s) == 0 {
+		return books
+	}
+	var kept []metadata.Book
+	for _, b := range books {
+		if s.Match(b) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// FacetCount is a value of a facet and the number of books having it.
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+// Counts counts the values of the facet among the books matching the
+// selection of the other facets, so that the counts show what selecting
+// another value would give. The most frequent values come first.
+func (s Selection) Counts(books []metadata.Book, facet string) []FacetCount {
+	counts := make(map[string]int)
+	for _, b := range books {
+		if !s.matchExcept(b, facet) {
+			continue
+		}
+		for _, v := range FacetValues(b, facet) {
+			counts[v]++
+		}
+	}
+
+	result := make([]FacetCount, 0, len(counts))
+	for v, n := range counts {
+		result = append(result, FacetCount{Value: v, Count: n})
+	}
+	slices.SortFunc(result, func(a, b FacetCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+	return result
+}
@@ -0,0 +1,268 @@
+// Package search parses and evaluates the searches typed in the filter
+// view.
+package search
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"Bonalioteko/metadata"
+)
+
+// Field names accepted before a colon, and the field they stand for.
+var fieldNames = map[string]string{
+	"author":    "author",
+	"by":        "author",
+	"title":     "title",
+	"lang":      "lang",
+	"language":  "lang",
+	"series":    "series",
+	"publisher": "publisher",
+	"year":      "year",
+	"size":      "size",
+	"format":    "format",
+	"isbn":      "isbn",
+}
+
+// Term is a field-qualified term such as author:tolstoy or year:1890..1910.
+type Term struct {
+	Field string
+	Value string
+
+	// span holds the bounds of year and size terms.
+	span span
+}
+
+// span is an inclusive range of integers. Missing bounds are open.
+type span struct {
+	min, max int64
+}
+
+func (s span) contains(v int64) bool {
+	return v >= s.min && v <= s.max
+}
+
+// Search is a parsed search: the field terms and the rest of the text.
+type Search struct {
+	Terms []Term
+	// Text is what is left once the field terms are removed. It is fuzzy
+	// matched or evaluated as a tag query.
+	Text string
+}
+
+// HasTerms reports whether the search has field terms.
+func (s Search) HasTerms() bool {
+	return len(s.Terms) > 0
+}
+
+// Parse splits s into field terms and free text. Words whose prefix is not
+// a known field stay in the text.
+func Parse(s string) (Search, error) {
+	var search Search
+	var text []string
+	for _, word := range splitWords(s) {
+		name, value, ok := strings.Cut(word, ":")
+		field, known := fieldNames[strings.ToLower(name)]
+		if !ok || !known {
+			text = append(text, word)
+			continue
+		}
+
+		term, err := newTerm(field, unquote(value))
+		if err != nil {
+			return Search{}, err
+		}
+		search.Terms = append(search.Terms, term)
+	}
+	search.Text = strings.Join(text, " ")
+	return search, nil
+}
+
+func newTerm(field, value string) (Term, error) {
+	term := Term{Field: field, Value: value}
+	if value == "" {
+		return term, fmt.Errorf("%s: needs a value", field)
+	}
+
+	var err error
+	switch field {
+	case "isbn":
+		// A value without digits would match every book.
+		if !strings.ContainsFunc(value, unicode.IsDigit) {
+			return term, fmt.Errorf("%s: needs digits", field)
+		}
+	case "year":
+		term.span, err = parseSpan(value, strconv.ParseInt)
+	case "size":
+		term.span, err = parseSpan(value, parseSize)
+	}
+	if err != nil {
+		return term, fmt.Errorf("%s:%s: %w", field, value, err)
+	}
+	return term, nil
+}
+
+// parseSpan parses N, N..M, N.., ..M, >N, >=N, <N and <=N.
+func parseSpan(s string, parse func(string, int, int) (int64, error)) (span, error) {
+	sp := span{min: math.MinInt64, max: math.MaxInt64}
+	bound := func(v string) (int64, error) { return parse(strings.TrimSpace(v), 10, 64) }
+
+	var err error
+	switch {
+	case strings.Contains(s, ".."):
+		lo, hi, _ := strings.Cut(s, "..")
+		if lo != "" {
+			if sp.min, err = bound(lo); err != nil {
+				return sp, err
+			}
+		}
+		if hi != "" {
+			if sp.max, err = bound(hi); err != nil {
+				return sp, err
+			}
+		}
+	case strings.HasPrefix(s, ">="):
+		sp.min, err = bound(s[2:])
+	case strings.HasPrefix(s, "<="):
+		sp.max, err = bound(s[2:])
+	case strings.HasPrefix(s, ">"):
+		sp.min, err = bound(s[1:])
+		sp.min++
+	case strings.HasPrefix(s, "<"):
+		sp.max, err = bound(s[1:])
+		sp.max--
+	default:
+		sp.min, err = bound(s)
+		sp.max = sp.min
+	}
+	if err != nil {
+		return sp, err
+	}
+	if sp.min > sp.max {
+		return sp, fmt.Errorf("empty range")
+	}
+	return sp, nil
+}
+
+// sizeUnits are the multipliers of the size suffixes, in powers of 1024.
+var sizeUnits = []struct {
+	suffix string
+	factor float64
+}{
+	{"kb", 1 << 10}, {"k", 1 << 10},
+	{"mb", 1 << 20}, {"m", 1 << 20},
+	{"gb", 1 << 30}, {"g", 1 << 30},
+	{"b", 1},
+}
+
+// parseSize parses sizes such as 500KB or 1.5MB. It has the signature of
+// strconv.ParseInt so that parseSpan can use either.
+func parseSize(s string, _ int, _ int) (int64, error) {
+	lower := strings.ToLower(s)
+	factor := 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSuffix(lower, u.suffix)
+			factor = u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(lower), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size such as 500KB or 5MB", s)
+	}
+	return int64(n * factor), nil
+}
+
+// Match reports whether the book matches every field term.
+func (s Search) Match(b metadata.Book) bool {
+	for _, t := range s.Terms {
+		if !t.Match(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether the book matches the term.
+func (t Term) Match(b metadata.Book) bool {
+	switch t.Field {
+	case "author":
+		for _, a := range b.Authors {
+			if contains(a, t.Value) {
+				return true
+			}
+		}
+		return false
+	case "title":
+		return contains(b.Title, t.Value)
+	case "series":
+		return contains(b.Series, t.Value)
+	case "publisher":
+		return contains(b.Publisher, t.Value)
+	case "lang":
+		lang := Fold(b.Language)
+		want := Fold(t.Value)
+		return lang == want || strings.HasPrefix(lang, want+"-")
+	case "format":
+		return Fold(b.Format()) == Fold(strings.TrimPrefix(t.Value, "."))
+	case "isbn":
+		return strings.Contains(digits(b.ISBN()), digits(t.Value))
+	case "year":
+		year := b.Year()
+		return year != 0 && t.span.contains(int64(year))
+	case "size":
+		return t.span.contains(b.Size)
+	}
+	return false
+}
+
+// contains reports whether s contains sub, ignoring case.
+func contains(s, sub string) bool {
+	return strings.Contains(Fold(s), Fold(sub))
+}
+
+// digits keeps the digits and X of an ISBN.
+func digits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) || r == 'X' || r == 'x' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// splitWords splits s on spaces outside double quotes.
+func splitWords(s string) []string {
+	var words []string
+	var word strings.Builder
+	inQuotes := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			word.WriteRune(r)
+		case unicode.IsSpace(r) && !inQuotes:
+			if word.Len() > 0 {
+				words = append(words, word.String())
+				word.Reset()
+			}
+		default:
+			word.WriteRune(r)
+		}
+	}
+	if word.Len() > 0 {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
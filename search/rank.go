@@ -0,0 +1,329 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"Bonalioteko/metadata"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Field is a text a document can be found by, and how much a match in it
+// counts.
+type Field struct {
+	Text   string
+	Weight float64
+}
+
+// Weights of the fields of a book: a match in the title counts more than
+// one in the authors, which counts more than one in the tags.
+const (
+	TitleWeight  = 3
+	AuthorWeight = 2
+	TagWeight    = 1
+)
+
+// BookFields returns the fields a book is ranked on: its title, its
+// authors and its tags, in that order. The filter and the saved
+// collections both rank books on them, so that they find the same ones.
+func BookFields(b metadata.Book, tags []string) []Field {
+	tags = slices.Sorted(slices.Values(tags))
+	return []Field{
+		{Text: b.Title, Weight: TitleWeight},
+		{Text: b.Author(), Weight: AuthorWeight},
+		{Text: strings.Join(tags, ", "), Weight: TagWeight},
+	}
+}
+
+// BookTags turns tags, which maps the tags to the paths of their books,
+// into a map of the paths to the tags of their books.
+func BookTags(tags map[string][]string) map[string][]string {
+	bookTags := make(map[string][]string)
+	for tag, paths := range tags {
+		for _, path := range paths {
+			bookTags[path] = append(bookTags[path], tag)
+		}
+	}
+	return bookTags
+}
+
+// Match is a document matching a ranked search.
+type Match struct {
+	Index int
+	Score float64
+	// Highlights holds, for each field, the indexes of the runes of its
+	// text that matched.
+	Highlights [][]int
+}
+
+// Rank returns the documents matching every word of q, best first. Words
+// are compared without case and diacritics, and match a field as a
+// substring, as a word with a typo or two, or failing that as a
+// subsequence of its letters.
+func Rank(q string, docs [][]Field) []Match {
+	words := foldText(q).wordRunes()
+	if len(words) == 0 {
+		return nil
+	}
+
+	var matches []Match
+	for i, fields := range docs {
+		folded := make([]folded, len(fields))
+		for j, f := range fields {
+			folded[j] = foldText(f.Text)
+		}
+
+		m := Match{Index: i, Highlights: make([][]int, len(fields))}
+		for _, w := range words {
+			best, bestField, bestRunes := 0.0, -1, []int(nil)
+			for j, f := range folded {
+				score, runes := matchWord(w, f)
+				if score*fields[j].Weight > best {
+					best, bestField, bestRunes = score*fields[j].Weight, j, runes
+				}
+			}
+			if bestField < 0 {
+				m.Score = 0
+				break
+			}
+			m.Score += best
+			m.Highlights[bestField] = append(m.Highlights[bestField], bestRunes...)
+		}
+		if m.Score == 0 {
+			continue
+		}
+		for j := range m.Highlights {
+			slices.Sort(m.Highlights[j])
+			m.Highlights[j] = slices.Compact(m.Highlights[j])
+		}
+		matches = append(matches, m)
+	}
+
+	slices.SortStableFunc(matches, func(a, b Match) int {
+		switch {
+		case a.Score > b.Score:
+			return -1
+		case a.Score < b.Score:
+			return 1
+		}
+		return 0
+	})
+	return matches
+}
+
+// Scores of the ways a word can match a field, before weighting.
+const (
+	substringScore   = 1.0
+	wordStartBonus   = 0.25
+	wholeWordBonus   = 0.25
+	typoScore        = 0.7
+	typoPenalty      = 0.15
+	subsequenceScore = 0.4
+)
+
+// matchWord scores the best match of the folded word w in f, and returns
+// the indexes of the matched runes of the original text.
+func matchWord(w []rune, f folded) (float64, []int) {
+	if i := indexRunes(f.runes, w); i >= 0 {
+		score := substringScore
+		if i == 0 || !isWordRune(f.runes[i-1]) {
+			score += wordStartBonus
+			if end := i + len(w); end == len(f.runes) || !isWordRune(f.runes[end]) {
+				score += wholeWordBonus
+			}
+		}
+		return score, f.original(i, i+len(w))
+	}
+
+	if maxDist := allowedTypos(len(w)); maxDist > 0 {
+		best, bestRunes := -1, []int(nil)
+		for _, s := range f.words() {
+			word := f.runes[s[0]:s[1]]
+			// Compare against the start of longer words too, so that a
+			// word being typed matches.
+			candidates := [][]rune{word}
+			if len(word) > len(w) {
+				candidates = append(candidates, word[:len(w)])
+			}
+			for _, c := range candidates {
+				if d := distance(w, c); d <= maxDist && (best < 0 || d < best) {
+					best, bestRunes = d, f.original(s[0], s[0]+len(c))
+				}
+			}
+		}
+		if best >= 0 {
+			return typoScore - typoPenalty*float64(best), bestRunes
+		}
+	}
+
+	if start, end, ok := subsequence(w, f.runes); ok && len(w) > 1 {
+		var runes []int
+		j := 0
+		for i := start; i < end && j < len(w); i++ {
+			if f.runes[i] == w[j] {
+				runes = append(runes, f.orig[i])
+				j++
+			}
+		}
+		return subsequenceScore * float64(len(w)) / float64(end-start), runes
+	}
+	return 0, nil
+}
+
+// allowedTypos returns how many edits a word of n runes may be off by.
+func allowedTypos(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// distance returns the optimal string alignment distance between a and b:
+// the number of insertions, deletions, substitutions and transpositions of
+// adjacent runes turning one into the other.
+func distance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// subsequence finds the shortest span of s holding the runes of w in
+// order.
+func subsequence(w, s []rune) (start, end int, ok bool) {
+	if len(w) == 0 {
+		return 0, 0, false
+	}
+	best := -1
+	for i, r := range s {
+		if r != w[0] {
+			continue
+		}
+		j, k := i, 0
+		for ; j < len(s) && k < len(w); j++ {
+			if s[j] == w[k] {
+				k++
+			}
+		}
+		if k < len(w) {
+			break
+		}
+		if best < 0 || j-i < end-start {
+			start, end, best = i, j, j-i
+		}
+	}
+	return start, end, best >= 0
+}
+
+func indexRunes(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if slices.Equal(s[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// folded is a text without case and diacritics, remembering which rune of
+// the original text each of its runes comes from.
+type folded struct {
+	runes []rune
+	orig  []int
+}
+
+// letterFolds spells out the letters that don't decompose into a base
+// letter and diacritics.
+var letterFolds = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+}
+
+func foldText(s string) folded {
+	var f folded
+	for i, r := range []rune(s) {
+		r = unicode.ToLower(r)
+		decomposed := norm.NFD.String(string(r))
+		if spelled, ok := letterFolds[r]; ok {
+			decomposed = spelled
+		}
+		for _, d := range decomposed {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+			f.runes = append(f.runes, d)
+			f.orig = append(f.orig, i)
+		}
+	}
+	return f
+}
+
+// original returns the indexes in the original text of the runes from
+// start to end.
+func (f folded) original(start, end int) []int {
+	var runes []int
+	for i := start; i < end; i++ {
+		if len(runes) == 0 || runes[len(runes)-1] != f.orig[i] {
+			runes = append(runes, f.orig[i])
+		}
+	}
+	return runes
+}
+
+// words returns the spans of the words of f.
+func (f folded) words() [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range f.runes {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(f.runes)})
+	}
+	return spans
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Fold removes case and diacritics from s, so that "Dostoévski" and
+// "dostoevski" compare equal.
+func Fold(s string) string {
+	return string(foldText(s).runes)
+}
+
+// wordRunes returns the words of the folded text.
+func (f folded) wordRunes() [][]rune {
+	var words [][]rune
+	for _, s := range f.words() {
+		words = append(words, f.runes[s[0]:s[1]])
+	}
+	return words
+}
@@ -0,0 +1,439 @@
+package search_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"Bonalioteko/metadata"
+	"Bonalioteko/search"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+var books = []metadata.Book{
+	{
+		Path:        "/books/resurrection.epub",
+		Title:       "Resurrection",
+		Authors:     []string{"Leo Tolstoy"},
+		Language:    "en-GB",
+		PublishDate: "1899-12-01",
+		Publisher:   "Penguin",
+		Size:        3 << 20,
+	},
+	{
+		Path:        "/books/demons.epub",
+		Title:       "Demons",
+		Authors:     []string{"Fyodor Dostoevsky"},
+		Series:      "Novels",
+		Language:    "ru",
+		PublishDate: "1872",
+		Size:        8 << 20,
+	},
+	{
+		Path:    "/books/notes.pdf",
+		Title:   "Notes from Underground",
+		Authors: []string{"Fyodor Dostoevsky"},
+		Size:    512 << 10,
+	},
+}
+
+func TestMatch(t *testing.T) {
+	type testCase struct {
+		search string
+		want   []string
+	}
+
+	testCases := []testCase{
+		{search: "author:tolstoy", want: []string{"Resurrection"}},
+		{search: `author:"fyodor dostoevsky" format:pdf`, want: []string{"Notes from Underground"}},
+		{search: "lang:en", want: []string{"Resurrection"}},
+		{search: "year:1850..1880", want: []string{"Demons"}},
+		{search: "year:>1880", want: []string{"Resurrection"}},
+		{search: "size:>5MB", want: []string{"Demons"}},
+		{search: "size:<1mb", want: []string{"Notes from Underground"}},
+		{search: "series:novels publisher:penguin"},
+		{search: "", want: []string{"Resurrection", "Demons", "Notes from Underground"}},
+	}
+
+	for _, tc := range testCases {
+		s, err := search.Parse(tc.search)
+		if err != nil {
+			t.Errorf("Parse(%q): got error:%s", tc.search, err)
+			continue
+		}
+		var got []string
+		for _, b := range books {
+			if s.Match(b) {
+				got = append(got, b.Title)
+			}
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Parse(%q).Match(): mismatch (-want +got):\n%s", tc.search, diff)
+		}
+	}
+}
+
+func TestParse_Text(t *testing.T) {
+	s, err := search.Parse(`war author:tolstoy "and peace" note:x`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(`war "and peace" note:x`, s.Text); diff != "" {
+		t.Errorf("Text: mismatch (-want +got):\n%s", diff)
+	}
+	if len(s.Terms) != 1 || s.Terms[0].Field != "author" || s.Terms[0].Value != "tolstoy" {
+		t.Errorf("Terms: got %+v", s.Terms)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, s := range []string{"year:abc", "year:1910..1890", "size:5XB", "author:", "isbn:abc", "isbn:-", "isbn:x"} {
+		if _, err := search.Parse(s); err == nil {
+			t.Errorf("Parse(%q): got no error", s)
+		}
+	}
+	if _, err := search.Parse("isbn:-"); err == nil || err.Error() != "isbn: needs digits" {
+		t.Errorf(`Parse("isbn:-"): got %v; want "isbn: needs digits"`, err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	tags := map[string][]string{
+		"unread":     {"/books/resurrection.epub", "/books/notes.pdf"},
+		"philosophy": {"/books/notes.pdf", "/books/demons.epub"},
+	}
+
+	type testCase struct {
+		query, search string
+		want          []string
+	}
+
+	testCases := []testCase{
+		{query: "unread and philosophy", want: []string{"Notes from Underground"}},
+		{query: "unread", search: "author:tolstoy", want: []string{"Resurrection"}},
+		{search: "philosophy and not unread", want: []string{"Demons"}},
+		{search: "notes", want: []string{"Notes from Underground"}},
+		// Books are found by their tags too, as in the filter.
+		{search: "philosoph", want: []string{"Demons", "Notes from Underground"}},
+		{want: []string{"Resurrection", "Demons", "Notes from Underground"}},
+	}
+
+	for _, tc := range testCases {
+		books, err := search.Select(books, tags, tc.query, tc.search)
+		if err != nil {
+			t.Errorf("Select(%q, %q): got error:%s", tc.query, tc.search, err)
+			continue
+		}
+		var got []string
+		for _, b := range books {
+			got = append(got, b.Title)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Select(%q, %q): mismatch (-want +got):\n%s", tc.query, tc.search, diff)
+		}
+	}
+}
+
+func TestSelection(t *testing.T) {
+	sel := search.Selection{}
+	sel.Toggle("author", "Fyodor Dostoevsky")
+
+	var got []string
+	for _, b := range sel.Narrow(books) {
+		got = append(got, b.Title)
+	}
+	if diff := cmp.Diff([]string{"Demons", "Notes from Underground"}, got); diff != "" {
+		t.Errorf("Narrow: mismatch (-want +got):\n%s", diff)
+	}
+
+	// The counts of a facet ignore its own selection, the other facets
+	// narrow them down.
+	wantAuthors := []search.FacetCount{{Value: "Fyodor Dostoevsky", Count: 2}, {Value: "Leo Tolstoy", Count: 1}}
+	if diff := cmp.Diff(wantAuthors, sel.Counts(books, "author")); diff != "" {
+		t.Errorf("Counts(author): mismatch (-want +got):\n%s", diff)
+	}
+	wantFormats := []search.FacetCount{{Value: "epub", Count: 1}, {Value: "pdf", Count: 1}}
+	if diff := cmp.Diff(wantFormats, sel.Counts(books, "format")); diff != "" {
+		t.Errorf("Counts(format): mismatch (-want +got):\n%s", diff)
+	}
+	wantDecades := []search.FacetCount{{Value: "1870s", Count: 1}}
+	if diff := cmp.Diff(wantDecades, sel.Counts(books, "decade")); diff != "" {
+		t.Errorf("Counts(decade): mismatch (-want +got):\n%s", diff)
+	}
+
+	sel.Toggle("author", "Fyodor Dostoevsky")
+	if len(sel) != 0 {
+		t.Errorf("Toggle twice: got %v, want an empty selection", sel)
+	}
+}
+
+func TestRank(t *testing.T) {
+	docs := [][]search.Field{
+		{{Text: "Demons", Weight: search.TitleWeight}, {Text: "Fyodor Dostoevsky", Weight: search.AuthorWeight}},
+		{{Text: "Dostoevsky: A Writer in His Time", Weight: search.TitleWeight}, {Text: "Joseph Frank", Weight: search.AuthorWeight}},
+		{{Text: "Les Misérables", Weight: search.TitleWeight}, {Text: "Victor Hugo", Weight: search.AuthorWeight}},
+		{{Text: "dostoevsky", Weight: search.TagWeight}},
+	}
+
+	type testCase struct {
+		query string
+		want  []int
+	}
+
+	testCases := []testCase{
+		// The title beats the author, which beats the tag.
+		{query: "dostoevsky", want: []int{1, 0, 3}},
+		{query: "Dostoevskij", want: []int{1, 0, 3}},
+		{query: "miserables", want: []int{2}},
+		{query: "misreables hugo", want: []int{2}},
+		{query: "lsmsr", want: []int{2}},
+		{query: "dostoevsky frank", want: []int{1}},
+		{query: "tolstoy"},
+	}
+
+	for _, tc := range testCases {
+		var got []int
+		for _, m := range search.Rank(tc.query, docs) {
+			got = append(got, m.Index)
+		}
+		if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Rank(%q): mismatch (-want +got):\n%s", tc.query, diff)
+		}
+	}
+}
+
+func TestRank_Highlights(t *testing.T) {
+	docs := [][]search.Field{{{Text: "Les Misérables", Weight: 1}, {Text: "Victor Hugo", Weight: 1}}}
+	matches := search.Rank("MISER hugo", docs)
+	if len(matches) != 1 {
+		t.Fatalf("want one match, got %+v", matches)
+	}
+	want := [][]int{{4, 5, 6, 7, 8}, {7, 8, 9, 10}}
+	if diff := cmp.Diff(want, matches[0].Highlights); diff != "" {
+		t.Errorf("Highlights: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFold(t *testing.T) {
+	for in, want := range map[string]string{"Dostoévski": "dostoevski", "Straße": "strasse", "Øresund": "oresund"} {
+		if got := search.Fold(in); got != want {
+			t.Errorf("Fold(%q): want %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestAddVirtualTags(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	library := []metadata.Book{
+		{Path: "/a.epub", Title: "The Idiot", Authors: []string{"Fyodor Dostoevsky"}, Added: now.AddDate(0, 0, -1)},
+		{Path: "/b.epub", Title: "Idiot", Authors: []string{"Dostoevsky, Fyodor"}, Added: now.AddDate(0, 0, -30)},
+		{Path: "/c.epub", Title: "c.epub"},
+		{Path: "/d.epub", Title: "Demons", Authors: []string{"Fyodor Dostoevsky"}, Identifiers: []metadata.Identifier{{Scheme: "ISBN", Value: "978-0-14-044141-5"}}},
+		{Path: "/e.epub", Title: "Besy", Authors: []string{"F. M. Dostoevsky"}, Identifiers: []metadata.Identifier{{Value: "urn:isbn:9780140441415"}}},
+	}
+	// Another tool stored @home on B and @untagged on E.
+	tags := map[string][]string{
+		"novel":         {"/a.epub", "/d.epub"},
+		"@home":         {"/b.epub"},
+		search.Untagged: {"/e.epub"},
+	}
+	shadowed := search.AddVirtualTags(tags, library, now)
+
+	if diff := cmp.Diff([]string{search.Untagged}, shadowed); diff != "" {
+		t.Errorf("AddVirtualTags: shadowed tags mismatch (-want +got):\n%s", diff)
+	}
+	want := map[string][]string{
+		"novel":           {"/a.epub", "/d.epub"},
+		"@home":           {"/b.epub"},
+		search.Untagged:   {"/c.epub"},
+		search.Recent:     {"/a.epub"},
+		search.Incomplete: {"/c.epub"},
+		search.Duplicates: {"/a.epub", "/b.epub", "/d.epub", "/e.epub"},
+	}
+	if diff := cmp.Diff(want, tags); diff != "" {
+		t.Errorf("AddVirtualTags: mismatch (-want +got):\n%s", diff)
+	}
+
+	// Virtual tags combine with real ones in queries.
+	got, err := search.Select(library, tags, "@duplicates and not novel", "")
+	if err != nil {
+		t.Fatalf("Select: got error:%s", err)
+	}
+	var paths []string
+	for _, b := range got {
+		paths = append(paths, b.Path)
+	}
+	if diff := cmp.Diff([]string{"/b.epub", "/e.epub"}, paths); diff != "" {
+		t.Errorf("Select: mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOrderTags(t *testing.T) {
+	tags := []string{"poetry", "@recent", "Essays", "fiction", "drama", "@untagged"}
+	counts := map[string]int{"poetry": 1, "Essays": 3, "fiction": 3, "drama": 5, "@recent": 2}
+	pinned := []string{"fiction", "missing"}
+	manual := []string{"poetry", "@recent", "drama"}
+	tests := []struct {
+		order search.TagOrder
+		want  []string
+	}{
+		{search.TagsAlpha, []string{"fiction", "@recent", "@untagged", "Essays", "drama", "poetry"}},
+		{search.TagsByCount, []string{"fiction", "@recent", "@untagged", "drama", "Essays", "poetry"}},
+		{search.TagsManual, []string{"fiction", "poetry", "@recent", "drama", "@untagged", "Essays"}},
+	}
+	for _, tt := range tests {
+		got := search.OrderTags(tags, tt.order, pinned, manual, counts, nil)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("OrderTags(%s): mismatch (-want +got):\n%s", tt.order, diff)
+		}
+	}
+}
+
+func TestParseTagOrder(t *testing.T) {
+	for in, want := range map[string]search.TagOrder{"": search.TagsAlpha, "Count": search.TagsByCount, "manual": search.TagsManual} {
+		got, err := search.ParseTagOrder(in)
+		if err != nil || got != want {
+			t.Errorf("ParseTagOrder(%q) = %s, %v; want %s", in, got, err, want)
+		}
+	}
+	if _, err := search.ParseTagOrder("random"); err == nil {
+		t.Error("ParseTagOrder(random): got no error")
+	}
+	if got := search.TagsManual.Next(); got != search.TagsAlpha {
+		t.Errorf("Next: got %s, want alpha", got)
+	}
+}
+
+func TestCompleteTag(t *testing.T) {
+	counts := map[string]int{
+		"fiction":         4,
+		"science fiction": 9,
+		"nonfiction":      2,
+		"Fantasy":         1,
+		"favourites":      3,
+		"français":        5,
+		search.Recent:     8,
+	}
+	tests := []struct {
+		text    string
+		exclude []string
+		want    []string
+	}{
+		{"fi", nil, []string{"fiction", "science fiction", "nonfiction", "français", "favourites"}},
+		{"f", []string{"fiction"}, []string{"français", "favourites", "Fantasy", "science fiction", "nonfiction"}},
+		{"FRAN", nil, []string{"français"}},
+		{"fv", nil, []string{"favourites"}},
+		{"", []string{"science fiction", "français", "fiction", "favourites"}, []string{"nonfiction", "Fantasy"}},
+		{"xyz", nil, []string{}},
+	}
+	for _, tt := range tests {
+		got := search.CompleteTag(tt.text, counts, tt.exclude)
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("CompleteTag(%q): mismatch (-want +got):\n%s", tt.text, diff)
+		}
+	}
+}
+
+func TestTagShare(t *testing.T) {
+	paths := []string{"/books/a.epub", "/books/b.epub", "/books/c.epub"}
+	tags := map[string][]string{
+		"/books/a.epub": {"fiction", "russian"},
+		"/books/b.epub": {"fiction"},
+		"/books/c.epub": {"fiction", "unread"},
+		"/books/d.epub": {"russian"},
+	}
+	tests := []struct {
+		tag   string
+		paths []string
+		want  search.Share
+		count int
+	}{
+		{"fiction", paths, search.ShareAll, 3},
+		{"russian", paths, search.ShareSome, 1},
+		{"unread", paths, search.ShareSome, 1},
+		{"poetry", paths, search.ShareNone, 0},
+		{"russian", nil, search.ShareNone, 0},
+	}
+	for _, tt := range tests {
+		got, count := search.TagShare(tt.tag, tt.paths, tags)
+		if got != tt.want || count != tt.count {
+			t.Errorf("TagShare(%q, %q): got %v, %d; want %v, %d", tt.tag, tt.paths, got, count, tt.want, tt.count)
+		}
+	}
+}
+
+func TestEditTags(t *testing.T) {
+	errRead := errors.New("cannot read")
+	errWrite := errors.New("cannot write")
+	paths := []string{"/books/a.epub", "/books/b.epub", "/books/unreadable.epub", "/books/readonly.epub"}
+	stored := map[string][]string{
+		"/books/a.epub":        {"fiction", "russian"},
+		"/books/b.epub":        {"unread"},
+		"/books/readonly.epub": {"fiction"},
+	}
+	read := func(path string) ([]string, error) {
+		if path == "/books/unreadable.epub" {
+			return nil, errRead
+		}
+		return stored[path], nil
+	}
+	tests := []struct {
+		tags    []string
+		add     bool
+		want    []search.TagEdit
+		written []string
+		changed int
+		failed  int
+	}{
+		{
+			tags: []string{"russian", "classic"},
+			add:  true,
+			want: []search.TagEdit{
+				{Path: "/books/a.epub", Before: []string{"fiction", "russian"}, After: []string{"fiction", "russian", "classic"}},
+				{Path: "/books/b.epub", Before: []string{"unread"}, After: []string{"unread", "russian", "classic"}},
+				{Path: "/books/unreadable.epub", Err: errRead},
+				{Path: "/books/readonly.epub", Before: []string{"fiction"}, After: []string{"fiction", "russian", "classic"}, Err: errWrite},
+			},
+			written: []string{"/books/a.epub", "/books/b.epub", "/books/readonly.epub"},
+			changed: 2,
+			failed:  2,
+		},
+		{
+			tags: []string{"fiction"},
+			add:  false,
+			want: []search.TagEdit{
+				{Path: "/books/a.epub", Before: []string{"fiction", "russian"}, After: []string{"russian"}},
+				{Path: "/books/b.epub", Before: []string{"unread"}, After: []string{"unread"}},
+				{Path: "/books/unreadable.epub", Err: errRead},
+				{Path: "/books/readonly.epub", Before: []string{"fiction"}, After: []string{}, Err: errWrite},
+			},
+			// Books left unchanged are not written.
+			written: []string{"/books/a.epub", "/books/readonly.epub"},
+			changed: 1,
+			failed:  2,
+		},
+	}
+	for _, tt := range tests {
+		var written []string
+		write := func(path string, tags []string) error {
+			written = append(written, path)
+			if path == "/books/readonly.epub" {
+				return errWrite
+			}
+			return nil
+		}
+		got := search.EditTags(paths, tt.tags, tt.add, read, write)
+		if diff := cmp.Diff(tt.want, got, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("EditTags(%q, %v): mismatch (-want +got):\n%s", tt.tags, tt.add, diff)
+		}
+		if diff := cmp.Diff(tt.written, written); diff != "" {
+			t.Errorf("EditTags(%q, %v): written books mismatch (-want +got):\n%s", tt.tags, tt.add, diff)
+		}
+		changed, failed := search.CountEdits(got)
+		if changed != tt.changed || failed != tt.failed {
+			t.Errorf("CountEdits(EditTags(%q, %v)): got %d changed, %d failed; want %d, %d", tt.tags, tt.add, changed, failed, tt.changed, tt.failed)
+		}
+	}
+}
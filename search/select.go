@@ -0,0 +1,81 @@
+package search
+
+import (
+	"fmt"
+
+	"Bonalioteko/metadata"
+	"Bonalioteko/query"
+)
+
+// TagQuery returns the tag query written in text, if text is made of known
+// tags combined with query operators such as `philosophy and not unread`.
+// Other text is left to fuzzy matching.
+func TagQuery(text string, tags map[string][]string) (query.Node, bool) {
+	node, err := query.Parse(text)
+	if err != nil || node == nil || !query.HasOperators(text) {
+		return nil, false
+	}
+	for _, tag := range query.Tags(node) {
+		if _, ok := tags[tag]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// Select returns the books of library matching both the tag query and the
+// search, keeping the library order. It is how saved collections are
+// evaluated, so that they follow the library as it changes.
+func Select(library []metadata.Book, tags map[string][]string, tagQuery, text string) ([]metadata.Book, error) {
+	node, err := query.Parse(tagQuery)
+	if err != nil {
+		return nil, fmt.Errorf("query %q: %w", tagQuery, err)
+	}
+	s, err := Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("search %q: %w", text, err)
+	}
+
+	books := library
+	if node != nil {
+		books = metadata.WithPaths(books, query.Eval(node, tags, metadata.Paths(books)))
+	}
+
+	var matching []metadata.Book
+	for _, b := range books {
+		if s.Match(b) {
+			matching = append(matching, b)
+		}
+	}
+	books = matching
+
+	if s.Text == "" {
+		return books, nil
+	}
+	if node, ok := TagQuery(s.Text, tags); ok {
+		return metadata.WithPaths(books, query.Eval(node, tags, metadata.Paths(books))), nil
+	}
+
+	bookTags := BookTags(tags)
+	docs := make([][]Field, len(books))
+	for i, b := range books {
+		docs[i] = BookFields(b, bookTags[b.Path])
+	}
+	keep := make(map[int]bool)
+	for _, m := range Rank(s.Text, docs) {
+		keep[m.Index] = true
+	}
+	var found []metadata.Book
+	for i, b := range books {
+		if keep[i] {
+			found = append(found, b)
+		}
+	}
+	return found, nil
+}
+
+// Check reports the syntax errors of a tag query and a search.
+func Check(tagQuery, text string) error {
+	_, err := Select(nil, nil, tagQuery, text)
+	return err
+}
@@ -0,0 +1,105 @@
+package search
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TagOrder is the order of the tags of the tag bar.
+type TagOrder string
+
+const (
+	// TagsAlpha orders the tags alphabetically.
+	TagsAlpha TagOrder = "alpha"
+	// TagsByCount puts the tags carried by the most books first.
+	TagsByCount TagOrder = "count"
+	// TagsManual keeps the tags in the order they were moved into.
+	TagsManual TagOrder = "manual"
+)
+
+// TagOrders lists the tag orders in the order they are cycled through.
+var TagOrders = []TagOrder{TagsAlpha, TagsByCount, TagsManual}
+
+// ParseTagOrder parses a tag order. An empty string is the alphabetical
+// order.
+func ParseTagOrder(s string) (TagOrder, error) {
+	o := TagOrder(strings.ToLower(strings.TrimSpace(s)))
+	if o == "" {
+		return TagsAlpha, nil
+	}
+	if !slices.Contains(TagOrders, o) {
+		return TagsAlpha, fmt.Errorf("unknown tag order %q", s)
+	}
+	return o, nil
+}
+
+// Next returns the tag order following o.
+func (o TagOrder) Next() TagOrder {
+	i := slices.Index(TagOrders, o)
+	return TagOrders[(i+1)%len(TagOrders)]
+}
+
+// OrderTags returns the tags in the order of the tag bar. The pinned tags
+// come first, in the order they are listed in. In the manual order, the
+// tags listed in manual follow in that order. The other tags come last:
+// virtual tags first, then by decreasing count in the count order, then
+// alphabetically according to compare, or byte-wise when it is nil.
+func OrderTags(tags []string, o TagOrder, pinned, manual []string, counts map[string]int, compare func(a, b string) int) []string {
+	if compare == nil {
+		compare = strings.Compare
+	}
+	pins, places := positions(pinned), positions(manual)
+	sorted := slices.Clone(tags)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		if c, ok := byPosition(pins, a, b); ok {
+			return c
+		}
+		if o == TagsManual {
+			if c, ok := byPosition(places, a, b); ok {
+				return c
+			}
+		}
+		if va, vb := IsVirtual(a), IsVirtual(b); va != vb {
+			if va {
+				return -1
+			}
+			return 1
+		}
+		if o == TagsByCount {
+			if c := cmp.Compare(counts[b], counts[a]); c != 0 {
+				return c
+			}
+		}
+		return compare(a, b)
+	})
+	return sorted
+}
+
+// positions maps the tags of list to their position in it.
+func positions(list []string) map[string]int {
+	pos := make(map[string]int, len(list))
+	for i, tag := range list {
+		if _, ok := pos[tag]; !ok {
+			pos[tag] = i
+		}
+	}
+	return pos
+}
+
+// byPosition compares a and b by their position in pos. Tags found in pos
+// come before the others; ok is false when neither is.
+func byPosition(pos map[string]int, a, b string) (c int, ok bool) {
+	pa, aOK := pos[a]
+	pb, bOK := pos[b]
+	switch {
+	case aOK && bOK:
+		return cmp.Compare(pa, pb), true
+	case aOK:
+		return -1, true
+	case bOK:
+		return 1, true
+	}
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package search
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"Bonalioteko/metadata"
+)
+
+// VirtualPrefix starts the names of the virtual tags. Virtual tags are
+// computed from the library every time it is read and never written to the
+// files. Only their names are reserved: other tags starting with it, which
+// other tools may store, are real tags like any other.
+const VirtualPrefix = "@"
+
+// Names of the virtual tags.
+const (
+	// Untagged books have no tag.
+	Untagged = VirtualPrefix + "untagged"
+	// Recent books were added in the last RecentDays days.
+	Recent = VirtualPrefix + "recent"
+	// Incomplete books lack a title or an author.
+	Incomplete = VirtualPrefix + "incomplete"
+	// Duplicates are books that look like another one of the library.
+	Duplicates = VirtualPrefix + "duplicates"
+)
+
+// RecentDays is how long books stay under the Recent virtual tag.
+const RecentDays = 7
+
+// VirtualTags lists the virtual tags.
+var VirtualTags = []string{Untagged, Recent, Incomplete, Duplicates}
+
+// IsVirtual reports whether tag is the name of a virtual tag.
+func IsVirtual(tag string) bool {
+	return slices.Contains(VirtualTags, tag)
+}
+
+// AddVirtualTags adds the books of the library to the virtual tags of tags,
+// which maps the real tags to the paths of their books. Virtual tags no
+// book has are left out, like real ones. Real tags named like a virtual tag
+// are replaced by it and returned, sorted, for the caller to report; their
+// books still count as tagged.
+func AddVirtualTags(tags map[string][]string, library []metadata.Book, now time.Time) (shadowed []string) {
+	tagged := make(map[string]bool)
+	for tag, paths := range tags {
+		for _, path := range paths {
+			tagged[path] = true
+		}
+		if IsVirtual(tag) {
+			delete(tags, tag)
+			shadowed = append(shadowed, tag)
+		}
+	}
+	slices.Sort(shadowed)
+
+	since := now.AddDate(0, 0, -RecentDays)
+	for _, b := range library {
+		if !tagged[b.Path] {
+			tags[Untagged] = append(tags[Untagged], b.Path)
+		}
+		if !b.Added.IsZero() && b.Added.After(since) {
+			tags[Recent] = append(tags[Recent], b.Path)
+		}
+		if incomplete(b) {
+			tags[Incomplete] = append(tags[Incomplete], b.Path)
+		}
+	}
+	if paths := duplicates(library); len(paths) > 0 {
+		tags[Duplicates] = paths
+	}
+	return shadowed
+}
+
+// incomplete reports whether the book has no title of its own or no author.
+func incomplete(b metadata.Book) bool {
+	title := strings.TrimSpace(b.Title)
+	return title == "" || title == filepath.Base(b.Path) || len(b.Authors) == 0
+}
+
+// duplicates returns the paths of the books sharing their ISBN, or their
+// title and first author, with another book.
+func duplicates(library []metadata.Book) []string {
+	groups := make(map[string][]string)
+	for _, b := range library {
+		var keys []string
+		if isbn := digits(b.ISBN()); isbn != "" {
+			keys = append(keys, "isbn:"+isbn)
+		}
+		if !incomplete(b) {
+			title := Fold(metadata.SortableTitle(b.Title, b.Language))
+			author := Fold(metadata.AuthorSortName(b.Authors[0]))
+			keys = append(keys, "book:"+strings.Join(strings.Fields(title), " ")+"\x00"+author)
+		}
+		for _, k := range keys {
+			groups[k] = append(groups[k], b.Path)
+		}
+	}
+
+	duplicated := make(map[string]bool)
+	for _, group := range groups {
+		if len(group) > 1 {
+			for _, path := range group {
+				duplicated[path] = true
+			}
+		}
+	}
+	var paths []string
+	for _, b := range library {
+		if duplicated[b.Path] {
+			paths = append(paths, b.Path)
+		}
+	}
+	return paths
+}
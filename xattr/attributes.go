@@ -0,0 +1,74 @@
+package xattr
+
+import (
+	"errors"
+
+	"github.com/pkg/xattr"
+)
+
+// attributePrefix namespaces the attributes Bonalioteko keeps next to the
+// xdg tags.
+const attributePrefix = "user.bonalioteko."
+
+// Names of the Bonalioteko attributes. The series and rating are the ones
+// imported from Calibre, and the override attributes the metadata fields
+// the user set in place of the ones of the file.
+const (
+	AttrSeries      = "series"
+	AttrSeriesIndex = "series_index"
+	AttrRating      = "rating"
+
+	AttrOverrideTitle       = "override.title"
+	AttrOverrideAuthor      = "override.author"
+	AttrOverrideSeries      = "override.series"
+	AttrOverrideSeriesIndex = "override.series_index"
+)
+
+// isNoAttr reports whether err means the attribute is not set on the file.
+func isNoAttr(err error) bool {
+	return errors.Is(err, xattr.ENOATTR)
+}
+
+// GetAttribute returns the Bonalioteko attribute name of the file. A missing
+// attribute is returned as an empty string.
+func GetAttribute(filePath string, name string) (string, error) {
+	value, err := xattr.Get(filePath, attributePrefix+name)
+	if err != nil {
+		if isNoAttr(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return string(value), nil
+}
+
+// SetAttribute sets the Bonalioteko attribute name of the file. An empty
+// value removes the attribute.
+func SetAttribute(filePath string, name string, value string) error {
+	if value == "" {
+		err := xattr.Remove(filePath, attributePrefix+name)
+		if err != nil && !isNoAttr(err) {
+			return err
+		}
+		return nil
+	}
+	return xattr.Set(filePath, attributePrefix+name, []byte(value))
+}
+
+// CopyAll copies every extended attribute of src to dst.
+func CopyAll(src string, dst string) error {
+	names, err := xattr.List(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		value, err := xattr.Get(src, name)
+		if err != nil {
+			return err
+		}
+		if err := xattr.Set(dst, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
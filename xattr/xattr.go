@@ -1,12 +1,12 @@
 package xattr
 
 import (
-	"io/fs"
 	"log"
-	"path/filepath"
+	"slices"
 	"strings"
 
 	"Bonalioteko/config"
+	"Bonalioteko/internal/walk"
 
 	"github.com/pkg/xattr"
 )
@@ -50,22 +50,8 @@ type TagManager struct {
 	Prefix string
 }
 
-func find(root, ext string) []string {
-	var filename []string
-	filepath.WalkDir(root, func(s string, d fs.DirEntry, e error) error {
-		if e != nil {
-			return e
-		}
-		if filepath.Ext(d.Name()) == ext {
-			filename = append(filename, s)
-		}
-		return nil
-	})
-	return filename
-}
-
 func GetXattrmap(directory string) map[string]string {
-	filelist := find(directory, ".epub")
+	filelist := walk.Find(directory, ".epub")
 	tags := make(map[string]string)
 
 	for _, actualname := range filelist {
@@ -74,46 +60,40 @@ func GetXattrmap(directory string) map[string]string {
 			log.Printf("error:%v", err)
 		}
 
-		if (string(value)) == "" {
-			tags[actualname] = "untagged"
-			continue
-		}
 		tags[actualname] = string(value)
 
 	}
 	return tags
 }
 
+// GetTagsFromPath returns the tags of the file, none when it has no tag
+// attribute.
 func GetTagsFromPath(filePath string) ([]string, error) {
 	tagsBytes, err := xattr.Get(filePath, prefix)
 	if err != nil {
 		// If the attribute doesn't exist, treat it as no tags, not as an error.
-		if strings.Contains(err.Error(), "no such attribute") { // Check for attribute not found error
-			// Changed to "" from untagged
-			return []string{"untagged"}, nil
+		if isNoAttr(err) {
+			return nil, nil
 		}
 		return nil, err
 	}
 
-	tagsString := string(tagsBytes)
-
-	if tagsString == "" {
-		return []string{"untagged"}, nil
+	var tags []string
+	for tag := range strings.SplitSeq(string(tagsBytes), ",") {
+		if tag != "" {
+			tags = append(tags, tag)
+		}
 	}
-	tags := strings.Split(tagsString, ",")
 	return tags, nil
 }
 
 func GetXattrMapFilePathToTag(directory string) map[string][]string {
-	filelist := find(directory, ".epub")
+	filelist := walk.Find(directory, ".epub")
 
 	fileToTag := make(map[string][]string)
 
 	for _, fileNames := range filelist {
 		tags, _ := GetTagsFromPath(fileNames)
-		if tags == nil {
-			tags = append(tags, "untagged")
-		}
 		addFileAndTag(fileNames, tags, fileToTag)
 	}
 	return fileToTag
@@ -124,7 +104,7 @@ func addFileAndTag(filePath string, tags []string, mymap map[string][]string) {
 }
 
 func GetXattrMapTagToFilePath(directory string) map[string][]string {
-	filelist := find(directory, ".epub")
+	filelist := walk.Find(directory, ".epub")
 	tagToFiles := make(map[string][]string)
 	for _, fileNames := range filelist {
 		tags, _ := GetTagsFromPath(fileNames)
@@ -138,12 +118,9 @@ func AddTagAndFile(filePath string, tags []string, mymap map[string][]string) {
 	for _, tag := range tags {
 		mymap[tag] = append(mymap[tag], filePath)
 	}
-
-	if len(tags) == 0 {
-		mymap["untagged"] = append(mymap["untagged"], filePath)
-	}
 }
 
+// GetUniqueTags returns the tags of the map, sorted.
 func GetUniqueTags(tagFiles map[string][]string) []string {
 	uniqueTags := []string{}
 	seenTags := make(map[string]bool)
@@ -154,6 +131,7 @@ func GetUniqueTags(tagFiles map[string][]string) []string {
 			seenTags[tag] = true
 		}
 	}
+	slices.Sort(uniqueTags)
 
 	return uniqueTags
 }
@@ -197,7 +175,7 @@ func GetUnion(setA []string, setB []string) []string {
 
 	hashsetA := CreateHashSet(setA)
 	for key := range hashsetA {
-		if key == "" || key == " " || key == "untagged" {
+		if key == "" || key == " " {
 			continue
 		}
 
@@ -208,7 +186,7 @@ func GetUnion(setA []string, setB []string) []string {
 		if _, exists := hashsetA[key]; exists {
 			continue
 		}
-		if key == "" || key == " " || key == "untagged" {
+		if key == "" || key == " " {
 			continue
 		}
 		result = append(result, key)
@@ -234,7 +212,7 @@ func Addtag(filepath string, newTags []byte) error {
 	}
 
 	currentString := string(existingTags)
-	if currentString == "" || currentString == "untagged" {
+	if currentString == "" {
 		return xattr.Set(filepath, prefix, newTags)
 	} else {
 
@@ -263,3 +241,16 @@ func RemoveTag(filepath string, tagToRemove string) error {
 
 	return xattr.Set(filepath, prefix, []byte(strings.Join(newTags, ",")))
 }
+
+// SetTags replaces the xattr tags of the file with tags. An empty list
+// removes the attribute.
+func SetTags(filepath string, tags []string) error {
+	if len(tags) == 0 {
+		err := xattr.Remove(filepath, prefix)
+		if err != nil && !isNoAttr(err) {
+			return err
+		}
+		return nil
+	}
+	return xattr.Set(filepath, prefix, []byte(strings.Join(tags, ",")))
+}
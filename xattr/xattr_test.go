@@ -184,6 +184,30 @@ func TestAddTag_TempOS(t *testing.T) {
 	}
 }
 
+func TestGetTagsFromPath_Untagged(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "untagged.epub")
+	os.WriteFile(testFile, []byte("dummy content"), 0o644)
+
+	got, err := xattr.GetTagsFromPath(testFile)
+	if err != nil {
+		t.Errorf("got error:%s", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("want no tags, got %q", got)
+	}
+
+	// A real tag named untagged is an ordinary tag.
+	xattrpkg.Set(testFile, "user.xdg.tags", []byte("untagged,,unread"))
+	got, _ = xattr.GetTagsFromPath(testFile)
+	if diff := cmp.Diff([]string{"untagged", "unread"}, got); diff != "" {
+		t.Error(diff)
+	}
+	if diff := cmp.Diff(map[string][]string{"untagged": {testFile}, "unread": {testFile}}, xattr.GetXattrMapTagToFilePath(tmpDir)); diff != "" {
+		t.Error(diff)
+	}
+}
+
 func TestRemoveTag_TempOS(t *testing.T) {
 	tmpDir := t.TempDir()
 	testFile := filepath.Join(tmpDir, "remove.epub")